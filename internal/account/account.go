@@ -0,0 +1,285 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"godra/internal/auth"
+	"godra/internal/database"
+	"godra/internal/metrics"
+)
+
+// Account management
+//
+// Users can change their password, attach an email address and verify it,
+// and reset a forgotten password through a link mailed to a verified
+// address. Changing or resetting the password signs the user out everywhere.
+
+var (
+	// Mail delivers reset and verification messages. Defaults to logging them.
+	Mail Mailer = &LogMailer{}
+
+	// ResetURL and VerifyURL are the links mailed to users; the token is
+	// added as the "token" query parameter. When empty the bare token is sent.
+	ResetURL  string
+	VerifyURL string
+
+	MinPasswordLength = 8
+)
+
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrWrongPassword   = errors.New("current password is incorrect")
+	ErrWeakPassword    = errors.New("password is too short")
+	ErrInvalidEmail    = errors.New("invalid email address")
+	ErrEmailTaken      = errors.New("email address already in use")
+	ErrNoEmail         = errors.New("no email address set")
+	ErrAlreadyVerified = errors.New("email address already verified")
+)
+
+// Get loads a user's account.
+func Get(ctx context.Context, userID uint) (*database.User, error) {
+	var user database.User
+	err := database.DB.WithContext(ctx).First(&user, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return &user, err
+}
+
+// ChangePassword sets a new password. The current one is required unless the
+// account has none yet (it was created through an identity provider). All
+// sessions are revoked and a new token pair is returned for the caller.
+func ChangePassword(ctx context.Context, userID uint, current, password string) (*database.User, *auth.TokenPair, error) {
+	user, err := Get(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.Password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(current)); err != nil {
+			return nil, nil, ErrWrongPassword
+		}
+	}
+	if err := setPassword(database.DB.WithContext(ctx), user, password); err != nil {
+		return nil, nil, err
+	}
+
+	id := fmt.Sprintf("%d", user.ID)
+	if err := auth.RevokeAllSessions(ctx, id, "Password changed"); err != nil {
+		metrics.Log.Error("Failed to revoke sessions after password change", "user_id", user.ID, "error", err)
+	}
+	metrics.Log.Info("Password changed", "user_id", user.ID)
+
+	tokens, err := auth.StartSession(ctx, id, user.Username, user.Role)
+	return user, tokens, err
+}
+
+// RequestReset mails a reset link when login (a username or email address)
+// belongs to an account with a verified email. It reports success either
+// way so callers can't probe which accounts exist.
+func RequestReset(ctx context.Context, login string) error {
+	login = strings.TrimSpace(login)
+	if login == "" {
+		return nil
+	}
+
+	var user database.User
+	err := database.DB.WithContext(ctx).
+		Where("username = ? OR email = ?", login, strings.ToLower(login)).
+		First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.Email == nil || user.EmailVerifiedAt == nil {
+		metrics.Log.Info("Password reset requested without a verified email", "user_id", user.ID)
+		return nil
+	}
+
+	token, err := issueToken(ctx, user.ID, PurposeReset, *user.Email, ResetTokenTTL)
+	if err != nil {
+		return err
+	}
+	deliver(Message{
+		To:      *user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password for %s.\n\n"+
+			"To choose a new password, use this link within %s:\n\n%s\n\n"+
+			"If it wasn't you, ignore this email; your password stays the same.\n",
+			user.Username, ResetTokenTTL, link(ResetURL, token)),
+	})
+	return nil
+}
+
+// ConfirmReset redeems a reset token and sets the new password. The user is
+// signed out everywhere and any login lockout is lifted.
+func ConfirmReset(ctx context.Context, token, password string) error {
+	if len(password) < MinPasswordLength {
+		return ErrWeakPassword
+	}
+
+	var user database.User
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		t, err := consumeToken(tx, token, PurposeReset)
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&user, t.UserID).Error; err != nil {
+			return ErrInvalidToken
+		}
+		// The link is void once the address it went to is no longer the user's
+		if user.Email == nil || *user.Email != t.Email {
+			return ErrInvalidToken
+		}
+		return setPassword(tx, &user, password)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := auth.RevokeAllSessions(ctx, fmt.Sprintf("%d", user.ID), "Password reset"); err != nil {
+		metrics.Log.Error("Failed to revoke sessions after password reset", "user_id", user.ID, "error", err)
+	}
+	auth.ResetLoginFailures(ctx, user.Username)
+	metrics.Log.Info("Password reset", "user_id", user.ID)
+	return nil
+}
+
+// SetEmail changes the user's email address and mails a verification link.
+// The new address is unverified until the link is used. Setting the current,
+// unverified address again sends a fresh link.
+func SetEmail(ctx context.Context, userID uint, address string) (*database.User, error) {
+	parsed, err := mail.ParseAddress(strings.TrimSpace(address))
+	if err != nil || parsed.Name != "" {
+		return nil, ErrInvalidEmail
+	}
+	email := strings.ToLower(parsed.Address)
+
+	user, err := Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Email != nil && *user.Email == email && user.EmailVerifiedAt != nil {
+		return nil, ErrAlreadyVerified
+	}
+
+	db := database.DB.WithContext(ctx)
+	var taken int64
+	if err := db.Model(&database.User{}).Where("email = ? AND id <> ?", email, userID).Count(&taken).Error; err != nil {
+		return nil, err
+	}
+	if taken > 0 {
+		return nil, ErrEmailTaken
+	}
+
+	err = db.Model(user).Updates(map[string]interface{}{"email": email, "email_verified_at": nil}).Error
+	if err != nil {
+		// Lost a race for the unique index
+		return nil, ErrEmailTaken
+	}
+	user.Email, user.EmailVerifiedAt = &email, nil
+
+	if err := sendVerification(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ResendVerification mails a new verification link for the current address.
+func ResendVerification(ctx context.Context, userID uint) error {
+	user, err := Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Email == nil {
+		return ErrNoEmail
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrAlreadyVerified
+	}
+	return sendVerification(ctx, user)
+}
+
+// VerifyEmail redeems a verification token.
+func VerifyEmail(ctx context.Context, token string) (*database.User, error) {
+	var user database.User
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		t, err := consumeToken(tx, token, PurposeVerify)
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&user, t.UserID).Error; err != nil {
+			return ErrInvalidToken
+		}
+		if user.Email == nil || *user.Email != t.Email {
+			return ErrInvalidToken
+		}
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		return tx.Model(&user).Update("email_verified_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	metrics.Log.Info("Email verified", "user_id", user.ID)
+	return &user, nil
+}
+
+func sendVerification(ctx context.Context, user *database.User) error {
+	token, err := issueToken(ctx, user.ID, PurposeVerify, *user.Email, VerifyTokenTTL)
+	if err != nil {
+		return err
+	}
+	deliver(Message{
+		To:      *user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("To confirm this address for %s, use this link within %s:\n\n%s\n",
+			user.Username, VerifyTokenTTL, link(VerifyURL, token)),
+	})
+	return nil
+}
+
+func setPassword(db *gorm.DB, user *database.User, password string) error {
+	if len(password) < MinPasswordLength {
+		return ErrWeakPassword
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	user.Password = string(hashed)
+	return db.Model(user).Update("password", user.Password).Error
+}
+
+// deliver sends mail in the background so slow mail servers don't hold up
+// requests, and so response times don't reveal whether an account exists.
+func deliver(msg Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := Mail.Send(ctx, msg); err != nil {
+			metrics.Log.Error("Failed to send mail", "to", msg.To, "subject", msg.Subject, "error", err)
+		}
+	}()
+}
+
+func link(base, token string) string {
+	if base == "" {
+		return token
+	}
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+	return base + sep + "token=" + url.QueryEscape(token)
+}
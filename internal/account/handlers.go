@@ -0,0 +1,223 @@
+package account
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"godra/internal/auth"
+	"godra/internal/database"
+	"godra/internal/metrics"
+	"godra/internal/social"
+)
+
+// AccountResponse is the caller's own account.
+type AccountResponse struct {
+	UserID          uint       `json:"user_id"`
+	Username        string     `json:"username"`
+	Role            string     `json:"role"`
+	Email           string     `json:"email,omitempty"`
+	EmailVerified   bool       `json:"email_verified"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	HasPassword     bool       `json:"has_password"`
+	TwoFactor       bool       `json:"two_factor_enabled"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+type PasswordRequest struct {
+	CurrentPassword string `json:"current_password"` // Not needed when the account has no password
+	NewPassword     string `json:"new_password"`
+}
+
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetRequest struct {
+	Login string `json:"login"` // Username or email address
+}
+
+type ConfirmResetRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type TokenRequest struct {
+	Token string `json:"token"`
+}
+
+// GetHandler returns the caller's account.
+func GetHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := Get(r.Context(), userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toResponse(user))
+}
+
+// ChangePasswordHandler changes the caller's password. Every session is
+// signed out and the caller gets a new token pair.
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	var req PasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, tokens, err := ChangePassword(r.Context(), userID, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(auth.AuthResponse{
+		TokenPair: tokens,
+		Username:  user.Username,
+		UserID:    user.ID,
+		Role:      user.Role,
+	})
+}
+
+// SetEmailHandler sets the caller's email address and sends a verification link.
+func SetEmailHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := SetEmail(r.Context(), userID, req.Email)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toResponse(user))
+}
+
+// ResendVerificationHandler mails a new verification link.
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := ResendVerification(r.Context(), userID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// VerifyEmailHandler redeems a verification token. It needs no login, so the
+// link works from any device.
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var req TokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Token required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := VerifyEmail(r.Context(), req.Token)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toResponse(user))
+}
+
+// RequestResetHandler mails a password reset link. It always answers 202,
+// whether or not the account exists.
+func RequestResetHandler(w http.ResponseWriter, r *http.Request) {
+	var req ResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := RequestReset(r.Context(), req.Login); err != nil {
+		metrics.Log.Error("Password reset request failed", "error", err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ConfirmResetHandler sets a new password with a reset token. The user then
+// logs in with the new password.
+func ConfirmResetHandler(w http.ResponseWriter, r *http.Request) {
+	var req ConfirmResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Token required", http.StatusBadRequest)
+		return
+	}
+
+	if err := ConfirmReset(r.Context(), req.Token, req.NewPassword); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toResponse(user *database.User) AccountResponse {
+	resp := AccountResponse{
+		UserID:          user.ID,
+		Username:        user.Username,
+		Role:            user.Role,
+		EmailVerified:   user.EmailVerifiedAt != nil,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		HasPassword:     user.Password != "",
+		TwoFactor:       user.TOTPEnabledAt != nil,
+		CreatedAt:       user.CreatedAt,
+	}
+	if user.Email != nil {
+		resp.Email = *user.Email
+	}
+	return resp
+}
+
+func callerID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	claims := auth.ClaimsFromContext(r.Context())
+	id, err := social.ParseUserID(claims.UserID)
+	if err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return 0, false
+	}
+	return id, true
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrWrongPassword):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrWeakPassword):
+		http.Error(w, "Password must be at least "+strconv.Itoa(MinPasswordLength)+" characters", http.StatusBadRequest)
+	case errors.Is(err, ErrInvalidEmail), errors.Is(err, ErrInvalidToken), errors.Is(err, ErrNoEmail):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrEmailTaken), errors.Is(err, ErrAlreadyVerified):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		metrics.Log.Error("Account request failed", "error", err)
+		http.Error(w, "Account request failed", http.StatusInternalServerError)
+	}
+}
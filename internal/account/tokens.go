@@ -0,0 +1,92 @@
+package account
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"godra/internal/database"
+)
+
+// Account tokens
+//
+// Password reset and email verification links carry a random token. Only its
+// SHA-256 hash is stored, each token works once, and issuing a new one
+// invalidates the user's earlier unused tokens for the same purpose.
+
+const (
+	PurposeReset  = "password_reset"
+	PurposeVerify = "email_verify"
+)
+
+var (
+	ResetTokenTTL  = time.Hour
+	VerifyTokenTTL = 24 * time.Hour
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// issueToken creates a token for userID and returns it in the clear, to be mailed.
+func issueToken(ctx context.Context, userID uint, purpose, email string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&database.AccountToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("used_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&database.AccountToken{
+			TokenHash: hashToken(token),
+			UserID:    userID,
+			Purpose:   purpose,
+			Email:     email,
+			ExpiresAt: now.Add(ttl),
+		}).Error
+	})
+	return token, err
+}
+
+// consumeToken marks a token used and returns it. The conditional update
+// makes sure two concurrent requests can't both redeem the same token.
+func consumeToken(tx *gorm.DB, token, purpose string) (*database.AccountToken, error) {
+	var t database.AccountToken
+	err := tx.Where("token_hash = ? AND purpose = ?", hashToken(token), purpose).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.UsedAt != nil || time.Now().After(t.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	res := tx.Model(&database.AccountToken{}).
+		Where("id = ? AND used_at IS NULL", t.ID).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrInvalidToken
+	}
+	return &t, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"godra/internal/auth"
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+)
+
+// RateLimit allows limit requests per client IP in each window, counted in
+// Redis under ratelimit:<name>:<ip> so every node shares the budget. A limit
+// of 0 disables it.
+func RateLimit(name string, limit int, window time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			key := "ratelimit:" + name + ":" + auth.ClientIP(r)
+
+			count, err := gamestate.RDB.Incr(ctx, key).Result()
+			if err != nil {
+				// Fail open: a Redis outage shouldn't take the endpoint down with it
+				metrics.Log.Error("Rate limit check failed", "limit", name, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if count == 1 {
+				gamestate.RDB.Expire(ctx, key, window)
+			}
+
+			if count > int64(limit) {
+				metrics.RateLimited.Add(1)
+				retry := window
+				if ttl, err := gamestate.RDB.TTL(ctx, key).Result(); err == nil && ttl > 0 {
+					retry = ttl
+				} else if err == nil {
+					// The expiry was lost (e.g. the first request failed half way)
+					gamestate.RDB.Expire(ctx, key, window)
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,9 +2,12 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	"godra/internal/apikeys"
 	"godra/internal/auth"
+	"godra/internal/database"
 	"godra/internal/gamestate"
 )
 
@@ -12,20 +15,27 @@ type RPCRequest struct {
 	Script string        `json:"script"`
 	Args   []interface{} `json:"args"`
 	Keys   []string      `json:"keys"`
+	UserID string        `json:"user_id,omitempty"` // API keys only: the user the call acts for
 }
 
 type RPCResponse struct {
 	Result interface{} `json:"result"`
 }
 
+// RPCHandler runs a script for a user token, or for a server API key sent in
+// the X-API-Key header.
 func RPCHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
 	// Auth first
-	tokenString := r.Header.Get("Authorization")
-	if len(tokenString) > 7 && tokenString[:7] == "Bearer " {
-		tokenString = tokenString[7:]
+	var key *database.APIKey
+	var claims *auth.Claims
+	var err error
+	if raw := r.Header.Get("X-API-Key"); raw != "" {
+		key, err = apikeys.Authenticate(ctx, raw)
+	} else {
+		claims, err = auth.ValidateToken(auth.TokenFromRequest(r))
 	}
-
-	claims, err := auth.ValidateToken(tokenString)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -43,26 +53,57 @@ func RPCHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify Permissions
-	requiredRole := gamestate.GetScriptRole(req.Script)
-	// Role Hierarchy: manager > player
-	// Simple check: if required is manager, user must be manager.
-	// If required is player, anyone (who is authenticated) access.
-	// If it's guest, anyone.
-	if requiredRole == "manager" && claims.Role != "manager" {
-		http.Error(w, "Forbidden: Manager role required", http.StatusForbidden)
+	// Verify Permissions: the key's scripts, or the script's ROLE / PERMISSION / INTERNAL metadata
+	if key != nil {
+		err = apikeys.Authorize(key, req.Script)
+	} else {
+		err = gamestate.AuthorizeScript(claims.Role, req.Script)
+	}
+	if err != nil {
+		if key != nil {
+			apikeys.RecordCall(ctx, key, req.Script, req.UserID, err)
+		}
+		switch {
+		case errors.Is(err, gamestate.ErrScriptNotFound):
+			http.Error(w, "Script not found", http.StatusNotFound)
+		default:
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		}
 		return
 	}
 
 	// Inject UserContext
 	// We prepend User ID to args to ensure scripts always know who is calling
 	// Convention: ARGV[1] is always User ID.
-	userID := claims.UserID
+	var userID string
+	switch {
+	case key == nil && req.UserID != "":
+		http.Error(w, "Forbidden: only API keys can act for another user", http.StatusForbidden)
+		return
+	case key == nil:
+		userID = claims.UserID
+	case req.UserID == "":
+		userID = apikeys.ServerUserID
+	default:
+		if err := apikeys.CheckUser(ctx, req.UserID); err != nil {
+			apikeys.RecordCall(ctx, key, req.Script, req.UserID, err)
+			if errors.Is(err, apikeys.ErrUnknownUser) {
+				http.Error(w, "User not found", http.StatusNotFound)
+			} else {
+				http.Error(w, "Failed to check user", http.StatusInternalServerError)
+			}
+			return
+		}
+		userID = req.UserID
+	}
 	finalArgs := append([]interface{}{userID}, req.Args...)
 
 	// Execute
 	// We now support custom KEYS via RPC if provided.
-	result, err := gamestate.ExecuteScript(r.Context(), req.Script, req.Keys, finalArgs...)
+	result, err := gamestate.ExecuteScript(ctx, req.Script, req.Keys, finalArgs...)
+	if key != nil {
+		apikeys.RecordCall(ctx, key, req.Script, userID, err)
+	}
 	if err != nil {
 		http.Error(w, "Script execution failed: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,245 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"godra/internal/database"
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+)
+
+// Server API keys
+//
+// Admins issue keys to trusted backends (match servers, a web backend) so
+// they can call scripts through /api/rpc without a user token. A key lists
+// the scripts it may call, and a call may name the user it acts for, who
+// becomes ARGV[1]. Keys are shown once and stored as SHA-256 hashes. Every
+// call is written to the audit log as "rpc.call" with the key as the actor
+// ("apikey:<id>").
+
+// ServerUserID is ARGV[1] for calls that don't act for a user.
+const ServerUserID = "server"
+
+const keyPrefix = "gk_"
+
+var (
+	ErrInvalidKey       = errors.New("invalid API key")
+	ErrNotFound         = errors.New("API key not found")
+	ErrRevoked          = errors.New("API key already revoked")
+	ErrNameRequired     = errors.New("name is required")
+	ErrNoScripts        = errors.New("at least one script is required")
+	ErrUnknownScript    = errors.New("unknown script")
+	ErrScriptNotAllowed = errors.New("script not allowed for this API key")
+	ErrUnknownUser      = errors.New("user not found")
+)
+
+// Create issues a key for scripts, valid for ttl (zero for no expiry). The
+// plain key is returned once; only its hash is kept.
+func Create(ctx context.Context, name string, scripts []string, ttl time.Duration, actor string) (*database.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", ErrNameRequired
+	}
+	scripts = cleanList(scripts)
+	if len(scripts) == 0 {
+		return nil, "", ErrNoScripts
+	}
+	for _, s := range scripts {
+		if _, ok := gamestate.LookupScript(s); s != "*" && !ok {
+			return nil, "", fmt.Errorf("%w: %s", ErrUnknownScript, s)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	plain := keyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	key := database.APIKey{
+		Name:      name,
+		Prefix:    plain[:len(keyPrefix)+6],
+		KeyHash:   hashKey(plain),
+		Scripts:   strings.Join(scripts, ","),
+		CreatedBy: actor,
+	}
+	if ttl > 0 {
+		expires := time.Now().Add(ttl)
+		key.ExpiresAt = &expires
+	}
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&key).Error; err != nil {
+			return err
+		}
+		return tx.Create(&database.AuditLog{
+			Actor:  actor,
+			Action: "apikey.create",
+			Target: keyRef(key.ID),
+			After:  key.Name + ": " + key.Scripts,
+		}).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	metrics.Log.Info("API key created", "id", key.ID, "name", key.Name, "actor", actor)
+	return &key, plain, nil
+}
+
+// List returns every key, newest first, revoked ones included.
+func List(ctx context.Context) ([]database.APIKey, error) {
+	var keys []database.APIKey
+	err := database.DB.WithContext(ctx).Order("id DESC").Find(&keys).Error
+	return keys, err
+}
+
+// Revoke stops a key from working.
+func Revoke(ctx context.Context, id uint, actor, reason string) (*database.APIKey, error) {
+	var key database.APIKey
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&key, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotFound
+			}
+			return err
+		}
+		if key.RevokedAt != nil {
+			return ErrRevoked
+		}
+		now := time.Now()
+		key.RevokedAt = &now
+		key.RevokedBy = actor
+		if err := tx.Model(&key).Updates(map[string]interface{}{"revoked_at": now, "revoked_by": actor}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&database.AuditLog{
+			Actor:  actor,
+			Action: "apikey.revoke",
+			Target: keyRef(key.ID),
+			Before: key.Name,
+			Reason: reason,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	metrics.Log.Info("API key revoked", "id", key.ID, "name", key.Name, "actor", actor)
+	return &key, nil
+}
+
+// Authenticate returns the active key matching plain.
+func Authenticate(ctx context.Context, plain string) (*database.APIKey, error) {
+	if !strings.HasPrefix(plain, keyPrefix) {
+		return nil, ErrInvalidKey
+	}
+	var key database.APIKey
+	err := database.DB.WithContext(ctx).Where("key_hash = ?", hashKey(plain)).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt)) {
+		return nil, ErrInvalidKey
+	}
+	return &key, nil
+}
+
+// Authorize checks that key may call script. Internal scripts must be listed
+// by name; "*" only covers the others.
+func Authorize(key *database.APIKey, script string) error {
+	policy, ok := gamestate.LookupScript(script)
+	if !ok {
+		return gamestate.ErrScriptNotFound
+	}
+	for _, allowed := range strings.Split(key.Scripts, ",") {
+		if allowed == script || (allowed == "*" && !policy.Internal) {
+			return nil
+		}
+	}
+	return ErrScriptNotAllowed
+}
+
+// CheckUser verifies the user a call acts for: a registered user or a
+// guest that still exists.
+func CheckUser(ctx context.Context, userID string) error {
+	if strings.HasPrefix(userID, "guest:") {
+		n, err := gamestate.RDB.Exists(ctx, userID).Result()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrUnknownUser
+		}
+		return nil
+	}
+
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return ErrUnknownUser
+	}
+	var user database.User
+	err = database.DB.WithContext(ctx).Select("id").First(&user, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrUnknownUser
+	}
+	return err
+}
+
+// RecordCall writes a call to the audit log and marks the key as used.
+// callErr is why the call was refused or the script failed, if it was.
+func RecordCall(ctx context.Context, key *database.APIKey, script, userID string, callErr error) {
+	entry := database.AuditLog{
+		Actor:  keyRef(key.ID),
+		Action: "rpc.call",
+		Target: userID,
+		After:  script,
+	}
+	if callErr != nil {
+		entry.After = script + " (failed)"
+		entry.Reason = callErr.Error()
+	}
+	db := database.DB.WithContext(ctx)
+	if err := db.Create(&entry).Error; err != nil {
+		metrics.Log.Error("Failed to audit API key call", "key_id", key.ID, "script", script, "error", err)
+	}
+
+	// Coarse, so busy keys don't write on every call
+	now := time.Now()
+	db.Model(&database.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", key.ID, now.Add(-time.Minute)).
+		Update("last_used_at", now)
+}
+
+func hashKey(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// keyRef names a key in the audit log.
+func keyRef(id uint) string { return "apikey:" + strconv.FormatUint(uint64(id), 10) }
+
+func cleanList(items []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item != "" && !seen[item] {
+			seen[item] = true
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,131 @@
+package apikeys
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"godra/internal/auth"
+	"godra/internal/database"
+	"godra/internal/metrics"
+)
+
+type CreateRequest struct {
+	Name      string   `json:"name"`
+	Scripts   []string `json:"scripts"`
+	ExpiresIn int      `json:"expires_in"` // Seconds; 0 for no expiry
+}
+
+type RevokeRequest struct {
+	Reason string `json:"reason"`
+}
+
+// KeyResponse describes a key. Key is only set when it was just created.
+type KeyResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Key        string     `json:"key,omitempty"`
+	Prefix     string     `json:"prefix"`
+	Scripts    []string   `json:"scripts"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy  string     `json:"revoked_by,omitempty"`
+}
+
+// CreateHandler issues a key. The plain key is in the response and nowhere
+// else. Needs the apikeys.manage permission.
+func CreateHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ExpiresIn < 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	actor := auth.ClaimsFromContext(r.Context()).UserID
+	key, plain, err := Create(r.Context(), req.Name, req.Scripts, time.Duration(req.ExpiresIn)*time.Second, actor)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	resp := toResponse(key)
+	resp.Key = plain
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ListHandler lists every key, newest first. Needs the apikeys.manage permission.
+func ListHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := List(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to load API keys", http.StatusInternalServerError)
+		return
+	}
+	resp := make([]KeyResponse, 0, len(keys))
+	for i := range keys {
+		resp = append(resp, toResponse(&keys[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// RevokeHandler revokes {keyID}. The body (a reason) is optional. Needs the
+// apikeys.manage permission.
+func RevokeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "keyID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid key ID", http.StatusBadRequest)
+		return
+	}
+	var req RevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	key, err := Revoke(r.Context(), uint(id), auth.ClaimsFromContext(r.Context()).UserID, req.Reason)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toResponse(key))
+}
+
+func toResponse(k *database.APIKey) KeyResponse {
+	return KeyResponse{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scripts:    strings.Split(k.Scripts, ","),
+		CreatedBy:  k.CreatedBy,
+		CreatedAt:  k.CreatedAt,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		RevokedAt:  k.RevokedAt,
+		RevokedBy:  k.RevokedBy,
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNameRequired), errors.Is(err, ErrNoScripts), errors.Is(err, ErrUnknownScript):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrRevoked):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		metrics.Log.Error("API key request failed", "error", err)
+		http.Error(w, "API key request failed", http.StatusInternalServerError)
+	}
+}
@@ -1,25 +1,33 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 
 	"godra/internal/database"
 	"godra/internal/gamestate"
+	"godra/internal/metrics"
+	"godra/internal/rbac"
 )
 
 type AuthRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
-	Role     string `json:"role"`
 }
 
 type AuthResponse struct {
-	Token    string `json:"token"`
+	*TokenPair
 	Username string `json:"username"`
 	UserID   uint   `json:"user_id"`
 	Role     string `json:"role"`
@@ -32,11 +40,6 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	role := "player"
-	if req.Role == "manager" {
-		role = "manager"
-	}
-
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -47,7 +50,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	user := database.User{
 		Username: req.Username,
 		Password: string(hashedPassword),
-		Role:     role,
+		Role:     DefaultRole, // Other roles are granted by an admin
 	}
 
 	if result := database.DB.Create(&user); result.Error != nil {
@@ -65,57 +68,521 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Locked out usernames and IPs are rejected before the password is checked
+	ip := ClientIP(r)
+	if wait := LoginLockedFor(r.Context(), req.Username, ip); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
+
 	var user database.User
 	if result := database.DB.Where("username = ?", req.Username).First(&user); result.Error != nil {
+		RecordLoginFailure(r.Context(), req.Username, ip, 0)
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
 	// Compare Hash
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		RecordLoginFailure(r.Context(), req.Username, ip, user.ID)
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	RespondLogin(w, r, &user, http.StatusOK)
+}
 
-	userID := fmt.Sprintf("%d", user.ID)
-	token, err := GenerateToken(userID, user.Username, user.Role)
+func GuestLoginHandler(w http.ResponseWriter, r *http.Request) {
+	// Simple Guest Login
+	// Generate random Guest ID
+	guestID := fmt.Sprintf("guest:%s", database.GenerateRandomString(8))
+
+	// Store in Redis (Temporary)
+	// We treat "guest:xyz" as a key with dummy value
+	gamestate.RDB.Set(r.Context(), guestID, "active", 24*time.Hour)
+
+	tokens, err := StartSession(r.Context(), guestID, "Guest", "guest")
 	if err != nil {
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
 	}
 
-	resp := AuthResponse{
-		Token:    token,
-		Username: user.Username,
-		UserID:   user.ID,
-		Role:     user.Role,
+	resp := map[string]interface{}{
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"user_id":       guestID,
+		"role":          "guest",
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-func GuestLoginHandler(w http.ResponseWriter, r *http.Request) {
-	// Simple Guest Login
-	// Generate random Guest ID
-	guestID := fmt.Sprintf("guest:%s", database.GenerateRandomString(8))
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
 
-	// Store in Redis (Temporary)
-	// We treat "guest:xyz" as a key with dummy value
-	gamestate.RDB.Set(r.Context(), guestID, "active", 24*time.Hour)
+// RefreshHandler exchanges a refresh token for a new token pair.
+func RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, ErrAccessDenied) {
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Error refreshing token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// LogoutHandler ends the caller's session. Mounted behind RequireAuth.
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if err := Logout(r.Context(), ClaimsFromContext(r.Context())); err != nil {
+		http.Error(w, "Error logging out", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type RevokeRequest struct {
+	Reason string `json:"reason"`
+}
+
+// RevokeSessionsHandler signs {userID} out of every session and closes their
+// sockets. Needs the sessions.revoke permission.
+func RevokeSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+
+	// The body is optional
+	var req RevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Only users of a lower role, so a manager can't sign out admins
+	role, err := roleOf(r.Context(), userID)
+	if errors.Is(err, ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error revoking sessions", http.StatusInternalServerError)
+		return
+	}
+	if caller := ClaimsFromContext(r.Context()).Role; role == caller || !rbac.HasRole(caller, role) {
+		http.Error(w, "Forbidden: can only revoke sessions of lower roles", http.StatusForbidden)
+		return
+	}
+
+	if err := RevokeAllSessions(r.Context(), userID, req.Reason); err != nil {
+		http.Error(w, "Error revoking sessions", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	token, err := GenerateToken(guestID, "Guest", "guest")
+// roleOf returns a user's current role. Guest IDs aren't accounts and are always guests.
+func roleOf(ctx context.Context, userID string) (string, error) {
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return "guest", nil
+	}
+	var user database.User
+	err = database.DB.WithContext(ctx).Select("role").First(&user, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrUserNotFound
+	}
+	return user.Role, err
+}
+
+// UpgradeHandler turns the calling guest into a registered user. Their lobby,
+// party, matchmaking and chat state moves to the new user ID, the guest's
+// sessions are revoked and a new token pair is returned. Mounted behind RequireAuth.
+func UpgradeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := ClaimsFromContext(ctx)
+	if !strings.HasPrefix(claims.UserID, "guest:") {
+		http.Error(w, "Only guests can upgrade", http.StatusBadRequest)
+		return
+	}
+
+	var req AuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" || req.Password == "" {
+		http.Error(w, "Username and password required", http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Error processing password", http.StatusInternalServerError)
+		return
+	}
+
+	user := database.User{
+		Username: req.Username,
+		Password: string(hashedPassword),
+		Role:     DefaultRole,
+		GuestID:  claims.UserID,
+	}
+	if result := database.DB.WithContext(ctx).Create(&user); result.Error != nil {
+		http.Error(w, "Error creating user (username might be taken)", http.StatusConflict)
+		return
+	}
+	userID := fmt.Sprintf("%d", user.ID)
+
+	if _, err := gamestate.ExecuteScript(ctx, "upgrade_guest", nil, claims.UserID, userID); err != nil {
+		database.DB.WithContext(ctx).Unscoped().Delete(&user)
+		http.Error(w, "Error migrating guest data", http.StatusInternalServerError)
+		return
+	}
+
+	// Old guest tokens and sockets stop working; the client reconnects with the new token
+	if err := RevokeAllSessions(ctx, claims.UserID, "Account upgraded"); err != nil {
+		metrics.Log.Error("Failed to revoke guest sessions", "guest_id", claims.UserID, "error", err)
+	}
+
+	tokens, err := StartSession(ctx, userID, user.Username, user.Role)
 	if err != nil {
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
 	}
 
-	resp := map[string]string{
-		"token":   token,
-		"user_id": guestID,
-		"role":    "guest",
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(AuthResponse{
+		TokenPair: tokens,
+		Username:  user.Username,
+		UserID:    user.ID,
+		Role:      user.Role,
+	})
+}
+
+type RoleRequest struct {
+	Role   string `json:"role"`
+	Reason string `json:"reason"`
+}
+
+type UserRoleResponse struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// ListRolesHandler returns the defined roles. Needs the roles.manage permission.
+func ListRolesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rbac.Names())
+}
+
+// SetRoleHandler grants {userID} a role. Needs the roles.manage permission.
+func SetRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var req RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Role == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	changeRole(w, r, req.Role, req.Reason)
+}
+
+// RevokeRoleHandler puts {userID} back to the default role. Needs the
+// roles.manage permission. The body (a reason) is optional.
+func RevokeRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var req RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	changeRole(w, r, DefaultRole, req.Reason)
+}
+
+func changeRole(w http.ResponseWriter, r *http.Request, role, reason string) {
+	userID, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	actor := ClaimsFromContext(r.Context()).UserID
+	user, err := SetRole(r.Context(), uint(userID), role, actor, reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUserNotFound):
+			http.Error(w, "User not found", http.StatusNotFound)
+		case errors.Is(err, ErrUnknownRole):
+			http.Error(w, "Unknown role", http.StatusBadRequest)
+		case errors.Is(err, ErrLastAdmin):
+			http.Error(w, "Cannot remove the last admin", http.StatusConflict)
+		default:
+			http.Error(w, "Error changing role", http.StatusInternalServerError)
+		}
+		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UserRoleResponse{UserID: user.ID, Username: user.Username, Role: user.Role})
+}
+
+type AuditEntry struct {
+	ID        uint      `json:"id"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	Before    string    `json:"before,omitempty"`
+	After     string    `json:"after,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditHandler lists audit entries, newest first. Filters: actor, action,
+// target, limit. Needs the audit.read permission.
+func AuditHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+
+	entries, err := ListAudit(r.Context(), AuditFilter{
+		Actor:  q.Get("actor"),
+		Action: q.Get("action"),
+		Target: q.Get("target"),
+		Limit:  limit,
+	})
+	if err != nil {
+		http.Error(w, "Failed to load audit log", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]AuditEntry, 0, len(entries))
+	for _, e := range entries {
+		resp = append(resp, AuditEntry{
+			ID:        e.ID,
+			Actor:     e.Actor,
+			Action:    e.Action,
+			Target:    e.Target,
+			Before:    e.Before,
+			After:     e.After,
+			Reason:    e.Reason,
+			CreatedAt: e.CreatedAt,
+		})
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+// TwoFactorLoginResponse is a login's token pair. RecoveryCodes is only set
+// when 2FA was set up during the login.
+type TwoFactorLoginResponse struct {
+	AuthResponse
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+type TwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token,omitempty"`
+	Code           string `json:"code"` // TOTP code or recovery code
+}
+
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	Required          bool `json:"required"` // The user's role must use 2FA
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TwoFactorLoginHandler is the second login step: a challenge token from the
+// first step and a code.
+func TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChallengeToken == "" {
+		http.Error(w, "Challenge token required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := ChallengeUser(ctx, req.ChallengeToken)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+
+	ip := ClientIP(r)
+	if wait := LoginLockedFor(ctx, user.Username, ip); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	recoveryCodes, err := CompleteChallenge(ctx, req.ChallengeToken, user, req.Code, ip)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	writeSession(w, r, user, http.StatusOK, recoveryCodes)
+}
+
+// TwoFactorLoginEnrollHandler returns a new TOTP secret for a user whose
+// challenge has enroll_required set. The first code then completes the login.
+func TwoFactorLoginEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChallengeToken == "" {
+		http.Error(w, "Challenge token required", http.StatusBadRequest)
+		return
+	}
+
+	enrollment, err := EnrollChallenge(r.Context(), req.ChallengeToken)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// TwoFactorStatusHandler reports the caller's 2FA state.
+func TwoFactorStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := accountID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := loadUser(r.Context(), userID)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TwoFactorStatus{
+		Enabled:           user.TOTPEnabledAt != nil,
+		Required:          RequiresTwoFactor(user.Role),
+		RecoveryCodesLeft: RecoveryCodesLeft(user),
+	})
+}
+
+// TwoFactorEnrollHandler returns a new TOTP secret for the caller.
+func TwoFactorEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := accountID(w, r)
+	if !ok {
+		return
+	}
+
+	enrollment, err := EnrollTwoFactor(r.Context(), userID)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// TwoFactorConfirmHandler turns on 2FA with a first code and returns the recovery codes.
+func TwoFactorConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	twoFactorCodes(w, r, ConfirmTwoFactor)
+}
+
+// RecoveryCodesHandler replaces the caller's recovery codes. Needs a current code.
+func RecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	twoFactorCodes(w, r, RegenerateRecoveryCodes)
+}
+
+func twoFactorCodes(w http.ResponseWriter, r *http.Request, fn func(context.Context, uint, string) ([]string, error)) {
+	userID, ok := accountID(w, r)
+	if !ok {
+		return
+	}
+
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := fn(r.Context(), userID, req.Code)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// TwoFactorDisableHandler turns off the caller's 2FA. Needs a current code.
+func TwoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := accountID(w, r)
+	if !ok {
+		return
+	}
+
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := DisableTwoFactor(r.Context(), userID, req.Code); err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetTwoFactorHandler removes {userID}'s 2FA and signs them out, for users
+// who lost their authenticator and recovery codes. Needs the roles.manage
+// permission. The body (a reason) is optional.
+func ResetTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	var req RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	actor := ClaimsFromContext(r.Context()).UserID
+	if err := ResetTwoFactor(r.Context(), uint(userID), actor, req.Reason); err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// accountID returns the caller's user ID. Guests have no account.
+func accountID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(ClaimsFromContext(r.Context()).UserID, 10, 64)
+	if err != nil {
+		http.Error(w, "Forbidden: guests have no account", http.StatusForbidden)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func writeTwoFactorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, ErrInvalidChallenge), errors.Is(err, ErrInvalidCode):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, ErrTwoFactorEnabled), errors.Is(err, ErrTwoFactorDisabled), errors.Is(err, ErrTwoFactorRequired):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		metrics.Log.Error("Two-factor request failed", "error", err)
+		http.Error(w, "Two-factor request failed", http.StatusInternalServerError)
+	}
+}
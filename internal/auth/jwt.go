@@ -1,48 +1,68 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-)
 
-var SecretKey = []byte("super-secret-key-change-me")
+	"godra/internal/database"
+)
 
 type Claims struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID, username, role string) (string, error) {
+// GenerateToken signs an access token. Use StartSession for logins so the
+// token comes with a refresh token.
+func GenerateToken(userID, username, role, sessionID string) (string, error) {
+	if current == nil {
+		return "", fmt.Errorf("no signing key configured")
+	}
+
 	claims := &Claims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
+		UserID:    userID,
+		Username:  username,
+		Role:      role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        database.GenerateRandomString(16),
+			Issuer:    issuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(SecretKey)
+	token := jwt.NewWithClaims(current.method, claims)
+	token.Header["kid"] = current.id
+	return token.SignedString(current.private)
 }
 
 func ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return SecretKey, nil
-	})
+	var opts []jwt.ParserOption
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
 
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keyFunc, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
 	}
-
-	return nil, fmt.Errorf("invalid token")
+	if err := checkRevoked(context.Background(), claims); err != nil {
+		return nil, err
+	}
+	if err := checkAccess(context.Background(), claims.UserID); err != nil {
+		return nil, err
+	}
+	return claims, nil
 }
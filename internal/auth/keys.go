@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"godra/internal/metrics"
+)
+
+// KeyConfig controls how tokens are signed and which keys verify them.
+type KeyConfig struct {
+	Algorithm      string // "HS256", "RS256" or "ES256"
+	Secret         string // HMAC secret for HS256
+	PrivateKeyFile string // PEM private key for RS256 / ES256
+	KeyID          string // kid of the signing key; derived from the key when empty
+	Issuer         string // iss claim set on issued tokens and required on verified ones
+	RandomSecret   bool   // Development only: use a random per-process HS256 secret when Secret is empty
+
+	// Retired keys still accepted for verification while their tokens expire.
+	// Entries are "kid=value": PEM files (public or private keys) in VerifyKeyFiles,
+	// HMAC secrets in VerifySecrets.
+	VerifyKeyFiles []string
+	VerifySecrets  []string
+}
+
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private interface{} // []byte, *rsa.PrivateKey or *ecdsa.PrivateKey
+	public  interface{} // []byte, *rsa.PublicKey or *ecdsa.PublicKey
+}
+
+var (
+	current  *signingKey
+	keysByID = map[string]*signingKey{}
+	issuer   string
+)
+
+// ConfigureKeys loads the signing and verification keys. Call before serving requests.
+func ConfigureKeys(cfg KeyConfig) error {
+	key, err := loadSigningKey(cfg)
+	if err != nil {
+		return err
+	}
+
+	byID := map[string]*signingKey{key.id: key}
+	for _, entry := range cfg.VerifyKeyFiles {
+		kid, path, ok := strings.Cut(entry, "=")
+		if !ok || kid == "" {
+			return fmt.Errorf("verification key %q: expected kid=path", entry)
+		}
+		k, err := loadPEMKey(path)
+		if err != nil {
+			return fmt.Errorf("verification key %s: %w", kid, err)
+		}
+		k.id = kid
+		k.private = nil // Only the current key signs
+		byID[kid] = k
+	}
+	for _, entry := range cfg.VerifySecrets {
+		kid, secret, ok := strings.Cut(entry, "=")
+		if !ok || kid == "" || secret == "" {
+			return fmt.Errorf("verification secret: expected kid=secret")
+		}
+		byID[kid] = &signingKey{id: kid, method: jwt.SigningMethodHS256, public: []byte(secret)}
+	}
+
+	current = key
+	keysByID = byID
+	issuer = cfg.Issuer
+	return nil
+}
+
+func loadSigningKey(cfg KeyConfig) (*signingKey, error) {
+	var key *signingKey
+
+	switch strings.ToUpper(cfg.Algorithm) {
+	case "", "HS256":
+		secret := []byte(cfg.Secret)
+		if len(secret) == 0 {
+			// Tokens wouldn't survive a restart and other nodes wouldn't accept them
+			if !cfg.RandomSecret {
+				return nil, errors.New("HS256 needs a secret (set JWT_SECRET, or -dev to use a random one)")
+			}
+			metrics.Log.Warn("No JWT secret configured, using a random one. Set JWT_SECRET in production.")
+			secret = make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, err
+			}
+		}
+		key = &signingKey{method: jwt.SigningMethodHS256, private: secret, public: secret}
+	case "RS256", "ES256":
+		if cfg.PrivateKeyFile == "" {
+			return nil, fmt.Errorf("%s needs a private key file", cfg.Algorithm)
+		}
+		var err error
+		if key, err = loadPEMKey(cfg.PrivateKeyFile); err != nil {
+			return nil, err
+		}
+		if key.private == nil {
+			return nil, errors.New("signing key file holds a public key")
+		}
+		if key.method.Alg() != strings.ToUpper(cfg.Algorithm) {
+			return nil, fmt.Errorf("key file is for %s, not %s", key.method.Alg(), cfg.Algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm: %s", cfg.Algorithm)
+	}
+
+	key.id = cfg.KeyID
+	if key.id == "" {
+		key.id = deriveKeyID(key)
+	}
+	return key, nil
+}
+
+// loadPEMKey reads an RSA or EC key, private or public, from a PEM file.
+func loadPEMKey(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{method: jwt.SigningMethodRS256, private: k, public: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &signingKey{method: jwt.SigningMethodRS256, public: k}, nil
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("ES256 needs a P-256 key")
+		}
+		return &signingKey{method: jwt.SigningMethodES256, private: k, public: &k.PublicKey}, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("ES256 needs a P-256 key")
+		}
+		return &signingKey{method: jwt.SigningMethodES256, public: k}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", parsed)
+}
+
+// deriveKeyID fingerprints the key so the same key always gets the same kid.
+// HMAC secrets are hashed, never exposed.
+func deriveKeyID(k *signingKey) string {
+	var material []byte
+	switch pub := k.public.(type) {
+	case []byte:
+		material = pub
+	default:
+		material, _ = x509.MarshalPKIXPublicKey(pub)
+	}
+	sum := sha256.Sum256(material)
+	return hex.EncodeToString(sum[:8])
+}
+
+// keyFunc picks the verification key by kid. Tokens without a kid are checked
+// against the current key.
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	key := current
+	if kid, ok := token.Header["kid"].(string); ok {
+		if key = keysByID[kid]; key == nil {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+	}
+	if key == nil {
+		return nil, errors.New("no signing keys configured")
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSHandler publishes the public verification keys as a JSON Web Key Set.
+// HMAC keys are secret and never listed.
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: []jwk{}}
+
+	// Current key first, then retired keys in kid order
+	ids := make([]string, 0, len(keysByID))
+	for id := range keysByID {
+		if current == nil || id != current.id {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if current != nil {
+		ids = append([]string{current.id}, ids...)
+	}
+
+	for _, id := range ids {
+		if entry, ok := toJWK(keysByID[id]); ok {
+			set.Keys = append(set.Keys, entry)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(set)
+}
+
+func toJWK(k *signingKey) (jwk, bool) {
+	enc := base64.RawURLEncoding.EncodeToString
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		return jwk{
+			Kty: "RSA", Kid: k.id, Use: "sig", Alg: k.method.Alg(),
+			N: enc(pub.N.Bytes()),
+			E: enc(big.NewInt(int64(pub.E)).Bytes()),
+		}, true
+	case *ecdsa.PublicKey:
+		ecdh, err := pub.ECDH()
+		if err != nil {
+			return jwk{}, false
+		}
+		// Uncompressed point: 0x04 || X || Y
+		point := ecdh.Bytes()
+		size := (len(point) - 1) / 2
+		return jwk{
+			Kty: "EC", Kid: k.id, Use: "sig", Alg: k.method.Alg(), Crv: "P-256",
+			X: enc(point[1 : 1+size]),
+			Y: enc(point[1+size:]),
+		}, true
+	}
+	return jwk{}, false
+}
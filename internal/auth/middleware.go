@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"godra/internal/rbac"
+)
+
+type contextKey struct{}
+
+// TokenFromRequest extracts the bearer token from the Authorization header.
+func TokenFromRequest(r *http.Request) string {
+	token := r.Header.Get("Authorization")
+	return strings.TrimPrefix(token, "Bearer ")
+}
+
+// RequireAuth rejects requests without a valid token and stores the claims in the request context.
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := ValidateToken(TokenFromRequest(r))
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextKey{}, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClaimsFromContext returns the claims stored by RequireAuth.
+func ClaimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(contextKey{}).(*Claims)
+	return claims
+}
+
+// RequireRole rejects requests whose role isn't role or one inheriting from it. Use after RequireAuth.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return require(rbac.Requirement{Roles: []string{role}})
+}
+
+// RequirePermission rejects requests whose role doesn't grant perm. Use after RequireAuth.
+func RequirePermission(perm string) func(http.Handler) http.Handler {
+	return require(rbac.Requirement{Permissions: []string{perm}})
+}
+
+func require(req rbac.Requirement) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := ClaimsFromContext(r.Context())
+			if claims == nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if err := rbac.Authorize(claims.Role, req); err != nil {
+				http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"godra/internal/database"
+	"godra/internal/metrics"
+	"godra/internal/rbac"
+)
+
+// Role assignment
+//
+// Registration always creates players. Other roles are granted by an admin
+// (PUT /api/admin/users/{userID}/role or the "godra role" command), and every
+// change is written to the audit log. Promotions apply from the user's next
+// token refresh; demotions sign the user out so elevated tokens stop working.
+
+const DefaultRole = "player"
+
+var (
+	ErrUnknownRole  = errors.New("unknown role")
+	ErrUserNotFound = errors.New("user not found")
+	ErrLastAdmin    = errors.New("cannot remove the last admin")
+)
+
+// SetRole changes a user's role. actor is the admin's user ID, or "cli" / "system".
+func SetRole(ctx context.Context, userID uint, role, actor, reason string) (*database.User, error) {
+	// Guests aren't accounts; the role only exists in guest tokens
+	if role == "guest" || !rbac.Exists(role) {
+		return nil, ErrUnknownRole
+	}
+
+	var user database.User
+	var previous string
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		previous = user.Role
+		if previous == role {
+			return nil
+		}
+
+		if previous == "admin" {
+			var admins int64
+			if err := tx.Model(&database.User{}).Where("role = ?", "admin").Count(&admins).Error; err != nil {
+				return err
+			}
+			if admins <= 1 {
+				return ErrLastAdmin
+			}
+		}
+
+		if err := tx.Model(&user).Update("role", role).Error; err != nil {
+			return err
+		}
+		return tx.Create(&database.AuditLog{
+			Actor:  actor,
+			Action: "role.change",
+			Target: fmt.Sprintf("%d", user.ID),
+			Before: previous,
+			After:  role,
+			Reason: reason,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if previous == role {
+		return &user, nil
+	}
+
+	metrics.Log.Info("Role changed", "user_id", user.ID, "from", previous, "to", role, "actor", actor)
+
+	// Tokens carry the role, so a demoted user must not keep the old ones
+	if !rbac.HasRole(role, previous) {
+		if err := RevokeAllSessions(ctx, fmt.Sprintf("%d", user.ID), "Role changed"); err != nil {
+			metrics.Log.Error("Failed to revoke sessions after role change", "user_id", user.ID, "error", err)
+		}
+	}
+	return &user, nil
+}
+
+// BootstrapAdmin makes sure an admin exists on first start. When there is
+// none, username is promoted, or created with password if it doesn't exist.
+func BootstrapAdmin(ctx context.Context, username, password string) error {
+	db := database.DB.WithContext(ctx)
+
+	var admins int64
+	if err := db.Model(&database.User{}).Where("role = ?", "admin").Count(&admins).Error; err != nil {
+		return err
+	}
+	if admins > 0 {
+		return nil
+	}
+
+	var user database.User
+	err := db.Where("username = ?", username).First(&user).Error
+	if err == nil {
+		_, err = SetRole(ctx, user.ID, "admin", "system", "Bootstrap admin")
+		return err
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if password == "" {
+		return fmt.Errorf("bootstrap admin %q doesn't exist and no password is set", username)
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		user = database.User{Username: username, Password: string(hashed), Role: "admin"}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		metrics.Log.Info("Created bootstrap admin", "user_id", user.ID, "username", username)
+		return tx.Create(&database.AuditLog{
+			Actor:  "system",
+			Action: "role.change",
+			Target: fmt.Sprintf("%d", user.ID),
+			After:  "admin",
+			Reason: "Bootstrap admin",
+		}).Error
+	})
+}
+
+// Registration used to accept "role": "manager", so anyone could make
+// themselves a manager. DemoteSelfGrantedManagers finds the managers no admin
+// ever promoted (no role.change entry granting it) and makes them players
+// again. It runs once: the pass is recorded as a role.audit entry.
+func DemoteSelfGrantedManagers(ctx context.Context) error {
+	db := database.DB.WithContext(ctx)
+
+	var done int64
+	if err := db.Model(&database.AuditLog{}).Where("action = ?", "role.audit").Count(&done).Error; err != nil {
+		return err
+	}
+	if done > 0 {
+		return nil
+	}
+
+	var managers []database.User
+	granted := db.Model(&database.AuditLog{}).Select("target").Where("action = ? AND after = ?", "role.change", "manager")
+	if err := db.Where("role = ? AND CAST(id AS TEXT) NOT IN (?)", "manager", granted).Find(&managers).Error; err != nil {
+		return err
+	}
+	for _, u := range managers {
+		metrics.Log.Warn("Demoting self-granted manager", "user_id", u.ID, "username", u.Username)
+		if _, err := SetRole(ctx, u.ID, DefaultRole, "system", "Manager role was self-granted at registration"); err != nil {
+			return err
+		}
+	}
+
+	return db.Create(&database.AuditLog{
+		Actor:  "system",
+		Action: "role.audit",
+		Reason: fmt.Sprintf("Demoted %d self-granted managers", len(managers)),
+	}).Error
+}
+
+// AuditFilter narrows ListAudit. Empty fields match everything.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Limit  int
+}
+
+// ListAudit returns audit entries, newest first.
+func ListAudit(ctx context.Context, f AuditFilter) ([]database.AuditLog, error) {
+	q := database.DB.WithContext(ctx).Order("id DESC")
+	if f.Actor != "" {
+		q = q.Where("actor = ?", f.Actor)
+	}
+	if f.Action != "" {
+		q = q.Where("action = ?", f.Action)
+	}
+	if f.Target != "" {
+		q = q.Where("target = ?", f.Target)
+	}
+	if f.Limit <= 0 || f.Limit > 500 {
+		f.Limit = 100
+	}
+
+	var entries []database.AuditLog
+	err := q.Limit(f.Limit).Find(&entries).Error
+	return entries, err
+}
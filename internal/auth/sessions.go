@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"godra/internal/database"
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+)
+
+// Sessions
+//
+// A login starts a session: a short-lived access token (JWT) plus a refresh
+// token stored hashed in the database. Refreshing rotates the refresh token;
+// presenting a refresh token that was already rotated revokes the whole
+// session, since it means the token leaked.
+//
+// Access tokens can't be recalled, so ValidateToken checks a revocation list in Redis:
+//
+//	revoked:jti:<jti>   a single access token (logout), kept until it would expire
+//	revoked:user:<uid>  unix time; tokens issued at or before it are rejected (revoke all)
+
+var (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrTokenRevoked        = errors.New("token revoked")
+	ErrAccessDenied        = errors.New("access denied")
+)
+
+// AccessCheck, when set, can refuse a user at login, on refresh and on every
+// token validation, for example because they are banned. Errors matching
+// ErrAccessDenied are shown to the user.
+var AccessCheck func(ctx context.Context, userID string) error
+
+func checkAccess(ctx context.Context, userID string) error {
+	if AccessCheck == nil {
+		return nil
+	}
+	return AccessCheck(ctx, userID)
+}
+
+type TokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Seconds until the access token expires
+}
+
+// StartSession issues the first token pair of a new session.
+func StartSession(ctx context.Context, userID, username, role string) (*TokenPair, error) {
+	return issueTokens(ctx, database.GenerateRandomString(16), userID, username, role)
+}
+
+func issueTokens(ctx context.Context, sessionID, userID, username, role string) (*TokenPair, error) {
+	refresh, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	row := database.RefreshToken{
+		TokenHash: hashToken(refresh),
+		SessionID: sessionID,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}
+	if err := database.DB.WithContext(ctx).Create(&row).Error; err != nil {
+		return nil, err
+	}
+
+	access, err := GenerateToken(userID, username, role, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{Token: access, RefreshToken: refresh, ExpiresIn: int(AccessTokenTTL.Seconds())}, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair. The username and
+// role are reloaded so changes apply from the next refresh.
+func Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	db := database.DB.WithContext(ctx)
+
+	var row database.RefreshToken
+	if err := db.Where("token_hash = ?", hashToken(refreshToken)).First(&row).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	if row.RevokedAt != nil {
+		// A rotated token came back: someone else has a copy. End the session.
+		metrics.Log.Warn("Refresh token reused, revoking session", "user_id", row.UserID, "session_id", row.SessionID)
+		revokeSession(ctx, row.SessionID)
+		return nil, ErrInvalidRefreshToken
+	}
+	if time.Now().After(row.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	username, role, err := lookupUser(ctx, row.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAccess(ctx, row.UserID); err != nil {
+		return nil, err
+	}
+
+	// Rotate: only one refresher wins if the same token is sent twice at once
+	res := db.Model(&database.RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", row.ID).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return issueTokens(ctx, row.SessionID, row.UserID, username, role)
+}
+
+// lookupUser returns the current username and role for a token user ID.
+func lookupUser(ctx context.Context, userID string) (string, string, error) {
+	if strings.HasPrefix(userID, "guest:") {
+		// Guest sessions end with the guest record
+		exists, err := gamestate.RDB.Exists(ctx, userID).Result()
+		if err != nil {
+			return "", "", err
+		}
+		if exists == 0 {
+			return "", "", ErrInvalidRefreshToken
+		}
+		return "Guest", "guest", nil
+	}
+
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return "", "", ErrInvalidRefreshToken
+	}
+	var user database.User
+	if err := database.DB.WithContext(ctx).First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", "", ErrInvalidRefreshToken
+		}
+		return "", "", err
+	}
+	// Users who must use 2FA log in again to enroll
+	if user.TOTPEnabledAt == nil && RequiresTwoFactor(user.Role) {
+		return "", "", ErrInvalidRefreshToken
+	}
+	return user.Username, user.Role, nil
+}
+
+// Logout revokes the access token and every refresh token of its session.
+func Logout(ctx context.Context, claims *Claims) error {
+	if err := revokeAccessToken(ctx, claims); err != nil {
+		return err
+	}
+	if claims.SessionID != "" {
+		return revokeSession(ctx, claims.SessionID)
+	}
+	return nil
+}
+
+// RevokeAllSessions signs the user out everywhere: refresh tokens are revoked,
+// access tokens issued until now are rejected and open sockets are closed.
+func RevokeAllSessions(ctx context.Context, userID, reason string) error {
+	err := database.DB.WithContext(ctx).Model(&database.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+
+	// Kept as long as the last access token issued before now can live
+	if err := gamestate.RDB.Set(ctx, "revoked:user:"+userID, time.Now().Unix(), AccessTokenTTL).Err(); err != nil {
+		return err
+	}
+
+	if reason == "" {
+		reason = "Session revoked"
+	}
+	return gamestate.DisconnectUser(ctx, userID, "", reason)
+}
+
+func revokeSession(ctx context.Context, sessionID string) error {
+	return database.DB.WithContext(ctx).Model(&database.RefreshToken{}).
+		Where("session_id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func revokeAccessToken(ctx context.Context, claims *Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		return nil
+	}
+	return gamestate.RDB.Set(ctx, "revoked:jti:"+claims.ID, 1, ttl).Err()
+}
+
+// checkRevoked rejects tokens on the revocation list.
+func checkRevoked(ctx context.Context, claims *Claims) error {
+	cmds, err := gamestate.RDB.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Exists(ctx, "revoked:jti:"+claims.ID)
+		pipe.Get(ctx, "revoked:user:"+claims.UserID)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	if cmds[0].(*redis.IntCmd).Val() > 0 {
+		return ErrTokenRevoked
+	}
+	if cutoff, err := cmds[1].(*redis.StringCmd).Int64(); err == nil && claims.IssuedAt != nil && claims.IssuedAt.Unix() <= cutoff {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
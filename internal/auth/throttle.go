@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"godra/internal/database"
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+)
+
+// Login throttling
+//
+// Failed password logins are counted per username and per client IP:
+//
+//	login:fail:user:<username>  failures in the current window
+//	login:fail:ip:<ip>
+//	login:lock:user:<username>  set while the username is locked out
+//	login:lock:ip:<ip>
+//
+// Once a counter passes its limit the username (or IP) is locked, and every
+// further failure doubles the lockout, up to LockoutMax. Locked logins are
+// rejected before the password is checked, so attackers can't make the
+// server burn bcrypt time. A successful login clears the username's counter.
+
+type ThrottleConfig struct {
+	MaxFailures   int           // Per username before lockout (0 disables)
+	MaxIPFailures int           // Per client IP before lockout (0 disables)
+	Window        time.Duration // How long failures are remembered
+	Lockout       time.Duration // First lockout; doubles with each further failure
+	LockoutMax    time.Duration
+}
+
+var LoginThrottle = ThrottleConfig{
+	MaxFailures:   5,
+	MaxIPFailures: 20,
+	Window:        15 * time.Minute,
+	Lockout:       time.Minute,
+	LockoutMax:    time.Hour,
+}
+
+// TrustProxy makes ClientIP read X-Forwarded-For / X-Real-IP. Only enable
+// behind a proxy that sets them, or clients can pick their own IP.
+var TrustProxy bool
+
+// TrustedProxyHops is how many proxies in front of the server append to
+// X-Forwarded-For. The client IP is the entry that many places from the
+// right; anything further left was sent by the client and can't be trusted.
+var TrustedProxyHops = 1
+
+// ClientIP returns the caller's IP address.
+func ClientIP(r *http.Request) string {
+	if TrustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			hops := strings.Split(fwd, ",")
+			i := len(hops) - TrustedProxyHops
+			if i < 0 {
+				i = 0
+			}
+			return strings.TrimSpace(hops[i])
+		}
+		if real := r.Header.Get("X-Real-IP"); real != "" {
+			return real
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// LoginLockedFor returns how long logins for username from ip stay locked,
+// or zero when they're allowed.
+func LoginLockedFor(ctx context.Context, username, ip string) time.Duration {
+	cmds, err := gamestate.RDB.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.PTTL(ctx, "login:lock:user:"+username)
+		pipe.PTTL(ctx, "login:lock:ip:"+ip)
+		return nil
+	})
+	if err != nil {
+		// Don't lock everyone out when Redis is unavailable
+		metrics.Log.Error("Failed to check login lockout", "error", err)
+		return 0
+	}
+
+	var wait time.Duration
+	for _, cmd := range cmds {
+		if ttl := cmd.(*redis.DurationCmd).Val(); ttl > wait {
+			wait = ttl
+		}
+	}
+	return wait
+}
+
+// RecordLoginFailure counts a failed login and locks the username or IP
+// once it passes its limit. userID is zero when the username doesn't exist.
+func RecordLoginFailure(ctx context.Context, username, ip string, userID uint) {
+	metrics.FailedLogins.Add(1)
+
+	target := "username:" + username
+	if userID != 0 {
+		target = fmt.Sprintf("%d", userID)
+	}
+	reason := fmt.Sprintf("Failed logins for %q from %s", username, ip)
+
+	cfg := LoginThrottle
+	throttle(ctx, "user:"+username, cfg.MaxFailures, target, reason)
+	throttle(ctx, "ip:"+ip, cfg.MaxIPFailures, "ip:"+ip, reason)
+}
+
+// ResetLoginFailures clears a username's failures and lockout, after a
+// successful login or a password reset.
+func ResetLoginFailures(ctx context.Context, username string) {
+	gamestate.RDB.Del(ctx, "login:fail:user:"+username, "login:lock:user:"+username)
+}
+
+func throttle(ctx context.Context, subject string, limit int, target, reason string) {
+	if limit <= 0 {
+		return
+	}
+	cfg := LoginThrottle
+	failKey := "login:fail:" + subject
+
+	failures, err := gamestate.RDB.Incr(ctx, failKey).Result()
+	if err != nil {
+		metrics.Log.Error("Failed to record login failure", "subject", subject, "error", err)
+		return
+	}
+	if failures <= int64(limit) {
+		gamestate.RDB.Expire(ctx, failKey, cfg.Window)
+		return
+	}
+
+	lockout := cfg.Lockout
+	for i := int64(limit) + 1; i < failures && lockout < cfg.LockoutMax; i++ {
+		lockout *= 2
+	}
+	if lockout > cfg.LockoutMax {
+		lockout = cfg.LockoutMax
+	}
+
+	// Keep counting through the lockout so the next one is longer
+	gamestate.RDB.Set(ctx, "login:lock:"+subject, failures, lockout)
+	gamestate.RDB.Expire(ctx, failKey, cfg.Window+lockout)
+
+	metrics.AccountLockouts.Add(1)
+	metrics.Log.Warn("Login locked", "subject", subject, "failures", failures, "lockout", lockout)
+
+	err = database.DB.WithContext(ctx).Create(&database.AuditLog{
+		Actor:  "system",
+		Action: "login.lockout",
+		Target: target,
+		After:  lockout.String(),
+		Reason: reason,
+	}).Error
+	if err != nil {
+		metrics.Log.Error("Failed to audit login lockout", "subject", subject, "error", err)
+	}
+}
@@ -0,0 +1,455 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"godra/internal/database"
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+	"godra/internal/rbac"
+)
+
+// Two-factor authentication
+//
+// Users enroll a TOTP authenticator app and receive one-time recovery codes.
+// Once 2FA is on, the first login step (password, device or identity
+// provider) only returns a challenge token; the session starts when a code
+// is posted with it to /login/2fa. Challenges live in Redis:
+//
+//	login:challenge:<hash>  hash of user_id and failed attempts
+//
+// Roles in TwoFactorRoles, and the roles inheriting from them, must use 2FA.
+// Such users enroll during their next login, and their sessions can't be
+// refreshed until they have.
+
+var (
+	TwoFactorRoles []string
+	TOTPIssuer     = "Godra" // Shown in authenticator apps
+
+	ChallengeTTL = 5 * time.Minute
+)
+
+const (
+	challengeAttempts = 5
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrTwoFactorEnabled  = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorDisabled = errors.New("two-factor authentication not enabled")
+	ErrTwoFactorRequired = errors.New("two-factor authentication is required for this role")
+	ErrInvalidCode       = errors.New("invalid code")
+	ErrInvalidChallenge  = errors.New("invalid or expired challenge")
+)
+
+// RequiresTwoFactor reports whether users with role must use 2FA.
+func RequiresTwoFactor(role string) bool {
+	for _, required := range TwoFactorRoles {
+		if rbac.HasRole(role, required) {
+			return true
+		}
+	}
+	return false
+}
+
+// Enrollment is a new TOTP secret. URI is the otpauth:// link to show as a QR code.
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// EnrollTwoFactor starts (or restarts) enrollment with a new secret. 2FA is
+// only turned on once ConfirmTwoFactor sees a code from it.
+func EnrollTwoFactor(ctx context.Context, userID uint) (*Enrollment, error) {
+	user, err := loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabledAt != nil {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := database.DB.WithContext(ctx).Model(user).Update("totp_secret", secret).Error; err != nil {
+		return nil, err
+	}
+	return &Enrollment{Secret: secret, URI: totpURI(TOTPIssuer, user.Username, secret)}, nil
+}
+
+// ConfirmTwoFactor turns 2FA on with the first code from the enrolled secret
+// and returns the recovery codes. They are only shown this once.
+func ConfirmTwoFactor(ctx context.Context, userID uint, code string) ([]string, error) {
+	user, err := loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabledAt != nil {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorDisabled
+	}
+
+	step := matchTOTP(user.TOTPSecret, normalizeCode(code), time.Now())
+	if step == 0 {
+		return nil, ErrInvalidCode
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(user).Updates(map[string]interface{}{
+			"totp_enabled_at": time.Now(),
+			"totp_last_step":  step,
+			"recovery_codes":  hashes,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&database.AuditLog{
+			Actor:  fmt.Sprintf("%d", user.ID),
+			Action: "2fa.enable",
+			Target: fmt.Sprintf("%d", user.ID),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	metrics.Log.Info("Two-factor authentication enabled", "user_id", user.ID)
+	return codes, nil
+}
+
+// DisableTwoFactor turns 2FA off after checking a current code. Users whose
+// role requires 2FA can't turn it off.
+func DisableTwoFactor(ctx context.Context, userID uint, code string) error {
+	user, err := loadUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.TOTPEnabledAt == nil {
+		return ErrTwoFactorDisabled
+	}
+	if RequiresTwoFactor(user.Role) {
+		return ErrTwoFactorRequired
+	}
+	if err := verifyCode(ctx, user, code); err != nil {
+		return err
+	}
+	return clearTwoFactor(ctx, user, fmt.Sprintf("%d", user.ID), "2fa.disable", "")
+}
+
+// ResetTwoFactor removes a user's 2FA for an admin, when the user lost both
+// their authenticator and recovery codes. Users whose role requires 2FA
+// enroll again on their next login.
+func ResetTwoFactor(ctx context.Context, userID uint, actor, reason string) error {
+	user, err := loadUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.TOTPEnabledAt == nil && user.TOTPSecret == "" {
+		return ErrTwoFactorDisabled
+	}
+	if err := clearTwoFactor(ctx, user, actor, "2fa.reset", reason); err != nil {
+		return err
+	}
+	// Sessions started with the lost device shouldn't outlive it
+	if err := RevokeAllSessions(ctx, fmt.Sprintf("%d", user.ID), "Two-factor authentication reset"); err != nil {
+		metrics.Log.Error("Failed to revoke sessions after 2FA reset", "user_id", user.ID, "error", err)
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after checking a current code.
+func RegenerateRecoveryCodes(ctx context.Context, userID uint, code string) ([]string, error) {
+	user, err := loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabledAt == nil {
+		return nil, ErrTwoFactorDisabled
+	}
+	if err := verifyCode(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := database.DB.WithContext(ctx).Model(user).Update("recovery_codes", hashes).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RecoveryCodesLeft counts a user's unused recovery codes.
+func RecoveryCodesLeft(user *database.User) int {
+	if user.RecoveryCodes == "" {
+		return 0
+	}
+	return len(strings.Split(user.RecoveryCodes, ","))
+}
+
+func clearTwoFactor(ctx context.Context, user *database.User, actor, action, reason string) error {
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(user).Updates(map[string]interface{}{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+			"recovery_codes":  "",
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&database.AuditLog{
+			Actor:  actor,
+			Action: action,
+			Target: fmt.Sprintf("%d", user.ID),
+			Reason: reason,
+		}).Error
+	})
+	if err == nil {
+		metrics.Log.Info("Two-factor authentication removed", "user_id", user.ID, "actor", actor)
+	}
+	return err
+}
+
+// verifyCode accepts a TOTP code or a recovery code. Each TOTP code works
+// once and each recovery code is removed when used; the conditional updates
+// keep two requests from redeeming the same code.
+func verifyCode(ctx context.Context, user *database.User, code string) error {
+	code = normalizeCode(code)
+	db := database.DB.WithContext(ctx)
+
+	if step := matchTOTP(user.TOTPSecret, code, time.Now()); step != 0 {
+		res := db.Model(&database.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+
+	if code == "" || user.RecoveryCodes == "" {
+		return ErrInvalidCode
+	}
+	hashed := hashToken(code)
+	remaining := make([]string, 0, recoveryCodeCount)
+	found := false
+	for _, h := range strings.Split(user.RecoveryCodes, ",") {
+		if h == hashed && !found {
+			found = true
+			continue
+		}
+		remaining = append(remaining, h)
+	}
+	if !found {
+		return ErrInvalidCode
+	}
+
+	res := db.Model(&database.User{}).
+		Where("id = ? AND recovery_codes = ?", user.ID, user.RecoveryCodes).
+		Update("recovery_codes", strings.Join(remaining, ","))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInvalidCode
+	}
+	metrics.Log.Info("Recovery code used", "user_id", user.ID, "remaining", len(remaining))
+	return nil
+}
+
+// normalizeCode drops the spaces and dashes people type into codes.
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// newRecoveryCodes returns the codes to show and the hashes to store.
+func newRecoveryCodes() ([]string, string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, "", err
+		}
+		code := strings.ToLower(enc.EncodeToString(b)) // 8 characters
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashToken(code)
+	}
+	return codes, strings.Join(hashes, ","), nil
+}
+
+func loadUser(ctx context.Context, userID uint) (*database.User, error) {
+	var user database.User
+	if err := database.DB.WithContext(ctx).First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Challenge is returned by the first login step when a code is needed.
+// EnrollRequired means the user must set up 2FA first (POST /login/2fa/enroll).
+type Challenge struct {
+	ChallengeToken string `json:"challenge_token"`
+	ExpiresIn      int    `json:"expires_in"`
+	EnrollRequired bool   `json:"enroll_required,omitempty"`
+}
+
+// RespondLogin answers a successful first login step for user: it refuses
+// banned users, starts a session, or returns a challenge when the account
+// uses or must set up 2FA.
+// status is the code sent with a new session (201 for new accounts).
+func RespondLogin(w http.ResponseWriter, r *http.Request, user *database.User, status int) {
+	ctx := r.Context()
+	if err := checkAccess(ctx, fmt.Sprintf("%d", user.ID)); err != nil {
+		if errors.Is(err, ErrAccessDenied) {
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		metrics.Log.Error("Failed to check account access", "user_id", user.ID, "error", err)
+		http.Error(w, "Error checking account", http.StatusInternalServerError)
+		return
+	}
+	if user.TOTPEnabledAt != nil || RequiresTwoFactor(user.Role) {
+		challenge, err := startChallenge(ctx, user)
+		if err != nil {
+			metrics.Log.Error("Failed to start two-factor login", "user_id", user.ID, "error", err)
+			http.Error(w, "Error starting two-factor login", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(challenge)
+		return
+	}
+
+	// Only cleared once a session starts, so passing the first step doesn't
+	// reset the failures counted against the second
+	ResetLoginFailures(ctx, user.Username)
+	writeSession(w, r, user, status, nil)
+}
+
+func writeSession(w http.ResponseWriter, r *http.Request, user *database.User, status int, recoveryCodes []string) {
+	tokens, err := StartSession(r.Context(), fmt.Sprintf("%d", user.ID), user.Username, user.Role)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(TwoFactorLoginResponse{
+		AuthResponse: AuthResponse{
+			TokenPair: tokens,
+			Username:  user.Username,
+			UserID:    user.ID,
+			Role:      user.Role,
+		},
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+func startChallenge(ctx context.Context, user *database.User) (*Challenge, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	key := "login:challenge:" + hashToken(token)
+	_, err = gamestate.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "user_id", user.ID, "attempts", 0)
+		pipe.Expire(ctx, key, ChallengeTTL)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Challenge{
+		ChallengeToken: token,
+		ExpiresIn:      int(ChallengeTTL.Seconds()),
+		EnrollRequired: user.TOTPEnabledAt == nil,
+	}, nil
+}
+
+// ChallengeUser returns the user a challenge token was issued to.
+func ChallengeUser(ctx context.Context, token string) (*database.User, error) {
+	id, err := gamestate.RDB.HGet(ctx, "login:challenge:"+hashToken(token), "user_id").Result()
+	if err == redis.Nil {
+		return nil, ErrInvalidChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+	return loadUser(ctx, uint(userID))
+}
+
+// CompleteChallenge checks the second login step. Users enrolling during
+// login confirm their first code here and get their recovery codes back.
+// Wrong codes count as failed logins, and a challenge allows only a few.
+func CompleteChallenge(ctx context.Context, token string, user *database.User, code, ip string) ([]string, error) {
+	key := "login:challenge:" + hashToken(token)
+
+	var recoveryCodes []string
+	var err error
+	if user.TOTPEnabledAt == nil {
+		recoveryCodes, err = ConfirmTwoFactor(ctx, user.ID, code)
+	} else {
+		err = verifyCode(ctx, user, code)
+	}
+
+	if errors.Is(err, ErrInvalidCode) {
+		RecordLoginFailure(ctx, user.Username, ip, user.ID)
+		if attempts, _ := gamestate.RDB.HIncrBy(ctx, key, "attempts", 1).Result(); attempts >= challengeAttempts {
+			gamestate.RDB.Del(ctx, key)
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Single use: a second request with the same challenge loses
+	if deleted, err := gamestate.RDB.Del(ctx, key).Result(); err != nil || deleted == 0 {
+		return nil, ErrInvalidChallenge
+	}
+	ResetLoginFailures(ctx, user.Username)
+	return recoveryCodes, nil
+}
+
+// EnrollChallenge starts enrollment for a user who must set up 2FA to finish logging in.
+func EnrollChallenge(ctx context.Context, token string) (*Enrollment, error) {
+	user, err := ChallengeUser(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return EnrollTwoFactor(ctx, user.ID)
+}
@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"godra/internal/database"
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+	"godra/internal/social"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// send_chat.lua can't run the Go filters, so it pushes messages onto this list
+// and the worker below sends them like any other chat message.
+const inboxQueue = "chat:inbox"
+
+type queuedMessage struct {
+	UserID  string `json:"user_id"`
+	Channel string `json:"channel"`
+	Message string `json:"message"`
+}
+
+// StartWorker consumes chat messages queued from Lua scripts.
+func StartWorker(ctx context.Context) {
+	go func() {
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+
+			item, err := gamestate.RDB.BRPop(ctx, 5*time.Second, inboxQueue).Result()
+			if err != nil {
+				// redis.Nil on timeout; anything else is logged and retried after a pause
+				if err != redis.Nil && ctx.Err() == nil {
+					metrics.Log.Error("Failed to read chat inbox", "error", err)
+					time.Sleep(time.Second)
+				}
+				continue
+			}
+
+			var queued queuedMessage
+			if err := json.Unmarshal([]byte(item[1]), &queued); err != nil {
+				metrics.Log.Error("Dropping malformed chat message", "error", err)
+				continue
+			}
+
+			ch, err := ParseChannel(queued.Channel, queued.UserID)
+			if err != nil {
+				metrics.Log.Error("Dropping chat message", "channel", queued.Channel, "error", err)
+				continue
+			}
+			username, err := senderName(ctx, queued.UserID)
+			if err != nil {
+				metrics.Log.Error("Dropping chat message", "user_id", queued.UserID, "error", err)
+				continue
+			}
+			if _, err := Send(ctx, ch, queued.UserID, username, queued.Message); err != nil {
+				metrics.Log.Info("Chat message rejected", "channel", queued.Channel, "user_id", queued.UserID, "error", err)
+			}
+		}
+	}()
+}
+
+// senderName looks up the name shown with a queued message, since scripts only
+// know the user ID. Guests are all "Guest", as in their tokens.
+func senderName(ctx context.Context, userID string) (string, error) {
+	id, err := social.ParseUserID(userID)
+	if err != nil {
+		return "Guest", nil
+	}
+	var user database.User
+	if err := database.DB.WithContext(ctx).Select("username").First(&user, id).Error; err != nil {
+		return "", err
+	}
+	return user.Username, nil
+}
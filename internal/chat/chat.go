@@ -0,0 +1,340 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"godra/internal/database"
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+	"godra/internal/social"
+)
+
+// Channels
+//
+//	global                  every connected player
+//	room:<game_id>          players in a lobby
+//	team:<game_id>:<team>   players in a lobby whose entry in game:<id>:teams matches
+//	party:<party_id>        party members
+//	direct:<user>,<user>    two players; clients may send to "direct:<other_user>"
+//
+// Redis layout
+//
+//	chat:history:<channel>  sorted set of message JSON scored by message ID
+//	chat:next_id            message ID sequence
+//	chat:mute:<uid>         set while the user is muted; the value is the reason (kept by the moderation package)
+//
+// Team membership is owned by game logic: scripts write game:<id>:teams
+// (user ID -> team name) when teams are picked.
+
+var (
+	ErrInvalidChannel = errors.New("invalid channel")
+	ErrForbidden      = errors.New("not a member of this channel")
+	ErrBlocked        = errors.New("user is blocked")
+	ErrEmpty          = errors.New("message is empty")
+	ErrTooLong        = errors.New("message is too long")
+	ErrMuted          = errors.New("you are muted")
+	ErrNotFound       = errors.New("message not found")
+)
+
+// Config controls chat history and the default filters.
+type Config struct {
+	HistorySize int      // Messages kept per channel in Redis
+	MaxLength   int      // Longest message accepted, in characters
+	BannedWords []string // Words masked by the word filter
+	Archive     bool     // Also store every message in the database
+}
+
+var cfg = Config{
+	HistorySize: 100,
+	MaxLength:   500,
+}
+
+// Configure replaces the chat settings and resets the filter pipeline to the
+// defaults (mute, length, banned words). Call before serving requests.
+func Configure(c Config) {
+	cfg = c
+	filters = []Filter{MuteFilter, LengthFilter(c.MaxLength), WordFilter(c.BannedWords)}
+}
+
+type Message struct {
+	ID        int64  `json:"id"`
+	Channel   string `json:"channel"`
+	UserID    string `json:"user_id"`
+	Username  string `json:"username,omitempty"`
+	Message   string `json:"message"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Channel is a parsed channel name.
+type Channel struct {
+	Kind string // "global", "room", "team", "party" or "direct"
+	ID   string // Lobby, party or "<user>,<user>" for direct channels
+	Team string
+}
+
+func (c Channel) String() string {
+	switch c.Kind {
+	case "global":
+		return "global"
+	case "team":
+		return "team:" + c.ID + ":" + c.Team
+	default:
+		return c.Kind + ":" + c.ID
+	}
+}
+
+// ParseChannel parses a channel name for userID, expanding "direct:<other>"
+// into the canonical two-user form.
+func ParseChannel(name, userID string) (Channel, error) {
+	if name == "global" {
+		return Channel{Kind: "global"}, nil
+	}
+	kind, id, ok := strings.Cut(name, ":")
+	if !ok || id == "" {
+		return Channel{}, ErrInvalidChannel
+	}
+
+	switch kind {
+	case "room", "party":
+		return Channel{Kind: kind, ID: id}, nil
+	case "team":
+		i := strings.LastIndex(id, ":")
+		if i <= 0 || i == len(id)-1 {
+			return Channel{}, ErrInvalidChannel
+		}
+		return Channel{Kind: kind, ID: id[:i], Team: id[i+1:]}, nil
+	case "direct":
+		users := strings.Split(id, ",")
+		if len(users) == 1 {
+			users = append(users, userID)
+		}
+		if len(users) != 2 || users[0] == "" || users[1] == "" || users[0] == users[1] {
+			return Channel{}, ErrInvalidChannel
+		}
+		sort.Strings(users)
+		return Channel{Kind: kind, ID: users[0] + "," + users[1]}, nil
+	}
+	return Channel{}, ErrInvalidChannel
+}
+
+func historyKey(ch Channel) string { return "chat:history:" + ch.String() }
+
+// Authorize checks that userID may read and write the channel.
+func Authorize(ctx context.Context, ch Channel, userID string) error {
+	var ok bool
+	var err error
+
+	switch ch.Kind {
+	case "global":
+		return nil
+	case "room":
+		ok, err = gamestate.RDB.SIsMember(ctx, "game:"+ch.ID+":players", userID).Result()
+	case "team":
+		var team string
+		team, err = gamestate.RDB.HGet(ctx, "game:"+ch.ID+":teams", userID).Result()
+		if err == redis.Nil {
+			err = nil
+		}
+		ok = team == ch.Team
+	case "party":
+		ok, err = gamestate.RDB.SIsMember(ctx, "party:"+ch.ID+":members", userID).Result()
+	case "direct":
+		for _, u := range strings.Split(ch.ID, ",") {
+			ok = ok || u == userID
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// Send runs the message through the filters, stores it in the channel history
+// and delivers it to everyone in the channel.
+func Send(ctx context.Context, ch Channel, userID, username, text string) (*Message, error) {
+	if err := Authorize(ctx, ch, userID); err != nil {
+		return nil, err
+	}
+	if ch.Kind == "direct" {
+		users := strings.Split(ch.ID, ",")
+		if social.Blocks(ctx, users[0], users[1]) {
+			return nil, ErrBlocked
+		}
+	}
+
+	msg := &Message{
+		Channel:   ch.String(),
+		UserID:    userID,
+		Username:  username,
+		Message:   text,
+		Timestamp: time.Now().Unix(),
+	}
+	for _, f := range filters {
+		if err := f(ctx, msg); err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := gamestate.RDB.Incr(ctx, "chat:next_id").Result()
+	if err != nil {
+		return nil, err
+	}
+	msg.ID = id
+
+	stored, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	_, err = gamestate.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, historyKey(ch), redis.Z{Score: float64(id), Member: stored})
+		pipe.ZRemRangeByRank(ctx, historyKey(ch), 0, int64(-cfg.HistorySize-1))
+		if ch.Kind == "room" {
+			// Chat counts as lobby activity for the lobby reaper
+			pipe.HSet(ctx, "game:"+ch.ID, "last_activity", msg.Timestamp)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Archive {
+		archived := database.ChatMessage{
+			MessageID: id,
+			Channel:   msg.Channel,
+			UserID:    userID,
+			Username:  username,
+			Message:   msg.Message,
+		}
+		if err := database.DB.WithContext(ctx).Create(&archived).Error; err != nil {
+			metrics.Log.Error("Failed to archive chat message", "channel", msg.Channel, "id", id, "error", err)
+		}
+	}
+
+	publish(ctx, ch, "chat", msg, userID)
+	return msg, nil
+}
+
+// History returns up to limit messages older than before (0 for the newest),
+// newest first. When Redis has run out and archiving is on, older messages
+// come from the database.
+func History(ctx context.Context, ch Channel, before int64, limit int) ([]Message, error) {
+	max := "+inf"
+	if before > 0 {
+		max = "(" + strconv.FormatInt(before, 10)
+	}
+	raw, err := gamestate.RDB.ZRevRangeByScore(ctx, historyKey(ch), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   max,
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, limit)
+	for _, item := range raw {
+		var m Message
+		if json.Unmarshal([]byte(item), &m) == nil {
+			messages = append(messages, m)
+		}
+	}
+
+	if cfg.Archive && len(messages) < limit {
+		oldest := before
+		if len(messages) > 0 {
+			oldest = messages[len(messages)-1].ID
+		}
+
+		query := database.DB.WithContext(ctx).
+			Where("channel = ? AND deleted_by = ?", ch.String(), "").
+			Order("message_id DESC").
+			Limit(limit - len(messages))
+		if oldest > 0 {
+			query = query.Where("message_id < ?", oldest)
+		}
+		var archived []database.ChatMessage
+		if err := query.Find(&archived).Error; err != nil {
+			return nil, err
+		}
+		for _, a := range archived {
+			messages = append(messages, Message{
+				ID:        a.MessageID,
+				Channel:   a.Channel,
+				UserID:    a.UserID,
+				Username:  a.Username,
+				Message:   a.Message,
+				Timestamp: a.CreatedAt.Unix(),
+			})
+		}
+	}
+	return messages, nil
+}
+
+// publish delivers an event to the channel's audience. Chat from sender is
+// skipped for recipients who blocked them; room and global chat is filtered
+// by the WebSocket hub instead.
+func publish(ctx context.Context, ch Channel, eventType string, payload interface{}, sender string) {
+	event, err := json.Marshal(map[string]interface{}{
+		"type":    eventType,
+		"payload": payload,
+	})
+	if err != nil {
+		return
+	}
+
+	switch ch.Kind {
+	case "global":
+		err = gamestate.PublishToAll(ctx, event)
+	case "room":
+		err = gamestate.RDB.Publish(ctx, "game_updates:game:"+ch.ID, event).Err()
+	default:
+		var recipients []string
+		recipients, err = members(ctx, ch)
+		for _, userID := range recipients {
+			if sender != "" && userID != sender && social.Blocks(ctx, userID, sender) {
+				continue
+			}
+			if err := gamestate.PublishToUser(ctx, userID, event); err != nil {
+				metrics.Log.Error("Failed to deliver chat event", "channel", ch.String(), "user_id", userID, "error", err)
+			}
+		}
+	}
+	if err != nil {
+		metrics.Log.Error("Failed to deliver chat event", "channel", ch.String(), "error", err)
+	}
+}
+
+// members lists the users of a team, party or direct channel.
+func members(ctx context.Context, ch Channel) ([]string, error) {
+	switch ch.Kind {
+	case "team":
+		teams, err := gamestate.RDB.HGetAll(ctx, "game:"+ch.ID+":teams").Result()
+		if err != nil {
+			return nil, err
+		}
+		var users []string
+		for userID, team := range teams {
+			if team == ch.Team {
+				users = append(users, userID)
+			}
+		}
+		return users, nil
+	case "party":
+		return gamestate.RDB.SMembers(ctx, "party:"+ch.ID+":members").Result()
+	case "direct":
+		return strings.Split(ch.ID, ","), nil
+	}
+	return nil, nil
+}
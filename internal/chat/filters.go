@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"godra/internal/moderation"
+)
+
+// Filter inspects a message before it's stored and may rewrite msg.Message.
+// Returning an error rejects the message.
+type Filter func(ctx context.Context, msg *Message) error
+
+var filters = []Filter{MuteFilter, LengthFilter(cfg.MaxLength)}
+
+// Use appends filters to the pipeline. They run after the defaults, in order.
+func Use(f ...Filter) {
+	filters = append(filters, f...)
+}
+
+// MuteFilter rejects messages from muted users.
+func MuteFilter(ctx context.Context, msg *Message) error {
+	muted, err := moderation.IsMuted(ctx, msg.UserID)
+	if err != nil {
+		return err
+	}
+	if muted {
+		return ErrMuted
+	}
+	return nil
+}
+
+// LengthFilter trims surrounding whitespace and rejects empty messages and
+// messages longer than max characters. A max of 0 disables the upper limit.
+func LengthFilter(max int) Filter {
+	return func(ctx context.Context, msg *Message) error {
+		msg.Message = strings.TrimSpace(msg.Message)
+		if msg.Message == "" {
+			return ErrEmpty
+		}
+		if max > 0 && utf8.RuneCountInString(msg.Message) > max {
+			return ErrTooLong
+		}
+		return nil
+	}
+}
+
+// WordFilter masks whole-word, case-insensitive matches of the given words with asterisks.
+func WordFilter(words []string) Filter {
+	quoted := make([]string, 0, len(words))
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+	if len(quoted) == 0 {
+		return func(ctx context.Context, msg *Message) error { return nil }
+	}
+
+	re := regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+	return func(ctx context.Context, msg *Message) error {
+		msg.Message = re.ReplaceAllStringFunc(msg.Message, func(m string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(m))
+		})
+		return nil
+	}
+}
@@ -0,0 +1,180 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"godra/internal/auth"
+	"godra/internal/moderation"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 100
+)
+
+type SendRequest struct {
+	Message string `json:"message"`
+}
+
+type HistoryResponse struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor,omitempty"` // Pass as "before" to fetch older messages
+}
+
+type MuteRequest struct {
+	UserID   string `json:"user_id"`
+	Duration int    `json:"duration"` // Seconds
+	Reason   string `json:"reason"`
+}
+
+// SendHandler posts a message to {channel}.
+func SendHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	ch, err := ParseChannel(chi.URLParam(r, "channel"), claims.UserID)
+	if err != nil {
+		http.Error(w, "Invalid channel", http.StatusBadRequest)
+		return
+	}
+
+	var req SendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := Send(r.Context(), ch, claims.UserID, claims.Username, req.Message)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(msg)
+}
+
+// HistoryHandler returns a page of {channel}'s history, newest first.
+// Query parameters: before (message ID cursor) and limit.
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	ch, err := ParseChannel(chi.URLParam(r, "channel"), claims.UserID)
+	if err != nil {
+		http.Error(w, "Invalid channel", http.StatusBadRequest)
+		return
+	}
+	if err := Authorize(r.Context(), ch, claims.UserID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+	var before int64
+	if v := q.Get("before"); v != "" {
+		if before, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	messages, err := History(r.Context(), ch, before, limit)
+	if err != nil {
+		http.Error(w, "Failed to load chat history", http.StatusInternalServerError)
+		return
+	}
+
+	resp := HistoryResponse{Messages: messages}
+	if len(messages) == limit {
+		resp.NextCursor = strconv.FormatInt(messages[len(messages)-1].ID, 10)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// DeleteMessageHandler removes a message. Needs the chat.moderate permission.
+func DeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	ch, err := ParseChannel(chi.URLParam(r, "channel"), claims.UserID)
+	if err != nil {
+		http.Error(w, "Invalid channel", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "messageID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := Delete(r.Context(), ch, id, claims.UserID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MuteHandler mutes a user below the caller's role. Needs the chat.moderate permission.
+func MuteHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	var req MuteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" || req.Duration <= 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := moderation.CheckOutranks(r.Context(), claims.Role, req.UserID); err != nil {
+		switch {
+		case errors.Is(err, moderation.ErrProtected):
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		case errors.Is(err, auth.ErrUserNotFound):
+			http.Error(w, "User not found", http.StatusNotFound)
+		default:
+			http.Error(w, "Failed to mute user", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := Mute(r.Context(), req.UserID, time.Duration(req.Duration)*time.Second, req.Reason, claims.UserID); err != nil {
+		http.Error(w, "Failed to mute user", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnmuteHandler lifts a mute. Needs the chat.moderate permission.
+func UnmuteHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+	if err := Unmute(r.Context(), chi.URLParam(r, "userID"), claims.UserID); err != nil {
+		http.Error(w, "Failed to unmute user", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidChannel), errors.Is(err, ErrEmpty), errors.Is(err, ErrTooLong):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrForbidden), errors.Is(err, ErrBlocked), errors.Is(err, ErrMuted):
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, "Message not found", http.StatusNotFound)
+	default:
+		http.Error(w, "Chat request failed", http.StatusInternalServerError)
+	}
+}
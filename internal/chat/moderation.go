@@ -0,0 +1,121 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"godra/internal/database"
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+	"godra/internal/moderation"
+)
+
+// Delete removes a message from the channel history (and the archive) and
+// tells the channel so clients can hide it.
+func Delete(ctx context.Context, ch Channel, id int64, moderator string) error {
+	score := strconv.FormatInt(id, 10)
+	removed, err := gamestate.RDB.ZRemRangeByScore(ctx, historyKey(ch), score, score).Result()
+	if err != nil {
+		return err
+	}
+
+	archived := int64(0)
+	if cfg.Archive {
+		res := database.DB.WithContext(ctx).Model(&database.ChatMessage{}).
+			Where("message_id = ? AND channel = ? AND deleted_by = ?", id, ch.String(), "").
+			Update("deleted_by", moderator)
+		if res.Error != nil {
+			return res.Error
+		}
+		archived = res.RowsAffected
+	}
+	if removed == 0 && archived == 0 {
+		return ErrNotFound
+	}
+
+	metrics.Log.Info("Chat message deleted", "channel", ch.String(), "id", id, "moderator", moderator)
+	publish(ctx, ch, "chat_deleted", map[string]interface{}{
+		"channel": ch.String(),
+		"id":      id,
+	}, "")
+	return nil
+}
+
+// Mute stops the user from sending chat messages for duration. The mute is
+// recorded as a sanction in the user's moderation history.
+func Mute(ctx context.Context, userID string, duration time.Duration, reason, moderator string) error {
+	_, err := moderation.Issue(ctx, userID, moderation.Mute, reason, duration, moderator)
+	return err
+}
+
+// Unmute lifts the user's mutes early.
+func Unmute(ctx context.Context, userID, moderator string) error {
+	_, err := moderation.LiftAll(ctx, userID, moderation.Mute, moderator, "")
+	return err
+}
+
+// UserMessages returns the messages userID wrote that are still in channel
+// histories, for personal data exports.
+func UserMessages(ctx context.Context, userID string) ([]Message, error) {
+	var found []Message
+	err := scanHistories(ctx, func(key string, raw string, msg *Message) error {
+		if msg.UserID == userID {
+			found = append(found, *msg)
+		}
+		return nil
+	})
+	return found, err
+}
+
+// PurgeUser removes userID's messages from every channel history and drops
+// the histories of their direct channels. Returns how many messages went.
+func PurgeUser(ctx context.Context, userID string) (int, error) {
+	removed := 0
+	err := scanHistories(ctx, func(key string, raw string, msg *Message) error {
+		if msg.UserID != userID && !isDirectWith(key, userID) {
+			return nil
+		}
+		n, err := gamestate.RDB.ZRem(ctx, key, raw).Result()
+		removed += int(n)
+		return err
+	})
+	return removed, err
+}
+
+func isDirectWith(key, userID string) bool {
+	users, ok := strings.CutPrefix(key, "chat:history:direct:")
+	if !ok {
+		return false
+	}
+	for _, u := range strings.Split(users, ",") {
+		if u == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// scanHistories calls fn for every message in every channel history.
+func scanHistories(ctx context.Context, fn func(key string, raw string, msg *Message) error) error {
+	iter := gamestate.RDB.Scan(ctx, 0, "chat:history:*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		entries, err := gamestate.RDB.ZRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		for _, raw := range entries {
+			var msg Message
+			if json.Unmarshal([]byte(raw), &msg) != nil {
+				continue
+			}
+			if err := fn(key, raw, &msg); err != nil {
+				return err
+			}
+		}
+	}
+	return iter.Err()
+}
@@ -0,0 +1,22 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKey lets a trusted backend call scripts through /api/rpc. Only a hash of
+// the key is stored.
+type APIKey struct {
+	gorm.Model
+	Name       string
+	Prefix     string // First characters of the key, to recognize it in lists
+	KeyHash    string `gorm:"uniqueIndex"`
+	Scripts    string // Comma separated script names the key may call; "*" for every non-internal script
+	CreatedBy  string
+	ExpiresAt  *time.Time // nil for keys that don't expire
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	RevokedBy  string
+}
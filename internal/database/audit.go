@@ -0,0 +1,14 @@
+package database
+
+import "gorm.io/gorm"
+
+// AuditLog records a privileged change: who did what to whom.
+type AuditLog struct {
+	gorm.Model
+	Actor  string `gorm:"index"` // User ID, or "system" / "cli"
+	Action string `gorm:"index"` // e.g. "role.change"
+	Target string `gorm:"index"` // User ID the change applies to
+	Before string
+	After  string
+	Reason string
+}
@@ -0,0 +1,15 @@
+package database
+
+import "gorm.io/gorm"
+
+// ChatMessage is an archived chat message. Redis only keeps the most recent
+// messages per channel; the archive is optional.
+type ChatMessage struct {
+	gorm.Model
+	MessageID int64  `gorm:"uniqueIndex"`
+	Channel   string `gorm:"index"`
+	UserID    string `gorm:"index"`
+	Username  string
+	Message   string
+	DeletedBy string // Moderator who removed the message, empty if visible
+}
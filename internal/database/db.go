@@ -3,6 +3,7 @@ package database
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
@@ -16,6 +17,16 @@ type User struct {
 	Username string `gorm:"uniqueIndex"`
 	Password string
 	Role     string `gorm:"default:'player'"`
+	GuestID  string `gorm:"index"` // Guest ID the account was upgraded from, if any
+
+	Email           *string `gorm:"uniqueIndex"` // Optional; nil when not set
+	EmailVerifiedAt *time.Time
+
+	// Two-factor authentication
+	TOTPSecret    string     // Base32; set when enrollment starts
+	TOTPEnabledAt *time.Time // Set once the first code is confirmed
+	TOTPLastStep  int64      // Last accepted time step, so codes can't be replayed
+	RecoveryCodes string     // Comma separated SHA-256 hashes of unused recovery codes
 }
 
 func Init(dbType, dsn string) error {
@@ -39,7 +50,24 @@ func Init(dbType, dsn string) error {
 	log.Printf("Connected to %s database", dbType)
 
 	// AutoMigrate
-	if err := DB.AutoMigrate(&User{}); err != nil {
+	if err := DB.AutoMigrate(
+		&User{},
+		&Profile{},
+		&RefreshToken{},
+		&Identity{},
+		&AuditLog{},
+		&AccountToken{},
+		&APIKey{},
+		&Sanction{},
+		&DeletionReceipt{},
+		&PlayerRating{},
+		&MatchResult{},
+		&RatingHistory{},
+		&Friendship{},
+		&Block{},
+		&ChatMessage{},
+		&StorageObject{},
+	); err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
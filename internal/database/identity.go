@@ -0,0 +1,25 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"gorm.io/gorm"
+)
+
+// Identity links a user to an account at an external identity provider
+// (an OpenID Connect issuer, a device, a partner's user ID, ...).
+type Identity struct {
+	gorm.Model
+	UserID   uint   `gorm:"index"`
+	Provider string `gorm:"uniqueIndex:idx_identity_provider_subject"`
+	Subject  string `gorm:"uniqueIndex:idx_identity_provider_subject"` // The provider's ID for the user; a hash for devices
+	Email    string
+}
+
+// DeviceSubject returns the Identity subject stored for a device ID. Device
+// IDs log in on their own, like a password, so only their hash is stored.
+func DeviceSubject(deviceID string) string {
+	sum := sha256.Sum256([]byte(deviceID))
+	return hex.EncodeToString(sum[:])
+}
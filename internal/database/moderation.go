@@ -0,0 +1,21 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Sanction is a moderation action against a user. Lifted and expired
+// sanctions are kept as the user's history.
+type Sanction struct {
+	gorm.Model
+	UserID     string `gorm:"index"`
+	Type       string `gorm:"index"` // "ban", "suspension" or "mute"
+	Reason     string
+	IssuedBy   string     // Moderator's user ID, or "system" / "cli"
+	ExpiresAt  *time.Time // nil for permanent
+	LiftedAt   *time.Time
+	LiftedBy   string
+	LiftReason string
+}
@@ -0,0 +1,13 @@
+package database
+
+import "gorm.io/gorm"
+
+// DeletionReceipt records that an account's personal data was erased. It
+// holds no personal data itself, only what was removed and on whose request.
+type DeletionReceipt struct {
+	gorm.Model
+	UserID      uint   `gorm:"index"`
+	RequestedBy string // The user's own ID, or the admin's
+	Reason      string
+	Removed     string // JSON counts of the rows and Redis entries removed
+}
@@ -0,0 +1,14 @@
+package database
+
+import "gorm.io/gorm"
+
+// Profile holds what players show to each other beyond their username. Users
+// without one use the defaults.
+type Profile struct {
+	gorm.Model
+	UserID      uint `gorm:"uniqueIndex"`
+	DisplayName string
+	AvatarURL   string
+	Locale      string // BCP 47 tag such as "en" or "pt-BR"
+	Metadata    string // JSON object owned by the game, empty when unset
+}
@@ -0,0 +1,37 @@
+package database
+
+import "gorm.io/gorm"
+
+// PlayerRating is a user's skill rating for one game mode in one season.
+type PlayerRating struct {
+	gorm.Model
+	UserID uint   `gorm:"uniqueIndex:idx_rating_user_mode_season"`
+	Mode   string `gorm:"uniqueIndex:idx_rating_user_mode_season"`
+	Season string `gorm:"uniqueIndex:idx_rating_user_mode_season"`
+	Rating float64
+	Games  int
+	Wins   int
+	Losses int
+	Draws  int
+}
+
+// MatchResult records a reported match outcome. A game can only be reported once.
+type MatchResult struct {
+	gorm.Model
+	GameID     string `gorm:"uniqueIndex:idx_match_results_game"`
+	Mode       string
+	Season     string
+	ReportedBy string
+	Result     string // Raw JSON of the reported teams and ranks
+}
+
+// RatingHistory records every rating change applied by a match.
+type RatingHistory struct {
+	gorm.Model
+	UserID        uint `gorm:"index"`
+	MatchResultID uint `gorm:"index"`
+	Mode          string
+	Season        string
+	Before        float64
+	After         float64
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefreshToken is one refresh token of a login session. Tokens rotate on every
+// refresh; all tokens of a session share the SessionID. Only a hash of the
+// token is stored.
+type RefreshToken struct {
+	gorm.Model
+	TokenHash string `gorm:"uniqueIndex"`
+	SessionID string `gorm:"index"`
+	UserID    string `gorm:"index"` // Token user ID, so guests ("guest:...") are covered too
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+// AccountToken is a single-use token mailed to a user: a password reset or an
+// email verification link. Only a hash of the token is stored.
+type AccountToken struct {
+	gorm.Model
+	TokenHash string `gorm:"uniqueIndex"`
+	UserID    uint   `gorm:"index"`
+	Purpose   string `gorm:"index"` // "password_reset" or "email_verify"
+	Email     string // Address the token was sent to
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
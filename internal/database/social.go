@@ -0,0 +1,24 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Friendship is one side of a friend relationship. A pending request is a single
+// row from the requester; once accepted both users have an "accepted" row.
+type Friendship struct {
+	gorm.Model
+	UserID     uint   `gorm:"uniqueIndex:idx_friendship_pair"`
+	FriendID   uint   `gorm:"uniqueIndex:idx_friendship_pair;index"`
+	Status     string // "pending" or "accepted"
+	AcceptedAt *time.Time
+}
+
+// Block hides another user's chat and stops their friend requests and invites.
+type Block struct {
+	gorm.Model
+	UserID    uint `gorm:"uniqueIndex:idx_block_pair"`
+	BlockedID uint `gorm:"uniqueIndex:idx_block_pair;index"`
+}
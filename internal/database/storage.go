@@ -0,0 +1,16 @@
+package database
+
+import "gorm.io/gorm"
+
+// StorageObject is a JSON object a user keeps in one of their storage
+// collections: save games, settings, ... Version starts at 1 and goes up on
+// every write.
+type StorageObject struct {
+	gorm.Model
+	UserID     uint   `gorm:"uniqueIndex:idx_storage_object"`
+	Collection string `gorm:"uniqueIndex:idx_storage_object"`
+	Key        string `gorm:"uniqueIndex:idx_storage_object"`
+	Value      string // JSON object
+	Version    int64
+	Permission string // "owner" or "public"
+}
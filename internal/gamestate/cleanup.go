@@ -4,6 +4,7 @@ import (
 	"context"
 	"godra/internal/metrics"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -48,7 +49,21 @@ func cleanupExpiredSessions(expirySeconds int) {
 		// Remove from Sorted Set
 		RDB.ZRem(context.Background(), "active_sessions:guests", userID)
 
+		// Only guests expire; registered and upgraded users are left alone
+		if !IsGuest(context.Background(), userID) {
+			continue
+		}
+
 		// 2. Execute Disconnect Logic
 		ExecuteScript(context.Background(), "on_disconnect", []string{}, userID)
 	}
 }
+
+// IsGuest reports whether userID is a guest that hasn't upgraded to a registered account.
+func IsGuest(ctx context.Context, userID string) bool {
+	if !strings.HasPrefix(userID, "guest:") {
+		return false
+	}
+	upgraded, err := RDB.Exists(ctx, "guest_alias:"+userID).Result()
+	return err == nil && upgraded == 0
+}
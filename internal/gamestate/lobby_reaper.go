@@ -0,0 +1,143 @@
+package gamestate
+
+import (
+	"context"
+	"godra/internal/metrics"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// LobbyReaperConfig controls when players and lobbies are cleaned up.
+type LobbyReaperConfig struct {
+	DisconnectGrace time.Duration // Disconnected players are removed after this long
+	EmptyTimeout    time.Duration // Lobbies with no players are closed after this long
+	IdleTimeout     time.Duration // Lobbies without any activity are closed after this long
+}
+
+const (
+	reaperLockKey    = "lobbies:reaper_lock"
+	activeLobbiesKey = "lobbies:active" // Count from the last reaper pass, for every node's metrics
+)
+
+// StartLobbyReaper starts a background worker that removes players who didn't
+// reconnect in time and closes empty or idle lobbies. Only one node reaps per tick.
+func StartLobbyReaper(ctx context.Context, interval time.Duration, cfg LobbyReaperConfig) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ok, err := RDB.SetNX(ctx, reaperLockKey, "1", interval).Result()
+				if err != nil {
+					metrics.Log.Error("Lobby reaper lock failed", "error", err)
+					continue
+				}
+				if ok {
+					reapLobbies(ctx, cfg)
+				}
+				if n, err := RDB.Get(ctx, activeLobbiesKey).Int64(); err == nil {
+					metrics.ActiveLobbies.Store(n)
+				}
+			}
+		}
+	}()
+}
+
+// MarkDisconnected is called when one of a player's lobby sockets closes.
+// Once none is left the player is marked so the reaper can remove them if
+// they don't come back; on_connect clears the mark.
+func MarkDisconnected(ctx context.Context, gameID, userID string) error {
+	_, err := ExecuteScript(ctx, "on_socket_close", []string{"game:" + gameID}, userID)
+	return err
+}
+
+func reapLobbies(ctx context.Context, cfg LobbyReaperConfig) {
+	ids, err := RDB.ZRange(ctx, "lobbies:index", 0, -1).Result()
+	if err != nil {
+		metrics.Log.Error("Failed to scan lobbies", "error", err)
+		return
+	}
+
+	now := time.Now().Unix()
+	active := 0
+
+	for _, id := range ids {
+		gameKey := "game:" + id
+
+		// 1. Remove players who disconnected and didn't come back
+		ghosts, err := RDB.ZRangeByScore(ctx, gameKey+":disconnected", &redis.ZRangeBy{
+			Min: "-inf",
+			Max: strconv.FormatInt(now-int64(cfg.DisconnectGrace.Seconds()), 10),
+		}).Result()
+		if err != nil {
+			metrics.Log.Error("Failed to scan disconnected players", "game_id", id, "error", err)
+			continue
+		}
+		for _, userID := range ghosts {
+			metrics.Log.Info("Removing disconnected player", "game_id", id, "user_id", userID)
+			if _, err := ExecuteScript(ctx, "leave_lobby", []string{gameKey}, userID); err != nil {
+				metrics.Log.Error("Failed to remove disconnected player", "game_id", id, "user_id", userID, "error", err)
+			}
+			RDB.ZRem(ctx, gameKey+":disconnected", userID)
+		}
+
+		// 2. Close lobbies that are gone, empty or idle
+		fields, err := RDB.HMGet(ctx, gameKey, "last_activity", "empty_since", "created_at").Result()
+		if err != nil {
+			metrics.Log.Error("Failed to read lobby", "game_id", id, "error", err)
+			continue
+		}
+		players, err := RDB.SCard(ctx, gameKey+":players").Result()
+		if err != nil {
+			continue
+		}
+
+		lastActivity := parseUnix(fields[0])
+		emptySince := parseUnix(fields[1])
+		if lastActivity == 0 {
+			lastActivity = parseUnix(fields[2])
+		}
+
+		reason := ""
+		switch {
+		case lastActivity == 0:
+			// Hash is gone, only the index entries are left
+			reason = "expired"
+		case players == 0 && emptySince == 0:
+			RDB.HSet(ctx, gameKey, "empty_since", now)
+		case players == 0 && now-emptySince > int64(cfg.EmptyTimeout.Seconds()):
+			reason = "empty"
+		case now-lastActivity > int64(cfg.IdleTimeout.Seconds()):
+			reason = "idle"
+		}
+
+		if reason == "" {
+			active++
+			continue
+		}
+
+		metrics.Log.Info("Closing lobby", "game_id", id, "reason", reason)
+		if _, err := ExecuteScript(ctx, "close_lobby", []string{gameKey}, "system", reason); err != nil {
+			metrics.Log.Error("Failed to close lobby", "game_id", id, "error", err)
+			active++
+		}
+	}
+
+	if err := RDB.Set(ctx, activeLobbiesKey, active, 0).Err(); err != nil {
+		metrics.Log.Error("Failed to store active lobby count", "error", err)
+	}
+}
+
+func parseUnix(v interface{}) int64 {
+	s, ok := v.(string)
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
@@ -2,6 +2,8 @@ package gamestate
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,22 +12,37 @@ import (
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/redis/go-redis/v9"
+
+	"godra/internal/rbac"
 )
 
 var (
 	RDB     *redis.Client
 	scripts = make(map[string]*redis.Script)
-	permissions = make(map[string]string)
+	policies = make(map[string]ScriptPolicy)
 	mu          sync.RWMutex
 )
 
-func Init(addr string) error {
-	RDB = redis.NewClient(&redis.Options{
-		Addr: addr,
-	})
+// ScriptPolicy is who may call a script, read from its header:
+//
+//	-- ROLE: moderator, admin        any of these roles (or one inheriting from them)
+//	-- PERMISSION: lobby.kick        every listed permission
+//	-- INTERNAL                      only the server runs it; clients can't call it
+//
+// Scripts without a ROLE line require "player".
+type ScriptPolicy struct {
+	rbac.Requirement
+	Internal bool
+}
 
-	if err := RDB.Ping(context.Background()).Err(); err != nil {
-		return fmt.Errorf("failed to connect to redis: %w", err)
+var (
+	ErrScriptNotFound = errors.New("script not found")
+	ErrScriptInternal = errors.New("script is not callable by clients")
+)
+
+func Init(addr string) error {
+	if err := Connect(addr); err != nil {
+		return err
 	}
 
 	if err := LoadScripts(); err != nil {
@@ -38,6 +55,19 @@ func Init(addr string) error {
 	return nil
 }
 
+// Connect opens the Redis connection without loading scripts, for tools
+// that only need RDB.
+func Connect(addr string) error {
+	RDB = redis.NewClient(&redis.Options{
+		Addr: addr,
+	})
+
+	if err := RDB.Ping(context.Background()).Err(); err != nil {
+		return fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	return nil
+}
+
 func WatchScripts() {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -91,7 +121,7 @@ func LoadScripts() error {
 	defer mu.Unlock()
 
 	scripts = make(map[string]*redis.Script)
-	permissions = make(map[string]string)
+	policies = make(map[string]ScriptPolicy)
 
 	// Walk scripts directory
 	root := "scripts"
@@ -126,34 +156,72 @@ func LoadScripts() error {
 				return fmt.Errorf("failed to read script %s: %w", name, err)
 			}
 			
-			// Parse Metadata
-			// Look for line starting with "-- ROLE:"
-			role := "player" // Default
-			lines := strings.Split(string(content), "\n")
-			for _, line := range lines {
-				line = strings.TrimSpace(line)
-				if strings.HasPrefix(line, "-- ROLE:") {
-					role = strings.TrimSpace(strings.TrimPrefix(line, "-- ROLE:"))
-					break
-				}
-			}
-			
-			permissions[name] = role
+			policy := parsePolicy(string(content))
+			policies[name] = policy
 			scripts[name] = redis.NewScript(string(content))
-			fmt.Printf("Loaded script: %s (Role: %s)\n", name, role)
+			fmt.Printf("Loaded script: %s (Roles: %s, Permissions: %s, Internal: %v)\n", name,
+				strings.Join(policy.Roles, ","), strings.Join(policy.Permissions, ","), policy.Internal)
 		}
 	}
 
 	return nil
 }
 
-func GetScriptRole(scriptName string) string {
+// parsePolicy reads the access metadata from a script's leading comments.
+func parsePolicy(content string) ScriptPolicy {
+	var policy ScriptPolicy
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "-- ROLE:"):
+			policy.Roles = append(policy.Roles, splitMeta(strings.TrimPrefix(line, "-- ROLE:"))...)
+		case strings.HasPrefix(line, "-- PERMISSION:"):
+			policy.Permissions = append(policy.Permissions, splitMeta(strings.TrimPrefix(line, "-- PERMISSION:"))...)
+		case line == "-- INTERNAL":
+			policy.Internal = true
+		}
+	}
+	if len(policy.Roles) == 0 {
+		policy.Roles = []string{"player"} // Default
+	}
+	return policy
+}
+
+func splitMeta(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// AuthorizeScript checks whether a client with role may call a script. RPC
+// and WebSocket actions both go through it; the server's own calls don't.
+func AuthorizeScript(role, scriptName string) error {
 	mu.RLock()
-	defer mu.RUnlock()
-	if role, ok := permissions[scriptName]; ok {
-		return role
+	policy, ok := policies[scriptName]
+	mu.RUnlock()
+
+	if !ok {
+		return ErrScriptNotFound
+	}
+	if policy.Internal {
+		return ErrScriptInternal
 	}
-	return "player"
+	return rbac.Authorize(role, policy.Requirement)
+}
+
+// LookupScript returns a loaded script's policy.
+func LookupScript(name string) (ScriptPolicy, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	policy, ok := policies[name]
+	return policy, ok
 }
 
 func ExecuteScript(ctx context.Context, scriptName string, keys []string, args ...interface{}) (interface{}, error) {
@@ -171,3 +239,55 @@ func ExecuteScript(ctx context.Context, scriptName string, keys []string, args .
 func SubscribeToGame(ctx context.Context, gameID string) *redis.PubSub {
 	return RDB.Subscribe(ctx, "game_updates:game:"+gameID)
 }
+
+// PublishToUser delivers an event to every socket the user has open, on any node.
+func PublishToUser(ctx context.Context, userID string, payload []byte) error {
+	return RDB.Publish(ctx, "user_updates:"+userID, payload).Err()
+}
+
+// SubscribeToUsers listens for events addressed to individual users.
+// The channel suffix after "user_updates:" is the target user ID.
+func SubscribeToUsers(ctx context.Context) *redis.PubSub {
+	return RDB.PSubscribe(ctx, "user_updates:*")
+}
+
+// PublishToAll delivers an event to every connected socket on every node.
+func PublishToAll(ctx context.Context, payload []byte) error {
+	return RDB.Publish(ctx, "global_updates", payload).Err()
+}
+
+func SubscribeToAll(ctx context.Context) *redis.PubSub {
+	return RDB.Subscribe(ctx, "global_updates")
+}
+
+// ControlMessage is published on the "user_control" channel to act on a user's
+// sockets across every node.
+type ControlMessage struct {
+	Type   string `json:"type"` // "disconnect" or "reload_blocks"
+	UserID string `json:"user_id"`
+	GameID string `json:"game_id,omitempty"` // Only sockets in this game; empty for all
+	Reason string `json:"reason,omitempty"`
+}
+
+// DisconnectUser closes the user's sockets (optionally only those in gameID) on every node.
+func DisconnectUser(ctx context.Context, userID, gameID, reason string) error {
+	return SendControl(ctx, ControlMessage{
+		Type:   "disconnect",
+		UserID: userID,
+		GameID: gameID,
+		Reason: reason,
+	})
+}
+
+// SendControl publishes a control message to every node.
+func SendControl(ctx context.Context, msg ControlMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return RDB.Publish(ctx, "user_control", payload).Err()
+}
+
+func SubscribeToControl(ctx context.Context) *redis.PubSub {
+	return RDB.Subscribe(ctx, "user_control")
+}
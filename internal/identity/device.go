@@ -0,0 +1,73 @@
+package identity
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+
+	"godra/internal/database"
+)
+
+// Silent login
+//
+// Device login authenticates with an ID the client generates and keeps (e.g.
+// on a phone); custom login lets a trusted backend log a player in by their ID
+// in another system. Both are identities like any OIDC account, under the
+// "device" and "custom" providers, so a user can have several devices linked.
+// A device ID is as good as a password, so only its hash is stored (see
+// database.DeviceSubject) and it is never shown back.
+
+const (
+	DeviceProvider = "device"
+	CustomProvider = "custom"
+)
+
+// ServerKey protects custom login. Custom login is disabled while it's empty.
+var ServerKey string
+
+var (
+	ErrInvalidDeviceID = errors.New("device ID must be 10 to 128 characters")
+	ErrInvalidCustomID = errors.New("custom ID must be 1 to 128 characters")
+	ErrInvalidKey      = errors.New("invalid server key")
+	ErrCustomDisabled  = errors.New("custom login is not enabled")
+)
+
+// LoginDevice returns the user owning deviceID, registering a new player on
+// first use. username is only used for new accounts.
+func LoginDevice(ctx context.Context, deviceID, username string) (*database.User, bool, error) {
+	if len(deviceID) < 10 || len(deviceID) > 128 {
+		return nil, false, ErrInvalidDeviceID
+	}
+	return FindOrCreateUser(ctx, &External{Provider: DeviceProvider, Subject: database.DeviceSubject(deviceID), Username: username})
+}
+
+// LoginCustom checks the server key and returns the user with customID,
+// registering a new player on first use.
+func LoginCustom(ctx context.Context, key, customID, username string) (*database.User, bool, error) {
+	if ServerKey == "" {
+		return nil, false, ErrCustomDisabled
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(ServerKey)) != 1 {
+		return nil, false, ErrInvalidKey
+	}
+	if customID == "" || len(customID) > 128 {
+		return nil, false, ErrInvalidCustomID
+	}
+	return FindOrCreateUser(ctx, &External{Provider: CustomProvider, Subject: customID, Username: username})
+}
+
+// LinkDevice adds another device to a user's account.
+func LinkDevice(ctx context.Context, userID uint, deviceID string) (*database.Identity, error) {
+	if len(deviceID) < 10 || len(deviceID) > 128 {
+		return nil, ErrInvalidDeviceID
+	}
+	return Link(ctx, userID, &External{Provider: DeviceProvider, Subject: database.DeviceSubject(deviceID)})
+}
+
+// UnlinkDevice removes a single device from a user's account.
+func UnlinkDevice(ctx context.Context, userID uint, deviceID string) error {
+	if deviceID == "" {
+		return ErrNotLinked
+	}
+	return Unlink(ctx, userID, DeviceProvider, database.DeviceSubject(deviceID))
+}
@@ -0,0 +1,337 @@
+package identity
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"godra/internal/auth"
+	"godra/internal/database"
+	"godra/internal/metrics"
+	"godra/internal/social"
+)
+
+// LinkedIdentity is an identity as shown to its owner.
+type LinkedIdentity struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject,omitempty"` // Not shown for devices
+	Email    string    `json:"email,omitempty"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+// bindingCookie ties an authorization request to the browser that started it
+// (see login.go). It is only sent to the callback.
+const bindingCookie = "godra_oidc"
+
+type AuthorizeResponse struct {
+	URL   string `json:"url"`
+	State string `json:"state"`
+}
+
+// ProvidersHandler lists the identity providers users can log in with.
+func ProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Names())
+}
+
+// LoginHandler starts a login with {provider}. Browsers are redirected to the
+// provider; clients asking for JSON get the URL to open instead.
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	binding, err := setBindingCookie(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	url, state, err := Begin(r.Context(), chi.URLParam(r, "provider"), 0, binding)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(AuthorizeResponse{URL: url, State: state})
+		return
+	}
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// CallbackHandler completes a login or link started with {provider}. Logins
+// return a token pair; the account is created on first login.
+func CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	provider := chi.URLParam(r, "provider")
+	query := r.URL.Query()
+
+	if reason := query.Get("error"); reason != "" {
+		http.Error(w, "Login cancelled: "+reason, http.StatusUnauthorized)
+		return
+	}
+	if query.Get("state") == "" || query.Get("code") == "" {
+		http.Error(w, "Missing state or code", http.StatusBadRequest)
+		return
+	}
+
+	var binding string
+	if c, err := r.Cookie(bindingCookie); err == nil {
+		binding = c.Value
+	}
+	clearBindingCookie(w, r)
+
+	ext, linkTo, err := Complete(ctx, provider, query.Get("state"), query.Get("code"), binding)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if linkTo != 0 {
+		link, err := Link(ctx, linkTo, ext)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toLinked(link))
+		return
+	}
+
+	user, created, err := FindOrCreateUser(ctx, ext)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	respondLogin(w, r, user, created, provider)
+}
+
+// respondLogin answers a login through an identity. Accounts using 2FA get
+// a challenge instead of tokens.
+func respondLogin(w http.ResponseWriter, r *http.Request, user *database.User, created bool, provider string) {
+	status := http.StatusOK
+	if created {
+		metrics.Log.Info("Registered user from identity provider", "user_id", user.ID, "provider", provider)
+		status = http.StatusCreated
+	}
+	auth.RespondLogin(w, r, user, status)
+}
+
+// LinkHandler starts linking {provider} to the caller's account. The caller
+// opens the returned URL in the same browser; the callback then links instead
+// of logging in.
+func LinkHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	binding, err := setBindingCookie(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	url, state, err := Begin(r.Context(), chi.URLParam(r, "provider"), userID, binding)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuthorizeResponse{URL: url, State: state})
+}
+
+// ListHandler returns the identities linked to the caller's account.
+func ListHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	links, err := List(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to load identities", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]LinkedIdentity, 0, len(links))
+	for i := range links {
+		resp = append(resp, toLinked(&links[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// UnlinkHandler removes the caller's identities at {provider}.
+func UnlinkHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := Unlink(r.Context(), userID, chi.URLParam(r, "provider"), ""); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type DeviceRequest struct {
+	DeviceID string `json:"device_id"`
+	Username string `json:"username"` // Used when the device creates a new account
+}
+
+// DeviceLoginHandler logs in with a client-generated device ID, creating
+// the account on first use.
+func DeviceLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req DeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, created, err := LoginDevice(r.Context(), req.DeviceID, req.Username)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	respondLogin(w, r, user, created, DeviceProvider)
+}
+
+type CustomRequest struct {
+	ID       string `json:"id"` // The player's ID in the calling system
+	Username string `json:"username"`
+}
+
+// CustomLoginHandler logs a player in by their ID in a trusted backend. The
+// backend authenticates with the server key in the X-Server-Key header.
+func CustomLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req CustomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, created, err := LoginCustom(r.Context(), r.Header.Get("X-Server-Key"), req.ID, req.Username)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	respondLogin(w, r, user, created, CustomProvider)
+}
+
+// LinkDeviceHandler adds a device to the caller's account.
+func LinkDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	var req DeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	link, err := LinkDevice(r.Context(), userID, req.DeviceID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toLinked(link))
+}
+
+// UnlinkDeviceHandler removes {deviceID} from the caller's account.
+func UnlinkDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := UnlinkDevice(r.Context(), userID, chi.URLParam(r, "deviceID")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setBindingCookie gives the browser a fresh binding value. SameSite=Lax,
+// since the provider's redirect back to the callback is a cross-site navigation.
+func setBindingCookie(w http.ResponseWriter, r *http.Request) (string, error) {
+	binding, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     bindingCookie,
+		Value:    binding,
+		Path:     "/auth/",
+		MaxAge:   int(stateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return binding, nil
+}
+
+func clearBindingCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     bindingCookie,
+		Path:     "/auth/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+func toLinked(link *database.Identity) LinkedIdentity {
+	linked := LinkedIdentity{
+		Provider: link.Provider,
+		Subject:  link.Subject,
+		Email:    link.Email,
+		LinkedAt: link.CreatedAt,
+	}
+	if link.Provider == DeviceProvider {
+		linked.Subject = ""
+	}
+	return linked
+}
+
+func callerID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	claims := auth.ClaimsFromContext(r.Context())
+	id, err := social.ParseUserID(claims.UserID)
+	if err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return 0, false
+	}
+	return id, true
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownProvider), errors.Is(err, ErrNotLinked):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidState), errors.Is(err, ErrInvalidDeviceID), errors.Is(err, ErrInvalidCustomID):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrInvalidKey):
+		http.Error(w, "Invalid server key", http.StatusUnauthorized)
+	case errors.Is(err, ErrCustomDisabled):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrAlreadyLinked), errors.Is(err, ErrLastLogin):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrLoginFailed):
+		metrics.Log.Warn("External login rejected", "error", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+	default:
+		metrics.Log.Error("Identity request failed", "error", err)
+		http.Error(w, "Identity request failed", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,273 @@
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"godra/internal/auth"
+	"godra/internal/database"
+	"godra/internal/gamestate"
+)
+
+// Login flow
+//
+// Login starts an authorization request: the state, PKCE verifier and nonce
+// are kept in Redis under oidc:state:<state> until the provider redirects the
+// user back to the callback. The callback consumes the state, so each
+// authorization response can only be used once.
+//
+// The state is also bound to the browser that started the request: a random
+// value goes into an httpOnly cookie and its hash is stored with the state.
+// Without it, an attacker could start a login, hand the victim the callback
+// URL, and have the victim signed in as the attacker (or, when linking, get
+// the victim's identity linked to the attacker's account).
+
+const stateTTL = 10 * time.Minute
+
+var (
+	ErrInvalidState  = errors.New("invalid or expired state")
+	ErrAlreadyLinked = errors.New("identity already linked to another account")
+	ErrNotLinked     = errors.New("identity not linked")
+	ErrLastLogin     = errors.New("cannot remove the only way to log in")
+)
+
+type pendingLogin struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	LinkTo   uint   `json:"link_to,omitempty"` // Set when an existing user is linking the identity
+	Binding  string `json:"binding"`           // SHA-256 of the browser's binding cookie
+}
+
+// Begin starts an authorization request and returns the provider URL to send
+// the user to, and the state. A non-zero linkTo links the identity to that
+// user instead of logging in. binding is the value of the browser's binding
+// cookie; Complete must be given the same value.
+func Begin(ctx context.Context, provider string, linkTo uint, binding string) (string, string, error) {
+	p, err := Get(provider)
+	if err != nil {
+		return "", "", err
+	}
+	if binding == "" {
+		return "", "", ErrInvalidState
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	data, _ := json.Marshal(pendingLogin{Provider: provider, Verifier: verifier, Nonce: nonce, LinkTo: linkTo, Binding: hashBinding(binding)})
+	if err := gamestate.RDB.Set(ctx, "oidc:state:"+state, data, stateTTL).Err(); err != nil {
+		return "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	return p.AuthCodeURL(state, base64.RawURLEncoding.EncodeToString(challenge[:]), nonce), state, nil
+}
+
+// Complete finishes an authorization request for the browser holding
+// binding. It returns the verified identity and the pending request's link
+// target (zero for a login).
+func Complete(ctx context.Context, provider, state, code, binding string) (*External, uint, error) {
+	raw, err := gamestate.RDB.GetDel(ctx, "oidc:state:"+state).Result()
+	if err == redis.Nil {
+		return nil, 0, ErrInvalidState
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var pending pendingLogin
+	if err := json.Unmarshal([]byte(raw), &pending); err != nil || pending.Provider != provider {
+		return nil, 0, ErrInvalidState
+	}
+	if binding == "" || subtle.ConstantTimeCompare([]byte(hashBinding(binding)), []byte(pending.Binding)) != 1 {
+		return nil, 0, ErrInvalidState
+	}
+
+	p, err := Get(provider)
+	if err != nil {
+		return nil, 0, err
+	}
+	ext, err := p.Exchange(ctx, code, pending.Verifier, pending.Nonce)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ext, pending.LinkTo, nil
+}
+
+// FindOrCreateUser returns the user linked to ext, registering a new
+// player account on first login.
+func FindOrCreateUser(ctx context.Context, ext *External) (*database.User, bool, error) {
+	db := database.DB.WithContext(ctx)
+
+	var link database.Identity
+	err := db.Where("provider = ? AND subject = ?", ext.Provider, ext.Subject).First(&link).Error
+	if err == nil {
+		var user database.User
+		if err := db.First(&user, link.UserID).Error; err != nil {
+			return nil, false, err
+		}
+		if ext.Email != "" && ext.Email != link.Email {
+			db.Model(&link).Update("email", ext.Email)
+		}
+		return &user, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, err
+	}
+
+	var user database.User
+	err = db.Transaction(func(tx *gorm.DB) error {
+		username, err := availableUsername(tx, ext)
+		if err != nil {
+			return err
+		}
+		// No password: the account logs in through its linked identities
+		user = database.User{Username: username, Role: auth.DefaultRole}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return tx.Create(&database.Identity{
+			UserID:   user.ID,
+			Provider: ext.Provider,
+			Subject:  ext.Subject,
+			Email:    ext.Email,
+		}).Error
+	})
+	if err != nil {
+		// A concurrent first login may have created the account; use theirs
+		if db.Where("provider = ? AND subject = ?", ext.Provider, ext.Subject).First(&link).Error == nil {
+			if err := db.First(&user, link.UserID).Error; err == nil {
+				return &user, false, nil
+			}
+		}
+		return nil, false, err
+	}
+	return &user, true, nil
+}
+
+// Link attaches ext to an existing user.
+func Link(ctx context.Context, userID uint, ext *External) (*database.Identity, error) {
+	db := database.DB.WithContext(ctx)
+
+	var existing database.Identity
+	err := db.Where("provider = ? AND subject = ?", ext.Provider, ext.Subject).First(&existing).Error
+	if err == nil {
+		if existing.UserID != userID {
+			return nil, ErrAlreadyLinked
+		}
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	link := database.Identity{UserID: userID, Provider: ext.Provider, Subject: ext.Subject, Email: ext.Email}
+	if err := db.Create(&link).Error; err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// List returns the identities linked to a user.
+func List(ctx context.Context, userID uint) ([]database.Identity, error) {
+	var links []database.Identity
+	err := database.DB.WithContext(ctx).Where("user_id = ?", userID).Order("provider, id").Find(&links).Error
+	return links, err
+}
+
+// Unlink removes the user's identities at a provider, or only the one with
+// subject when it's set, unless the account would be left with no way to log in.
+func Unlink(ctx context.Context, userID uint, provider, subject string) error {
+	db := database.DB.WithContext(ctx)
+
+	var user database.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return err
+	}
+
+	matching := db.Model(&database.Identity{}).Where("user_id = ? AND provider = ?", userID, provider)
+	if subject != "" {
+		matching = matching.Where("subject = ?", subject)
+	}
+
+	var total, count int64
+	if err := db.Model(&database.Identity{}).Where("user_id = ?", userID).Count(&total).Error; err != nil {
+		return err
+	}
+	if err := matching.Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotLinked
+	}
+	if user.Password == "" && total == count {
+		return ErrLastLogin
+	}
+
+	return matching.Unscoped().Delete(&database.Identity{}).Error
+}
+
+var usernameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// availableUsername derives a free username from the provider's profile.
+func availableUsername(tx *gorm.DB, ext *External) (string, error) {
+	base := ext.Username
+	if base == "" && ext.Email != "" {
+		base = strings.SplitN(ext.Email, "@", 2)[0]
+	}
+	base = usernameInvalid.ReplaceAllString(base, "")
+	if len(base) > 24 {
+		base = base[:24]
+	}
+	if base == "" {
+		base = "player"
+	}
+
+	candidate := base
+	for attempt := 0; attempt < 10; attempt++ {
+		var count int64
+		if err := tx.Model(&database.User{}).Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = base + "_" + database.GenerateRandomString(4)
+	}
+	return base + "_" + database.GenerateRandomString(8), nil
+}
+
+func hashBinding(binding string) string {
+	sum := sha256.Sum256([]byte(binding))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,279 @@
+package identity
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCConfig describes an OpenID Connect client registration.
+type OIDCConfig struct {
+	Name         string // Provider name used in URLs, e.g. "studio"
+	Issuer       string // Discovery runs against <Issuer>/.well-known/openid-configuration
+	ClientID     string
+	ClientSecret string // Optional for public clients; PKCE is always used
+	RedirectURL  string // Godra's callback, e.g. https://game.example.com/auth/studio/callback
+	Scopes       []string
+}
+
+// OIDC is a generic OpenID Connect provider using the authorization code flow with PKCE.
+type OIDC struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	authURL  string
+	tokenURL string
+	jwksURL  string
+
+	mu          sync.Mutex
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+// Refetch the issuer's keys at most this often when a token names an unknown kid.
+const jwksMinRefresh = time.Minute
+
+// NewOIDC discovers the issuer's endpoints.
+func NewOIDC(ctx context.Context, cfg OIDCConfig) (*OIDC, error) {
+	if cfg.Name == "" || cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("oidc: name, issuer, client ID and redirect URL are required")
+	}
+	if cfg.Name == DeviceProvider || cfg.Name == CustomProvider {
+		return nil, fmt.Errorf("oidc: provider name %q is reserved", cfg.Name)
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+
+	p := &OIDC{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &discovery); err != nil {
+		return nil, fmt.Errorf("oidc %s: discovery: %w", cfg.Name, err)
+	}
+	if discovery.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("oidc %s: discovery returned issuer %q", cfg.Name, discovery.Issuer)
+	}
+
+	p.authURL = discovery.AuthorizationEndpoint
+	p.tokenURL = discovery.TokenEndpoint
+	p.jwksURL = discovery.JWKSURI
+	return p, nil
+}
+
+func (p *OIDC) Name() string { return p.cfg.Name }
+
+func (p *OIDC) AuthCodeURL(state, codeChallenge, nonce string) string {
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+	return p.authURL + sep + q.Encode()
+}
+
+func (p *OIDC) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*External, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: token endpoint returned %s", ErrLoginFailed, resp.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: token response has no id_token", ErrLoginFailed)
+	}
+	return p.verifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+type idTokenClaims struct {
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	EmailVerified     *bool  `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+// verifyIDToken checks the ID token's signature against the issuer's JWKS, and
+// its issuer, audience, expiry and nonce.
+func (p *OIDC) verifyIDToken(ctx context.Context, raw, nonce string) (*External, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithIssuer(p.cfg.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid id_token: %v", ErrLoginFailed, err)
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: id_token nonce mismatch", ErrLoginFailed)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: id_token has no subject", ErrLoginFailed)
+	}
+
+	ext := &External{
+		Provider: p.cfg.Name,
+		Subject:  claims.Subject,
+		Username: claims.PreferredUsername,
+	}
+	// Unverified addresses aren't trusted as contact details
+	if claims.EmailVerified == nil || *claims.EmailVerified {
+		ext.Email = claims.Email
+	}
+	if ext.Username == "" {
+		ext.Username = claims.Name
+	}
+	return ext, nil
+}
+
+// key returns the issuer's verification key for kid, refetching the key set
+// when the kid is unknown (the issuer rotated keys).
+func (p *OIDC) key(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k := p.lookup(kid); k != nil {
+		return k, nil
+	}
+	if time.Since(p.keysFetched) < jwksMinRefresh {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.jwksURL, &set); err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+
+	if k := p.lookup(kid); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// lookup finds a cached key. Tokens without a kid match when the issuer has a single key.
+func (p *OIDC) lookup(kid string) interface{} {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k
+		}
+	}
+	return p.keys[kid]
+}
+
+func (p *OIDC) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
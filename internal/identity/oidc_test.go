@@ -0,0 +1,293 @@
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIssuer is a minimal OpenID Connect issuer: discovery, a JWKS that can
+// be rotated, and a token endpoint that enforces PKCE.
+type mockIssuer struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu     sync.Mutex
+	keys   map[string]*rsa.PrivateKey // Published in the JWKS unless hidden
+	hidden map[string]bool
+	signer string // kid used to sign ID tokens
+	codes  map[string]authRequest
+
+	// Applied to the ID token claims before signing
+	tamper func(claims jwt.MapClaims)
+}
+
+type authRequest struct {
+	challenge string
+	nonce     string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	m := &mockIssuer{t: t, keys: map[string]*rsa.PrivateKey{}, hidden: map[string]bool{}, codes: map[string]authRequest{}}
+	m.rotate("k1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.srv.URL,
+			"authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint":         m.srv.URL + "/token",
+			"jwks_uri":               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", m.jwks)
+	mux.HandleFunc("/token", m.token)
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+// rotate publishes a new key and signs with it from now on. Older keys stay
+// published, as issuers do during a rotation.
+func (m *mockIssuer) rotate(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.keys[kid] = key
+	m.signer = kid
+	m.mu.Unlock()
+}
+
+// authorize stands in for the user approving the request at authURL and
+// returns the code the issuer would redirect back with.
+func (m *mockIssuer) authorize(authURL string) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		m.t.Fatalf("code_challenge_method = %q, want S256", q.Get("code_challenge_method"))
+	}
+	code := "code-" + q.Get("state")
+	m.mu.Lock()
+	m.codes[code] = authRequest{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	m.mu.Unlock()
+	return code
+}
+
+func (m *mockIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []map[string]string
+	for kid, k := range m.keys {
+		if m.hidden[kid] {
+			continue
+		}
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	m.mu.Lock()
+	req, ok := m.codes[r.Form.Get("code")]
+	delete(m.codes, r.Form.Get("code"))
+	key, kid := m.keys[m.signer], m.signer
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != req.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":                m.srv.URL,
+		"aud":                "godra",
+		"sub":                "user-1",
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              req.nonce,
+		"email":              "ada@example.com",
+		"email_verified":     true,
+		"preferred_username": "ada",
+	}
+	if m.tamper != nil {
+		m.tamper(claims)
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = kid
+	signed, err := tok.SignedString(key)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+func newTestProvider(t *testing.T, m *mockIssuer) *OIDC {
+	p, err := NewOIDC(context.Background(), OIDCConfig{
+		Name:        "mock",
+		Issuer:      m.srv.URL,
+		ClientID:    "godra",
+		RedirectURL: "http://localhost/auth/mock/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// login runs the flow the way Begin and Complete do: a fresh verifier and
+// nonce, the S256 challenge in the authorization URL, then the code exchange.
+func login(t *testing.T, m *mockIssuer, p *OIDC, verifierFor func(string) string, nonceFor func(string) string) (*External, error) {
+	verifier, _ := randomString()
+	nonce, _ := randomString()
+	challenge := sha256.Sum256([]byte(verifier))
+	code := m.authorize(p.AuthCodeURL("st", base64.RawURLEncoding.EncodeToString(challenge[:]), nonce))
+
+	if verifierFor != nil {
+		verifier = verifierFor(verifier)
+	}
+	if nonceFor != nil {
+		nonce = nonceFor(nonce)
+	}
+	return p.Exchange(context.Background(), code, verifier, nonce)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	m := newMockIssuer(t)
+	p := newTestProvider(t, m)
+
+	ext, err := login(t, m, p, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext.Provider != "mock" || ext.Subject != "user-1" || ext.Email != "ada@example.com" || ext.Username != "ada" {
+		t.Fatalf("unexpected identity %+v", ext)
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	m := newMockIssuer(t)
+	_, err := NewOIDC(context.Background(), OIDCConfig{
+		Name:        "mock",
+		Issuer:      m.srv.URL + "/other",
+		ClientID:    "godra",
+		RedirectURL: "http://localhost/auth/mock/callback",
+	})
+	if err == nil {
+		t.Fatal("expected discovery to fail for a different issuer")
+	}
+}
+
+func TestOIDCRejectsWrongVerifier(t *testing.T) {
+	m := newMockIssuer(t)
+	p := newTestProvider(t, m)
+
+	_, err := login(t, m, p, func(string) string { return "not-the-verifier" }, nil)
+	if !errors.Is(err, ErrLoginFailed) {
+		t.Fatalf("err = %v, want ErrLoginFailed", err)
+	}
+}
+
+func TestOIDCRejectsWrongNonce(t *testing.T) {
+	m := newMockIssuer(t)
+	p := newTestProvider(t, m)
+
+	_, err := login(t, m, p, nil, func(string) string { return "another-nonce" })
+	if !errors.Is(err, ErrLoginFailed) {
+		t.Fatalf("err = %v, want ErrLoginFailed", err)
+	}
+}
+
+func TestOIDCRejectsInvalidClaims(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(jwt.MapClaims)
+	}{
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "someone-else" }},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockIssuer(t)
+			p := newTestProvider(t, m)
+			m.tamper = tt.tamper
+
+			_, err := login(t, m, p, nil, nil)
+			if !errors.Is(err, ErrLoginFailed) {
+				t.Fatalf("err = %v, want ErrLoginFailed", err)
+			}
+		})
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	m := newMockIssuer(t)
+	p := newTestProvider(t, m)
+
+	if _, err := login(t, m, p, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new kid right after a fetch is refused rather than refetching on every token
+	m.rotate("k2")
+	if _, err := login(t, m, p, nil, nil); !errors.Is(err, ErrLoginFailed) {
+		t.Fatalf("err = %v, want ErrLoginFailed within the refresh interval", err)
+	}
+
+	// Once the interval has passed, the unknown kid triggers a refetch
+	p.mu.Lock()
+	p.keysFetched = time.Now().Add(-2 * jwksMinRefresh)
+	p.mu.Unlock()
+	if _, err := login(t, m, p, nil, nil); err != nil {
+		t.Fatalf("login after rotation: %v", err)
+	}
+}
+
+func TestOIDCRejectsUnpublishedKey(t *testing.T) {
+	m := newMockIssuer(t)
+	p := newTestProvider(t, m)
+
+	// Signed with a key the issuer's JWKS never lists, even after a refetch
+	m.rotate("rogue")
+	m.mu.Lock()
+	m.hidden["rogue"] = true
+	m.mu.Unlock()
+	p.mu.Lock()
+	p.keysFetched = time.Time{}
+	p.mu.Unlock()
+
+	if _, err := login(t, m, p, nil, nil); !errors.Is(err, ErrLoginFailed) {
+		t.Fatalf("err = %v, want ErrLoginFailed", err)
+	}
+}
@@ -0,0 +1,58 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// Provider is an external identity provider users can log in with.
+type Provider interface {
+	Name() string
+
+	// AuthCodeURL returns the URL the user is sent to. codeChallenge is the
+	// PKCE S256 challenge; nonce must come back in the ID token.
+	AuthCodeURL(state, codeChallenge, nonce string) string
+
+	// Exchange redeems the authorization code and returns the verified user.
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (*External, error)
+}
+
+// External is a user as reported by a provider.
+type External struct {
+	Provider string
+	Subject  string // Stable user ID at the provider
+	Email    string
+	Username string // Preferred username, used when creating an account
+}
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	ErrLoginFailed     = errors.New("login rejected") // The provider refused the code or returned an invalid token
+)
+
+var providers = map[string]Provider{}
+
+// Register makes a provider available for login. Call before serving requests.
+func Register(p Provider) {
+	providers[p.Name()] = p
+}
+
+// Get returns a registered provider.
+func Get(name string) (Provider, error) {
+	p, ok := providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+// Names lists the registered providers.
+func Names() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,390 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// Options configures a load test run against a Godra server.
+type Options struct {
+	BaseURL         string        // e.g. http://localhost:8080
+	Lobbies         int           // Number of lobbies to create
+	PlayersPerLobby int           // Guest clients connected to each lobby
+	ActionRate      float64       // Actions per second, per client
+	Duration        time.Duration // How long clients send actions once connected
+	RampUp          time.Duration // Spread connection attempts over this window
+	Timeout         time.Duration // Per-request timeout
+	RedisAddr       string        // If set, lobbies created by the run are deleted afterwards
+}
+
+// Message prefix used to recognise our own chat messages when they are broadcast back.
+const markerPrefix = "loadtest:"
+
+type client struct {
+	token  string
+	userID string
+	gameID string
+	conn   *websocket.Conn
+}
+
+type runner struct {
+	opts   Options
+	wsURL  string
+	http   *http.Client
+	report *Report
+	runID  string
+}
+
+// Run creates the lobbies, connects the clients, drives actions for the configured
+// duration and returns the collected measurements.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	if opts.Lobbies <= 0 || opts.PlayersPerLobby <= 0 {
+		return nil, fmt.Errorf("lobbies and players per lobby must be positive")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	opts.BaseURL = strings.TrimSuffix(opts.BaseURL, "/")
+
+	wsURL, err := toWebSocketURL(opts.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	total := opts.Lobbies * opts.PlayersPerLobby
+	r := &runner{
+		opts:  opts,
+		wsURL: wsURL,
+		http: &http.Client{
+			Timeout: opts.Timeout,
+			Transport: &http.Transport{
+				MaxIdleConns:        total,
+				MaxIdleConnsPerHost: total,
+			},
+		},
+		report: newReport(),
+		runID:  strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+
+	start := time.Now()
+
+	// 1. Create lobbies, each owned by a fresh guest who also plays in it.
+	owners := make([]*client, opts.Lobbies)
+	for i := range owners {
+		owner, err := r.guestLogin(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("guest login for lobby owner: %w", err)
+		}
+		owner.gameID = fmt.Sprintf("lt-%s-%d", r.runID, i)
+		if err := r.createLobby(ctx, owner); err != nil {
+			return nil, fmt.Errorf("create lobby %s: %w", owner.gameID, err)
+		}
+		owners[i] = owner
+	}
+	r.report.LobbiesCreated = len(owners)
+
+	// 2. Connect every client, spreading attempts over the ramp-up window.
+	clients := make([]*client, 0, total)
+	var clientsMu sync.Mutex
+	var wg sync.WaitGroup
+
+	var step time.Duration
+	if opts.RampUp > 0 {
+		step = opts.RampUp / time.Duration(total)
+	}
+
+	n := 0
+	for _, owner := range owners {
+		for p := 0; p < opts.PlayersPerLobby; p++ {
+			c := owner
+			if p > 0 {
+				c = &client{gameID: owner.gameID}
+			}
+
+			wg.Add(1)
+			go func(c *client, delay time.Duration) {
+				defer wg.Done()
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+				if c.token == "" {
+					g, err := r.guestLogin(ctx)
+					if err != nil {
+						r.report.connectFailed()
+						return
+					}
+					c.token, c.userID = g.token, g.userID
+				}
+				if err := r.connect(ctx, c); err != nil {
+					r.report.connectFailed()
+					return
+				}
+				clientsMu.Lock()
+				clients = append(clients, c)
+				clientsMu.Unlock()
+			}(c, step*time.Duration(n))
+			n++
+		}
+	}
+	wg.Wait()
+
+	// 3. Drive actions and read broadcasts until the deadline.
+	runCtx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	var readers sync.WaitGroup
+	for _, c := range clients {
+		readers.Add(1)
+		go func(c *client) {
+			defer readers.Done()
+			r.readLoop(runCtx, c)
+		}(c)
+
+		wg.Add(1)
+		go func(c *client) {
+			defer wg.Done()
+			r.actionLoop(runCtx, c)
+		}(c)
+	}
+
+	wg.Wait()
+	<-runCtx.Done()
+
+	// Give in-flight broadcasts a moment to land before closing sockets.
+	time.Sleep(500 * time.Millisecond)
+	for _, c := range clients {
+		c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		c.conn.Close()
+	}
+	readers.Wait()
+
+	r.report.Elapsed = time.Since(start)
+
+	if opts.RedisAddr != "" {
+		if err := r.cleanup(owners); err != nil {
+			return r.report, fmt.Errorf("cleanup: %w", err)
+		}
+	}
+	return r.report, nil
+}
+
+// cleanup closes the lobbies created by this run through the close_lobby
+// script, so repeated runs leave no lobbies or lobby browser entries behind.
+func (r *runner) cleanup(owners []*client) error {
+	source, err := os.ReadFile("scripts/close_lobby.lua")
+	if err != nil {
+		return err
+	}
+	closeLobby := redis.NewScript(string(source))
+
+	rdb := redis.NewClient(&redis.Options{Addr: r.opts.RedisAddr})
+	defer rdb.Close()
+
+	ctx := context.Background()
+	for _, owner := range owners {
+		if err := closeLobby.Run(ctx, rdb, []string{"game:" + owner.gameID}, owner.userID, "loadtest").Err(); err != nil {
+			return fmt.Errorf("close lobby %s: %w", owner.gameID, err)
+		}
+	}
+	return nil
+}
+
+func (r *runner) guestLogin(ctx context.Context) (*client, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.opts.BaseURL+"/guest-login", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Token  string `json:"token"`
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return &client{token: body.Token, userID: body.UserID}, nil
+}
+
+func (r *runner) createLobby(ctx context.Context, owner *client) error {
+	gameKey := "game:" + owner.gameID
+	_, err := r.rpc(ctx, owner.token, "create_lobby",
+		[]string{gameKey}, strconv.Itoa(r.opts.PlayersPerLobby))
+	return err
+}
+
+func (r *runner) rpc(ctx context.Context, token, script string, keys []string, args ...interface{}) (json.RawMessage, error) {
+	if keys == nil {
+		keys = []string{}
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"script": script,
+		"args":   args,
+		"keys":   keys,
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.opts.BaseURL+"/api/rpc", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("rpc %s: %d %s", script, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var body struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Result, nil
+}
+
+func (r *runner) connect(ctx context.Context, c *client) error {
+	q := url.Values{}
+	q.Set("token", c.token)
+	q.Set("game_id", c.gameID)
+
+	dialer := websocket.Dialer{HandshakeTimeout: r.opts.Timeout}
+
+	start := time.Now()
+	conn, _, err := dialer.DialContext(ctx, r.wsURL+"/ws?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	r.report.ConnectLatency.add(time.Since(start))
+
+	c.conn = conn
+	return nil
+}
+
+// actionLoop sends chat actions through the send_chat script at the configured rate,
+// timing each round trip. The send time is embedded in the message so readers can
+// measure how long the broadcast took to reach them.
+func (r *runner) actionLoop(ctx context.Context, c *client) {
+	if r.opts.ActionRate <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / r.opts.ActionRate))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			msg := markerPrefix + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+			start := time.Now()
+			_, err := r.rpc(ctx, c.token, "send_chat", nil, msg, c.gameID)
+			if err != nil {
+				if ctx.Err() == nil {
+					r.report.actionFailed()
+				}
+				continue
+			}
+			r.report.ScriptLatency.add(time.Since(start))
+			r.report.actionSent()
+		}
+	}
+}
+
+type event struct {
+	Type    string          `json:"type"`
+	Events  []event         `json:"events"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func (r *runner) readLoop(ctx context.Context, c *client) {
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			// Anything other than us closing the socket after the run counts as a drop.
+			if ctx.Err() == nil {
+				r.report.connectionDropped()
+			}
+			return
+		}
+
+		var ev event
+		if err := json.Unmarshal(data, &ev); err != nil {
+			continue
+		}
+		received := time.Now()
+		if ev.Type == "batch" {
+			for _, e := range ev.Events {
+				r.recordBroadcast(e, received)
+			}
+		} else {
+			r.recordBroadcast(ev, received)
+		}
+	}
+}
+
+func (r *runner) recordBroadcast(ev event, received time.Time) {
+	if ev.Type != "chat" {
+		return
+	}
+	var chat struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(ev.Payload, &chat); err != nil || !strings.HasPrefix(chat.Message, markerPrefix) {
+		return
+	}
+	sentNanos, err := strconv.ParseInt(strings.TrimPrefix(chat.Message, markerPrefix), 10, 64)
+	if err != nil {
+		return
+	}
+	r.report.FanOutLatency.add(received.Sub(time.Unix(0, sentNanos)))
+	r.report.broadcastReceived()
+}
+
+func toWebSocketURL(base string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid server url: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
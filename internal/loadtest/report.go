@@ -0,0 +1,97 @@
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Report holds the measurements collected during a run.
+type Report struct {
+	LobbiesCreated int
+	Elapsed        time.Duration
+
+	ConnectLatency *Histogram
+	ScriptLatency  *Histogram
+	FanOutLatency  *Histogram
+
+	ConnectFailures    atomic.Int64
+	DroppedConnections atomic.Int64
+	ActionsSent        atomic.Int64
+	ActionFailures     atomic.Int64
+	BroadcastsReceived atomic.Int64
+}
+
+func newReport() *Report {
+	return &Report{
+		ConnectLatency: &Histogram{},
+		ScriptLatency:  &Histogram{},
+		FanOutLatency:  &Histogram{},
+	}
+}
+
+func (r *Report) connectFailed()     { r.ConnectFailures.Add(1) }
+func (r *Report) connectionDropped() { r.DroppedConnections.Add(1) }
+func (r *Report) actionSent()        { r.ActionsSent.Add(1) }
+func (r *Report) actionFailed()      { r.ActionFailures.Add(1) }
+func (r *Report) broadcastReceived() { r.BroadcastsReceived.Add(1) }
+
+// Print writes a human readable summary of the run.
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Load test finished in %s\n\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Lobbies created:      %d\n", r.LobbiesCreated)
+	fmt.Fprintf(w, "Connections:          %d ok, %d failed, %d dropped\n",
+		r.ConnectLatency.Count(), r.ConnectFailures.Load(), r.DroppedConnections.Load())
+	fmt.Fprintf(w, "Actions:              %d ok, %d failed\n", r.ActionsSent.Load(), r.ActionFailures.Load())
+	fmt.Fprintf(w, "Broadcasts received:  %d\n\n", r.BroadcastsReceived.Load())
+
+	fmt.Fprintf(w, "%-20s %8s %10s %10s %10s %10s\n", "latency", "count", "p50", "p90", "p99", "max")
+	r.ConnectLatency.print(w, "connect")
+	r.ScriptLatency.print(w, "script round-trip")
+	r.FanOutLatency.print(w, "broadcast fan-out")
+}
+
+// Histogram keeps every sample so exact percentiles can be reported.
+type Histogram struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+func (h *Histogram) add(d time.Duration) {
+	h.mu.Lock()
+	h.samples = append(h.samples, d)
+	h.mu.Unlock()
+}
+
+// Count returns the number of recorded samples.
+func (h *Histogram) Count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.samples)
+}
+
+// Percentile returns the sample at percentile p (0-100), or zero if empty.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.samples) == 0 {
+		return 0
+	}
+	sort.Slice(h.samples, func(i, j int) bool { return h.samples[i] < h.samples[j] })
+
+	idx := int(float64(len(h.samples)-1) * p / 100)
+	return h.samples[idx]
+}
+
+func (h *Histogram) print(w io.Writer, name string) {
+	fmt.Fprintf(w, "%-20s %8d %10s %10s %10s %10s\n", name, h.Count(),
+		round(h.Percentile(50)), round(h.Percentile(90)), round(h.Percentile(99)), round(h.Percentile(100)))
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
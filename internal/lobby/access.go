@@ -0,0 +1,355 @@
+package lobby
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+
+	"godra/internal/auth"
+	"godra/internal/gamestate"
+)
+
+// Access control for lobbies.
+//
+// Lobby hash fields:  visibility ("public", "unlisted", "private"), password_hash, invite_code
+// game:<id>:allowed   set of user IDs invited to a private lobby
+// game:<id>:grant:<u> short-lived key written by Authorize and consumed by join_lobby/on_connect
+// lobby_invite:<code> lobby ID the invite code resolves to
+//
+// bcrypt can't run inside Redis, so the password and invite checks happen here and the
+// scripts only verify that a grant exists.
+
+var (
+	ErrLobbyNotFound    = errors.New("lobby not found")
+	ErrAccessDenied     = errors.New("this lobby is private")
+	ErrPasswordRequired = errors.New("password required")
+	ErrWrongPassword    = errors.New("wrong password")
+)
+
+const (
+	grantTTL = 30 * time.Second
+
+	// Invite codes avoid look-alike characters (0/O, 1/I/L) so they can be read out loud
+	inviteAlphabet   = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+	inviteCodeLength = 6
+)
+
+// Authorize checks whether the user may join the lobby and, for private or
+// password protected lobbies, issues the grant the join scripts require.
+// Existing players (reconnecting) and the owner always pass.
+func Authorize(ctx context.Context, gameID, userID, inviteCode, password string) error {
+	gameKey := "game:" + gameID
+
+	fields, err := gamestate.RDB.HMGet(ctx, gameKey, "owner", "visibility", "password_hash", "invite_code").Result()
+	if err != nil {
+		return err
+	}
+	if fields[0] == nil {
+		return ErrLobbyNotFound
+	}
+	owner, _ := fields[0].(string)
+	visibility, _ := fields[1].(string)
+	passwordHash, _ := fields[2].(string)
+	code, _ := fields[3].(string)
+
+	if owner == userID {
+		return grant(ctx, gameKey, userID)
+	}
+	member, err := gamestate.RDB.SIsMember(ctx, gameKey+":players", userID).Result()
+	if err != nil {
+		return err
+	}
+	if member {
+		return nil
+	}
+
+	allowed, err := gamestate.RDB.SIsMember(ctx, gameKey+":allowed", userID).Result()
+	if err != nil {
+		return err
+	}
+	hasCode := inviteCode != "" && code != "" && strings.EqualFold(inviteCode, code)
+
+	if visibility == "private" && !allowed && !hasCode {
+		return ErrAccessDenied
+	}
+
+	// Players on the allow-list were invited by name and skip the password
+	if passwordHash != "" && !allowed {
+		if password == "" {
+			return ErrPasswordRequired
+		}
+		if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+			return ErrWrongPassword
+		}
+	}
+
+	if visibility == "private" || passwordHash != "" {
+		return grant(ctx, gameKey, userID)
+	}
+	return nil
+}
+
+func grant(ctx context.Context, gameKey, userID string) error {
+	return gamestate.RDB.Set(ctx, gameKey+":grant:"+userID, 1, grantTTL).Err()
+}
+
+// isInvited reports whether the user can see a private lobby: the owner, its
+// players and anyone on the allow-list.
+func isInvited(ctx context.Context, gameID, userID string) bool {
+	gameKey := "game:" + gameID
+	if owner, _ := gamestate.RDB.HGet(ctx, gameKey, "owner").Result(); owner == userID {
+		return true
+	}
+	if ok, _ := gamestate.RDB.SIsMember(ctx, gameKey+":players", userID).Result(); ok {
+		return true
+	}
+	ok, _ := gamestate.RDB.SIsMember(ctx, gameKey+":allowed", userID).Result()
+	return ok
+}
+
+func isOwner(ctx context.Context, gameID, userID string) (bool, error) {
+	owner, err := gamestate.RDB.HGet(ctx, "game:"+gameID, "owner").Result()
+	if err == redis.Nil {
+		return false, ErrLobbyNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	return owner == userID, nil
+}
+
+// ensureInviteCode returns the lobby's invite code, creating one if needed.
+func ensureInviteCode(ctx context.Context, gameID string) (string, error) {
+	gameKey := "game:" + gameID
+	code, err := gamestate.RDB.HGet(ctx, gameKey, "invite_code").Result()
+	if err == nil && code != "" {
+		return code, nil
+	}
+
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err = randomCode()
+		if err != nil {
+			return "", err
+		}
+		ok, err := gamestate.RDB.SetNX(ctx, "lobby_invite:"+code, gameID, 0).Result()
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return code, gamestate.RDB.HSet(ctx, gameKey, "invite_code", code).Err()
+		}
+	}
+	return "", errors.New("could not allocate invite code")
+}
+
+func randomCode() (string, error) {
+	b := make([]byte, inviteCodeLength)
+	max := big.NewInt(int64(len(inviteAlphabet)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = inviteAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+type AccessRequest struct {
+	Visibility   string   `json:"visibility"`    // "public", "unlisted" or "private"; empty leaves it unchanged
+	Password     *string  `json:"password"`      // Empty string removes the password
+	AllowedUsers []string `json:"allowed_users"` // Replaces the allow-list when present
+}
+
+type AccessResponse struct {
+	GameID     string `json:"game_id"`
+	Visibility string `json:"visibility"`
+	InviteCode string `json:"invite_code,omitempty"`
+}
+
+// UpdateAccessHandler changes a lobby's visibility, password and allow-list. Owner only.
+func UpdateAccessHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := auth.ClaimsFromContext(ctx)
+	gameID := chi.URLParam(r, "gameID")
+	gameKey := "game:" + gameID
+
+	if !requireOwner(w, r, gameID, claims.UserID) {
+		return
+	}
+
+	var req AccessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	switch req.Visibility {
+	case "", "public", "unlisted", "private":
+	default:
+		http.Error(w, "Invalid visibility", http.StatusBadRequest)
+		return
+	}
+
+	var passwordHash string
+	if req.Password != nil && *req.Password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(*req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, "Error processing password", http.StatusInternalServerError)
+			return
+		}
+		passwordHash = string(hashed)
+	}
+
+	_, err := gamestate.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if req.Visibility != "" {
+			pipe.HSet(ctx, gameKey, "visibility", req.Visibility)
+			if req.Visibility == "public" {
+				pipe.SAdd(ctx, publicKey, gameID)
+			} else {
+				pipe.SRem(ctx, publicKey, gameID)
+			}
+		}
+		if req.Password != nil {
+			pipe.HSet(ctx, gameKey, "password_hash", passwordHash)
+		}
+		if req.AllowedUsers != nil {
+			pipe.Del(ctx, gameKey+":allowed")
+			for _, userID := range req.AllowedUsers {
+				pipe.SAdd(ctx, gameKey+":allowed", userID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Failed to update lobby", http.StatusInternalServerError)
+		return
+	}
+
+	resp := AccessResponse{GameID: gameID}
+	resp.Visibility, _ = gamestate.RDB.HGet(ctx, gameKey, "visibility").Result()
+	if resp.Visibility == "" {
+		resp.Visibility = "public"
+	}
+	if resp.Visibility != "public" {
+		if resp.InviteCode, err = ensureInviteCode(ctx, gameID); err != nil {
+			http.Error(w, "Failed to create invite code", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// InviteHandler returns (creating if needed) the lobby's invite code. Owner only.
+func InviteHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+	gameID := chi.URLParam(r, "gameID")
+
+	if !requireOwner(w, r, gameID, claims.UserID) {
+		return
+	}
+
+	code, err := ensureInviteCode(r.Context(), gameID)
+	if err != nil {
+		http.Error(w, "Failed to create invite code", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"game_id": gameID, "invite_code": code})
+}
+
+// ResolveInviteHandler turns an invite code into the lobby it points at.
+func ResolveInviteHandler(w http.ResponseWriter, r *http.Request) {
+	code := strings.ToUpper(chi.URLParam(r, "code"))
+
+	gameID, err := gamestate.RDB.Get(r.Context(), "lobby_invite:"+code).Result()
+	if err == redis.Nil {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to resolve invite", http.StatusInternalServerError)
+		return
+	}
+
+	summaries, err := loadSummaries(r.Context(), []candidate{{id: gameID}})
+	if err != nil || len(summaries) == 0 {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaries[0])
+}
+
+type JoinRequest struct {
+	InviteCode string `json:"invite_code"`
+	Password   string `json:"password"`
+}
+
+// JoinHandler checks access and reserves a slot through join_lobby.
+func JoinHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := auth.ClaimsFromContext(ctx)
+	gameID := chi.URLParam(r, "gameID")
+
+	// The body is optional for public lobbies
+	var req JoinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := Authorize(ctx, gameID, claims.UserID, req.InviteCode, req.Password); err != nil {
+		WriteAccessError(w, err)
+		return
+	}
+
+	if _, err := gamestate.ExecuteScript(ctx, "join_lobby", []string{"game:" + gameID}, claims.UserID); err != nil {
+		http.Error(w, "Join failed: "+err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WriteAccessError maps Authorize errors to HTTP responses.
+func WriteAccessError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrLobbyNotFound:
+		http.Error(w, "Lobby not found", http.StatusNotFound)
+	case ErrAccessDenied, ErrPasswordRequired, ErrWrongPassword:
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "Failed to check lobby access", http.StatusInternalServerError)
+	}
+}
+
+func requireOwner(w http.ResponseWriter, r *http.Request, gameID, userID string) bool {
+	owner, err := isOwner(r.Context(), gameID, userID)
+	if err == ErrLobbyNotFound {
+		http.Error(w, "Lobby not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Failed to load lobby", http.StatusInternalServerError)
+		return false
+	}
+	if !owner {
+		http.Error(w, "Forbidden: only the lobby owner can do that", http.StatusForbidden)
+		return false
+	}
+	return true
+}
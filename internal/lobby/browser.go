@@ -0,0 +1,299 @@
+package lobby
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+
+	"godra/internal/auth"
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+)
+
+// Redis index maintained by create_lobby, join_lobby, on_connect and leave_lobby:
+//
+//	lobbies:index            sorted set of lobby IDs scored by created_at
+//	lobbies:public           set of lobby IDs shown in the browser
+//	lobbies:free             sorted set of lobby IDs scored by free slots
+//	lobbies:status:<status>  set of lobby IDs
+//	lobbies:mode:<mode>      set of lobby IDs
+//	lobbies:region:<region>  set of lobby IDs
+//	lobbies:modes            set of every mode used, so close_lobby can find
+//	lobbies:regions          the sets of a lobby whose hash is gone
+//	user:<uid>:lobbies       set of the lobby IDs a user is a player in
+const (
+	indexKey  = "lobbies:index"
+	freeKey   = "lobbies:free"
+	publicKey = "lobbies:public"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type Summary struct {
+	GameID           string `json:"game_id"`
+	Owner            string `json:"owner"`
+	Status           string `json:"status"`
+	Mode             string `json:"mode"`
+	Region           string `json:"region"`
+	Visibility       string `json:"visibility"`
+	PasswordRequired bool   `json:"password_required"`
+	Capacity         int    `json:"capacity"`
+	Players          int    `json:"players"`
+	FreeSlots        int    `json:"free_slots"`
+	CreatedAt        int64  `json:"created_at"`
+}
+
+type ListResponse struct {
+	Lobbies    []Summary `json:"lobbies"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+type candidate struct {
+	id        string
+	createdAt int64
+	free      int
+}
+
+// ListHandler serves the lobby browser.
+//
+// Query parameters: status (default "open", "any" for all), mode, region,
+// min_free, sort ("newest", "oldest", "free"), limit and cursor.
+func ListHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+
+	status := q.Get("status")
+	if status == "" {
+		status = "open"
+	}
+
+	// Unlisted and private lobbies never show up in the browser
+	filters := []string{publicKey}
+	if status != "any" {
+		filters = append(filters, "lobbies:status:"+status)
+	}
+	if mode := q.Get("mode"); mode != "" {
+		filters = append(filters, "lobbies:mode:"+mode)
+	}
+	if region := q.Get("region"); region != "" {
+		filters = append(filters, "lobbies:region:"+region)
+	}
+
+	minFree, _ := strconv.Atoi(q.Get("min_free"))
+
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	order := q.Get("sort")
+	var after *candidate
+	if cursor := q.Get("cursor"); cursor != "" {
+		c, err := decodeCursor(cursor, order)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		after = &c
+	}
+
+	candidates, err := findCandidates(ctx, filters)
+	if err != nil {
+		http.Error(w, "Failed to list lobbies", http.StatusInternalServerError)
+		return
+	}
+
+	// Pages continue after the last lobby of the previous one, so lobbies
+	// created or closed in between don't shift the rest
+	filtered := candidates[:0]
+	for _, c := range candidates {
+		if c.free >= minFree && (after == nil || less(*after, c, order)) {
+			filtered = append(filtered, c)
+		}
+	}
+	sort.Slice(filtered, func(i, j int) bool { return less(filtered[i], filtered[j], order) })
+
+	resp := ListResponse{Lobbies: []Summary{}}
+	if len(filtered) > 0 {
+		page := filtered
+		if len(page) > limit {
+			page = page[:limit]
+			resp.NextCursor = encodeCursor(page[limit-1], order)
+		}
+
+		resp.Lobbies, err = loadSummaries(ctx, page)
+		if err != nil {
+			http.Error(w, "Failed to load lobbies", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetHandler returns a single lobby. Private lobbies are only visible to players
+// who could join them without an invite code.
+func GetHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+	id := chi.URLParam(r, "gameID")
+
+	summaries, err := loadSummaries(r.Context(), []candidate{{id: id}})
+	if err != nil {
+		http.Error(w, "Failed to load lobby", http.StatusInternalServerError)
+		return
+	}
+	if len(summaries) == 0 || (summaries[0].Visibility == "private" && !isInvited(r.Context(), id, claims.UserID)) {
+		http.Error(w, "Lobby not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaries[0])
+}
+
+func findCandidates(ctx context.Context, filters []string) ([]candidate, error) {
+	ids, err := gamestate.RDB.SInter(ctx, filters...).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	created, err := gamestate.RDB.ZMScore(ctx, indexKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	free, err := gamestate.RDB.ZMScore(ctx, freeKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]candidate, len(ids))
+	for i, id := range ids {
+		candidates[i] = candidate{id: id, createdAt: int64(created[i]), free: int(free[i])}
+	}
+	return candidates, nil
+}
+
+// less orders lobbies for the browser. Ties are broken by ID so every lobby
+// has a fixed position a cursor can point at.
+func less(a, b candidate, order string) bool {
+	switch order {
+	case "oldest":
+		if a.createdAt != b.createdAt {
+			return a.createdAt < b.createdAt
+		}
+	case "free":
+		if a.free != b.free {
+			return a.free > b.free
+		}
+	default: // newest
+		if a.createdAt != b.createdAt {
+			return a.createdAt > b.createdAt
+		}
+	}
+	return a.id < b.id
+}
+
+// A cursor is the sort key and ID of the last lobby on the previous page.
+func encodeCursor(c candidate, order string) string {
+	score := c.createdAt
+	if order == "free" {
+		score = int64(c.free)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(score, 10) + ":" + c.id))
+}
+
+func decodeCursor(cursor, order string) (candidate, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return candidate{}, err
+	}
+	scorePart, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return candidate{}, errors.New("malformed cursor")
+	}
+	score, err := strconv.ParseInt(scorePart, 10, 64)
+	if err != nil {
+		return candidate{}, err
+	}
+	c := candidate{id: id, createdAt: score}
+	if order == "free" {
+		c = candidate{id: id, free: int(score)}
+	}
+	return c, nil
+}
+
+// loadSummaries reads the lobby hashes. Index entries whose lobby no longer
+// exists are dropped from the index as they're found.
+func loadSummaries(ctx context.Context, page []candidate) ([]Summary, error) {
+	cmds, err := gamestate.RDB.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, c := range page {
+			pipe.HGetAll(ctx, "game:"+c.id)
+			pipe.SCard(ctx, "game:"+c.id+":players")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]Summary, 0, len(page))
+	for i, c := range page {
+		fields := cmds[i*2].(*redis.MapStringStringCmd).Val()
+		players := int(cmds[i*2+1].(*redis.IntCmd).Val())
+
+		if len(fields) == 0 {
+			removeFromIndex(ctx, c.id)
+			continue
+		}
+
+		capacity, _ := strconv.Atoi(fields["capacity"])
+		createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+		visibility := fields["visibility"]
+		if visibility == "" {
+			visibility = "public"
+		}
+		summaries = append(summaries, Summary{
+			GameID:           c.id,
+			Owner:            fields["owner"],
+			Status:           fields["status"],
+			Mode:             fields["mode"],
+			Region:           fields["region"],
+			Visibility:       visibility,
+			PasswordRequired: fields["password_hash"] != "",
+			Capacity:         capacity,
+			Players:          players,
+			FreeSlots:        capacity - players,
+			CreatedAt:        createdAt,
+		})
+	}
+	return summaries, nil
+}
+
+// JoinedBy returns the IDs of the lobbies the user is a player in, from the
+// user:<uid>:lobbies set kept by the scripts that add and remove players.
+func JoinedBy(ctx context.Context, userID string) ([]string, error) {
+	return gamestate.RDB.SMembers(ctx, "user:"+userID+":lobbies").Result()
+}
+
+// removeFromIndex drops a lobby whose hash is gone. close_lobby removes it
+// from every index set it could be in, not just the ones this query used.
+func removeFromIndex(ctx context.Context, id string) {
+	if _, err := gamestate.ExecuteScript(ctx, "close_lobby", []string{"game:" + id}, "system", "expired"); err != nil {
+		metrics.Log.Error("Failed to drop lobby from index", "game_id", id, "error", err)
+	}
+}
@@ -0,0 +1,118 @@
+package matchmaker
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"godra/internal/auth"
+	"godra/internal/party"
+	"godra/internal/ratings"
+)
+
+type EnqueueRequest struct {
+	Mode   string `json:"mode"`
+	Region string `json:"region"`
+}
+
+type StatusResponse struct {
+	*Ticket
+	WaitSeconds int64 `json:"wait_seconds"`
+}
+
+func EnqueueHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	var req EnqueueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Mode == "" || req.Region == "" {
+		http.Error(w, "Mode and region required", http.StatusBadRequest)
+		return
+	}
+	if !ValidQueue(req.Mode, req.Region) {
+		http.Error(w, "Unknown mode or region", http.StatusBadRequest)
+		return
+	}
+	// The ticket's size comes from the party, never from the client, so one
+	// player can't take up a whole match
+	ticket := &Ticket{
+		UserID:    claims.UserID,
+		Mode:      req.Mode,
+		Region:    req.Region,
+		PartySize: 1,
+		Members:   []string{claims.UserID},
+	}
+
+	// Parties queue together through their leader
+	p, err := party.ForUser(r.Context(), claims.UserID)
+	if err != nil && err != party.ErrNotInParty {
+		http.Error(w, "Failed to load party", http.StatusInternalServerError)
+		return
+	}
+	if p != nil {
+		if p.Leader != claims.UserID {
+			http.Error(w, "Forbidden: only the party leader can queue", http.StatusForbidden)
+			return
+		}
+		ticket.Members = p.Members
+		ticket.PartySize = len(p.Members)
+	}
+
+	// Skill is always the average rating of everyone on the ticket; letting
+	// clients send it would let them pick their own opponents in ranked modes
+	for _, member := range ticket.Members {
+		ticket.Skill += ratings.Get(r.Context(), member, req.Mode)
+	}
+	ticket.Skill /= float64(len(ticket.Members))
+
+	if err := Enqueue(r.Context(), ticket); err != nil {
+		if err == ErrAlreadyQueued {
+			http.Error(w, "Already in queue", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to enqueue: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ticket)
+}
+
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	ticket, err := Status(r.Context(), claims.UserID)
+	if err == ErrNotQueued {
+		http.Error(w, "Not in queue", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load ticket", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StatusResponse{
+		Ticket:      ticket,
+		WaitSeconds: time.Now().Unix() - ticket.EnqueuedAt,
+	})
+}
+
+func CancelHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	if err := Cancel(r.Context(), claims.UserID); err != nil {
+		if err == ErrNotQueued {
+			http.Error(w, "Not in queue", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to cancel ticket", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,432 @@
+package matchmaker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"godra/internal/database"
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis layout
+//
+//	mm:queues                    set of queue keys with waiting tickets
+//	mm:queue:<mode>:<region>     sorted set of ticket IDs scored by enqueue time
+//	mm:ticket:<id>               hash with the ticket fields
+//	mm:user:<user_id>            ticket ID the user is currently queued with
+const (
+	queuesKey = "mm:queues"
+	lockKey   = "mm:lock"
+)
+
+// How long a matched or expired ticket stays readable so clients polling the status endpoint see the result.
+const matchedTicketTTL = time.Minute
+
+var (
+	ErrAlreadyQueued = errors.New("already queued")
+	ErrNotQueued     = errors.New("not queued")
+)
+
+// Rules controls how tickets are grouped into matches.
+type Rules struct {
+	PlayersPerMatch   int
+	InitialSkillRange float64 // Allowed skill difference when a ticket is first queued
+	SkillRangeGrowth  float64 // Added to the allowed difference for every second waited
+	MaxSkillRange     float64
+	Modes             []string      // Modes clients may queue for
+	Regions           []string      // Regions clients may queue in
+	TicketTTL         time.Duration // Tickets still queued after this long expire
+}
+
+var rules = Rules{
+	PlayersPerMatch:   2,
+	InitialSkillRange: 100,
+	SkillRangeGrowth:  10,
+	MaxSkillRange:     1000,
+	TicketTTL:         5 * time.Minute,
+}
+
+// Ticket is a request by a player (or a party, through its leader) to be matched.
+type Ticket struct {
+	ID         string   `json:"ticket_id"`
+	UserID     string   `json:"user_id"`
+	Mode       string   `json:"mode"`
+	Region     string   `json:"region"`
+	Skill      float64  `json:"skill"`
+	PartySize  int      `json:"party_size"`
+	Members    []string `json:"members"` // Everyone matched with this ticket, the queuing user included
+	EnqueuedAt int64    `json:"enqueued_at"`
+	ExpiresAt  int64    `json:"expires_at"`
+	Status     string   `json:"status"` // "queued", "matched" or "expired"
+	GameID     string   `json:"game_id,omitempty"`
+}
+
+func queueKey(mode, region string) string { return "mm:queue:" + mode + ":" + region }
+func ticketKey(id string) string          { return "mm:ticket:" + id }
+func userKey(userID string) string        { return "mm:user:" + userID }
+
+// Start runs the matchmaking worker. Only one node processes the queues per tick.
+func Start(ctx context.Context, interval time.Duration, r Rules) {
+	rules = r
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ok, err := gamestate.RDB.SetNX(ctx, lockKey, "1", interval).Result()
+				if err != nil {
+					metrics.Log.Error("Matchmaker lock failed", "error", err)
+					continue
+				}
+				if ok {
+					processQueues(ctx)
+				}
+			}
+		}
+	}()
+}
+
+// ValidQueue reports whether clients may queue for the mode and region.
+func ValidQueue(mode, region string) bool {
+	return slices.Contains(rules.Modes, mode) && slices.Contains(rules.Regions, region)
+}
+
+// Enqueue adds a ticket for the user. A user can only hold one ticket at a time.
+func Enqueue(ctx context.Context, t *Ticket) error {
+	if t.PartySize < 1 || t.PartySize > rules.PlayersPerMatch {
+		return fmt.Errorf("party size must be between 1 and %d", rules.PlayersPerMatch)
+	}
+
+	if len(t.Members) == 0 {
+		t.Members = []string{t.UserID}
+	}
+
+	t.ID = database.GenerateRandomString(12)
+	t.EnqueuedAt = time.Now().Unix()
+	t.ExpiresAt = t.EnqueuedAt + int64(rules.TicketTTL.Seconds())
+	t.Status = "queued"
+
+	ok, err := gamestate.RDB.SetNX(ctx, userKey(t.UserID), t.ID, 0).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// A recently matched ticket may still be around for status polling
+		if existing, err := Status(ctx, t.UserID); err == nil && existing.Status == "queued" {
+			return ErrAlreadyQueued
+		}
+		if err := gamestate.RDB.Set(ctx, userKey(t.UserID), t.ID, 0).Err(); err != nil {
+			return err
+		}
+	}
+
+	queue := queueKey(t.Mode, t.Region)
+	_, err = gamestate.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, ticketKey(t.ID), ticketFields(t))
+		pipe.ZAdd(ctx, queue, redis.Z{Score: float64(t.EnqueuedAt), Member: t.ID})
+		pipe.SAdd(ctx, queuesKey, queue)
+		return nil
+	})
+	return err
+}
+
+// Status returns the user's current ticket, or ErrNotQueued.
+func Status(ctx context.Context, userID string) (*Ticket, error) {
+	id, err := gamestate.RDB.Get(ctx, userKey(userID)).Result()
+	if err == redis.Nil {
+		return nil, ErrNotQueued
+	}
+	if err != nil {
+		return nil, err
+	}
+	t, err := loadTicket(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrNotQueued
+	}
+	return t, nil
+}
+
+// Cancel removes the user's queued ticket. A ticket claimed by a match while
+// this runs can't be cancelled.
+func Cancel(ctx context.Context, userID string) error {
+	t, err := Status(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = gamestate.RDB.Watch(ctx, func(tx *redis.Tx) error {
+		status, err := tx.HGet(ctx, ticketKey(t.ID), "status").Result()
+		if err == redis.Nil || status != "queued" {
+			return ErrNotQueued
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZRem(ctx, queueKey(t.Mode, t.Region), t.ID)
+			pipe.Del(ctx, ticketKey(t.ID), userKey(userID))
+			return nil
+		})
+		return err
+	}, ticketKey(t.ID))
+	if err == redis.TxFailedErr {
+		// create_match claimed it between the check and the delete
+		return ErrNotQueued
+	}
+	return err
+}
+
+func ticketFields(t *Ticket) map[string]interface{} {
+	members, _ := json.Marshal(t.Members)
+	return map[string]interface{}{
+		"members":     string(members),
+		"user_id":     t.UserID,
+		"mode":        t.Mode,
+		"region":      t.Region,
+		"skill":       t.Skill,
+		"party_size":  t.PartySize,
+		"enqueued_at": t.EnqueuedAt,
+		"expires_at":  t.ExpiresAt,
+		"status":      t.Status,
+		"game_id":     t.GameID,
+	}
+}
+
+func loadTicket(ctx context.Context, id string) (*Ticket, error) {
+	fields, err := gamestate.RDB.HGetAll(ctx, ticketKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return parseTicket(id, fields), nil
+}
+
+func parseTicket(id string, fields map[string]string) *Ticket {
+	skill, _ := strconv.ParseFloat(fields["skill"], 64)
+	partySize, _ := strconv.Atoi(fields["party_size"])
+	enqueuedAt, _ := strconv.ParseInt(fields["enqueued_at"], 10, 64)
+	expiresAt, _ := strconv.ParseInt(fields["expires_at"], 10, 64)
+	var members []string
+	if err := json.Unmarshal([]byte(fields["members"]), &members); err != nil || len(members) == 0 {
+		members = []string{fields["user_id"]}
+	}
+	return &Ticket{
+		Members:    members,
+		ID:         id,
+		UserID:     fields["user_id"],
+		Mode:       fields["mode"],
+		Region:     fields["region"],
+		Skill:      skill,
+		PartySize:  partySize,
+		EnqueuedAt: enqueuedAt,
+		ExpiresAt:  expiresAt,
+		Status:     fields["status"],
+		GameID:     fields["game_id"],
+	}
+}
+
+func processQueues(ctx context.Context) {
+	queues, err := gamestate.RDB.SMembers(ctx, queuesKey).Result()
+	if err != nil {
+		metrics.Log.Error("Failed to list matchmaking queues", "error", err)
+		return
+	}
+	for _, queue := range queues {
+		processQueue(ctx, queue)
+	}
+}
+
+func processQueue(ctx context.Context, queue string) {
+	ids, err := gamestate.RDB.ZRange(ctx, queue, 0, -1).Result()
+	if err != nil {
+		metrics.Log.Error("Failed to read matchmaking queue", "queue", queue, "error", err)
+		return
+	}
+	if len(ids) == 0 {
+		gamestate.RDB.SRem(ctx, queuesKey, queue)
+		return
+	}
+
+	cmds, err := gamestate.RDB.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			pipe.HGetAll(ctx, ticketKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		metrics.Log.Error("Failed to load matchmaking tickets", "queue", queue, "error", err)
+		return
+	}
+
+	now := time.Now().Unix()
+	tickets := make([]*Ticket, 0, len(ids))
+	for i, cmd := range cmds {
+		fields := cmd.(*redis.MapStringStringCmd).Val()
+		if len(fields) == 0 {
+			// Ticket vanished (cancelled mid-tick), drop the dangling queue entry
+			gamestate.RDB.ZRem(ctx, queue, ids[i])
+			continue
+		}
+		t := parseTicket(ids[i], fields)
+		if t.ExpiresAt <= now {
+			expireTicket(ctx, queue, t)
+			continue
+		}
+		tickets = append(tickets, t)
+	}
+
+	for _, group := range groupTickets(tickets, now) {
+		if err := createMatch(ctx, queue, group); err != nil {
+			metrics.Log.Error("Failed to create match", "queue", queue, "error", err)
+		}
+	}
+}
+
+// expireTicket takes a ticket that waited too long out of its queue and tells
+// its members with a ticket_expired event. It stays readable as "expired" for
+// matchedTicketTTL. A ticket matched or cancelled in the meantime is left alone.
+func expireTicket(ctx context.Context, queue string, t *Ticket) {
+	expired := false
+	err := gamestate.RDB.Watch(ctx, func(tx *redis.Tx) error {
+		status, err := tx.HGet(ctx, ticketKey(t.ID), "status").Result()
+		if err == redis.Nil || status != "queued" {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZRem(ctx, queue, t.ID)
+			pipe.HSet(ctx, ticketKey(t.ID), "status", "expired")
+			pipe.Expire(ctx, ticketKey(t.ID), matchedTicketTTL)
+			pipe.Expire(ctx, userKey(t.UserID), matchedTicketTTL)
+			return nil
+		})
+		expired = err == nil
+		return err
+	}, ticketKey(t.ID))
+	if err != nil && err != redis.TxFailedErr {
+		metrics.Log.Error("Failed to expire ticket", "ticket_id", t.ID, "error", err)
+		return
+	}
+	if !expired {
+		return
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type": "ticket_expired",
+		"payload": map[string]interface{}{
+			"ticket_id": t.ID,
+			"mode":      t.Mode,
+			"region":    t.Region,
+		},
+	})
+	for _, member := range t.Members {
+		gamestate.PublishToUser(ctx, member, payload)
+	}
+}
+
+// skillRange returns how far apart skills may be for a ticket that has waited the given time.
+func skillRange(waited int64) float64 {
+	return math.Min(rules.InitialSkillRange+rules.SkillRangeGrowth*float64(waited), rules.MaxSkillRange)
+}
+
+// groupTickets greedily forms matches. Tickets are anchored oldest first so long waits
+// are served before fresh ones, and each anchor takes the closest-skilled tickets within
+// its (widening) range until the party sizes add up to a full match.
+func groupTickets(tickets []*Ticket, now int64) [][]*Ticket {
+	sort.Slice(tickets, func(i, j int) bool { return tickets[i].EnqueuedAt < tickets[j].EnqueuedAt })
+
+	used := make(map[string]bool)
+	var groups [][]*Ticket
+
+	for _, anchor := range tickets {
+		if used[anchor.ID] {
+			continue
+		}
+		allowed := skillRange(now - anchor.EnqueuedAt)
+
+		var candidates []*Ticket
+		for _, t := range tickets {
+			if t != anchor && !used[t.ID] && math.Abs(t.Skill-anchor.Skill) <= allowed {
+				candidates = append(candidates, t)
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			return math.Abs(candidates[i].Skill-anchor.Skill) < math.Abs(candidates[j].Skill-anchor.Skill)
+		})
+
+		group := []*Ticket{anchor}
+		size := anchor.PartySize
+		for _, t := range candidates {
+			if size == rules.PlayersPerMatch {
+				break
+			}
+			if size+t.PartySize <= rules.PlayersPerMatch {
+				group = append(group, t)
+				size += t.PartySize
+			}
+		}
+
+		if size == rules.PlayersPerMatch {
+			for _, t := range group {
+				used[t.ID] = true
+			}
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// createMatch claims the group's tickets and creates its lobby in one script
+// (create_match.lua), then notifies every player. If a ticket was cancelled
+// or matched in the meantime nothing is written and the rest of the group
+// waits for the next tick.
+func createMatch(ctx context.Context, queue string, group []*Ticket) error {
+	gameID := "mm-" + database.GenerateRandomString(10)
+	owner := group[0]
+
+	args := []interface{}{owner.UserID, rules.PlayersPerMatch, owner.Mode, owner.Region, int(matchedTicketTTL.Seconds())}
+	for _, t := range group {
+		args = append(args, t.ID)
+	}
+	if _, err := gamestate.ExecuteScript(ctx, "create_match", []string{"game:" + gameID, queue}, args...); err != nil {
+		return fmt.Errorf("create_match: %w", err)
+	}
+
+	metrics.Log.Info("Match found", "game_id", gameID, "queue", queue, "tickets", len(group))
+
+	for _, t := range group {
+		payload, _ := json.Marshal(map[string]interface{}{
+			"type": "match_found",
+			"payload": map[string]interface{}{
+				"game_id":   gameID,
+				"ticket_id": t.ID,
+				"mode":      t.Mode,
+				"region":    t.Region,
+			},
+		})
+		for _, member := range t.Members {
+			gamestate.PublishToUser(ctx, member, payload)
+		}
+	}
+	return nil
+}
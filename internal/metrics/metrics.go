@@ -10,6 +10,9 @@ var (
 	TotalRequests     atomic.Uint64
 	ActiveConnections atomic.Int64
 	ActiveLobbies     atomic.Int64
+	FailedLogins      atomic.Uint64
+	AccountLockouts   atomic.Uint64
+	RateLimited       atomic.Uint64
 )
 
 func Handler(w http.ResponseWriter, r *http.Request) {
@@ -17,6 +20,9 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		"total_requests":     TotalRequests.Load(),
 		"active_connections": ActiveConnections.Load(),
 		"active_lobbies":     ActiveLobbies.Load(),
+		"failed_logins":      FailedLogins.Load(),
+		"account_lockouts":   AccountLockouts.Load(),
+		"rate_limited":       RateLimited.Load(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,190 @@
+package moderation
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"godra/internal/auth"
+	"godra/internal/database"
+	"godra/internal/metrics"
+	"godra/internal/rbac"
+)
+
+// Mutes need chat.moderate; bans and suspensions need sanctions.manage.
+const managePermission = "sanctions.manage"
+
+type IssueRequest struct {
+	Type     string `json:"type"`     // "ban", "suspension" or "mute"
+	Duration int    `json:"duration"` // Seconds; 0 makes a ban or mute permanent
+	Reason   string `json:"reason"`
+}
+
+type LiftRequest struct {
+	Reason string `json:"reason"`
+}
+
+// SanctionResponse is a sanction as shown to moderators.
+type SanctionResponse struct {
+	ID         uint       `json:"id"`
+	UserID     string     `json:"user_id"`
+	Type       string     `json:"type"`
+	Reason     string     `json:"reason,omitempty"`
+	IssuedBy   string     `json:"issued_by"`
+	IssuedAt   time.Time  `json:"issued_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LiftedAt   *time.Time `json:"lifted_at,omitempty"`
+	LiftedBy   string     `json:"lifted_by,omitempty"`
+	LiftReason string     `json:"lift_reason,omitempty"`
+	Active     bool       `json:"active"`
+}
+
+// IssueHandler sanctions {userID}. Mounted behind chat.moderate; bans and
+// suspensions also need sanctions.manage. Moderators can only sanction users
+// below their own role.
+func IssueHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := auth.ClaimsFromContext(ctx)
+	userID := chi.URLParam(r, "userID")
+
+	var req IssueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Duration < 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !allowed(w, claims.Role, req.Type) {
+		return
+	}
+	if err := CheckOutranks(ctx, claims.Role, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	sanction, err := Issue(ctx, userID, req.Type, req.Reason, time.Duration(req.Duration)*time.Second, claims.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toResponse(sanction))
+}
+
+// LiftHandler ends {sanctionID} early. Needs the same permission as issuing
+// it. The body (a reason) is optional.
+func LiftHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := auth.ClaimsFromContext(ctx)
+
+	id, err := strconv.ParseUint(chi.URLParam(r, "sanctionID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid sanction ID", http.StatusBadRequest)
+		return
+	}
+	var req LiftRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var existing database.Sanction
+	if err := database.DB.WithContext(ctx).First(&existing, id).Error; err != nil {
+		writeError(w, ErrNotFound)
+		return
+	}
+	if !allowed(w, claims.Role, existing.Type) {
+		return
+	}
+
+	sanction, err := Lift(ctx, uint(id), claims.UserID, req.Reason)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toResponse(sanction))
+}
+
+// HistoryHandler lists every sanction {userID} has had, newest first. Needs
+// the sanctions.read permission.
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	sanctions, err := History(r.Context(), chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Failed to load sanctions", http.StatusInternalServerError)
+		return
+	}
+	writeList(w, sanctions)
+}
+
+// MineHandler lists the caller's active sanctions (mutes, in practice, since
+// banned users can't call it).
+func MineHandler(w http.ResponseWriter, r *http.Request) {
+	sanctions, err := Active(r.Context(), auth.ClaimsFromContext(r.Context()).UserID)
+	if err != nil {
+		http.Error(w, "Failed to load sanctions", http.StatusInternalServerError)
+		return
+	}
+	writeList(w, sanctions)
+}
+
+func writeList(w http.ResponseWriter, sanctions []database.Sanction) {
+	resp := make([]SanctionResponse, 0, len(sanctions))
+	for i := range sanctions {
+		resp = append(resp, toResponse(&sanctions[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// allowed checks the permission a sanction type needs.
+func allowed(w http.ResponseWriter, role, kind string) bool {
+	switch kind {
+	case Mute:
+		return true // Checked by the route
+	case Ban, Suspension:
+		if !rbac.HasPermission(role, managePermission) {
+			http.Error(w, "Forbidden: "+managePermission+" permission required", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+	writeError(w, ErrInvalidType)
+	return false
+}
+
+func toResponse(s *database.Sanction) SanctionResponse {
+	return SanctionResponse{
+		ID:         s.ID,
+		UserID:     s.UserID,
+		Type:       s.Type,
+		Reason:     s.Reason,
+		IssuedBy:   s.IssuedBy,
+		IssuedAt:   s.CreatedAt,
+		ExpiresAt:  s.ExpiresAt,
+		LiftedAt:   s.LiftedAt,
+		LiftedBy:   s.LiftedBy,
+		LiftReason: s.LiftReason,
+		Active:     active(s, time.Now()),
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidType), errors.Is(err, ErrDurationRequired):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrNotFound), errors.Is(err, auth.ErrUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrProtected):
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrNotActive):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		metrics.Log.Error("Moderation request failed", "error", err)
+		http.Error(w, "Moderation request failed", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,353 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"godra/internal/auth"
+	"godra/internal/database"
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+	"godra/internal/rbac"
+	"godra/internal/social"
+)
+
+// Sanctions
+//
+// Moderators ban, suspend and mute users. Every sanction is stored in the
+// database as the user's moderation history, and the active ones are mirrored
+// to Redis so they can be checked on every request:
+//
+//	sanction:access:<uid>  the ban or suspension that lasts longest, as JSON; expires with it
+//	chat:mute:<uid>        the mute that lasts longest; the value is the reason
+//
+// Bans and suspensions refuse logins, token refreshes and token validation,
+// and issuing one signs the user out and closes their sockets on every node.
+// Mutes stop the user from chatting.
+
+const (
+	Ban        = "ban"
+	Suspension = "suspension" // A ban with an end date
+	Mute       = "mute"
+)
+
+var (
+	ErrInvalidType      = errors.New("unknown sanction type")
+	ErrDurationRequired = errors.New("suspensions need a duration")
+	ErrNotFound         = errors.New("sanction not found")
+	ErrNotActive        = errors.New("sanction already lifted or expired")
+	ErrProtected        = errors.New("cannot sanction a user with an equal or higher role")
+)
+
+// SanctionError is returned for users under a ban or suspension. It matches
+// auth.ErrAccessDenied, so the message reaches the user.
+type SanctionError struct {
+	Type   string     `json:"type"`
+	Reason string     `json:"reason,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+func (e *SanctionError) Error() string {
+	msg := "account banned"
+	if e.Type == Suspension {
+		msg = "account suspended"
+	}
+	if e.Until != nil {
+		msg += " until " + e.Until.UTC().Format(time.RFC3339)
+	}
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+func (e *SanctionError) Is(target error) bool { return target == auth.ErrAccessDenied }
+
+func accessKey(userID string) string { return "sanction:access:" + userID }
+func muteKey(userID string) string   { return "chat:mute:" + userID }
+
+// CheckAccess returns a *SanctionError when the user is banned or suspended.
+// Set as auth.AccessCheck.
+func CheckAccess(ctx context.Context, userID string) error {
+	data, err := gamestate.RDB.Get(ctx, accessKey(userID)).Bytes()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var active SanctionError
+	if err := json.Unmarshal(data, &active); err != nil {
+		return err
+	}
+	return &active
+}
+
+// IsMuted reports whether the user is muted in chat.
+func IsMuted(ctx context.Context, userID string) (bool, error) {
+	n, err := gamestate.RDB.Exists(ctx, muteKey(userID)).Result()
+	return n > 0, err
+}
+
+// Issue sanctions a user. A zero duration makes a ban or mute permanent;
+// suspensions need one. issuer is the moderator's user ID, or "system".
+func Issue(ctx context.Context, userID, kind, reason string, duration time.Duration, issuer string) (*database.Sanction, error) {
+	switch kind {
+	case Ban, Mute:
+	case Suspension:
+		if duration <= 0 {
+			return nil, ErrDurationRequired
+		}
+	default:
+		return nil, ErrInvalidType
+	}
+
+	sanction := database.Sanction{UserID: userID, Type: kind, Reason: reason, IssuedBy: issuer}
+	if duration > 0 {
+		until := time.Now().Add(duration)
+		sanction.ExpiresAt = &until
+	}
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&sanction).Error; err != nil {
+			return err
+		}
+		return tx.Create(&database.AuditLog{
+			Actor:  issuer,
+			Action: "sanction.issue",
+			Target: userID,
+			After:  describe(&sanction),
+			Reason: reason,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	metrics.Log.Info("Sanction issued", "user_id", userID, "type", kind, "duration", duration, "issuer", issuer)
+
+	if err := Refresh(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	if kind == Mute {
+		notifyMuted(ctx, &sanction)
+		return &sanction, nil
+	}
+
+	// Existing tokens and sockets stop working on every node
+	if err := auth.RevokeAllSessions(ctx, userID, (&SanctionError{Type: kind, Reason: reason, Until: sanction.ExpiresAt}).Error()); err != nil {
+		metrics.Log.Error("Failed to revoke sessions of sanctioned user", "user_id", userID, "error", err)
+	}
+	return &sanction, nil
+}
+
+// Lift ends an active sanction early.
+func Lift(ctx context.Context, id uint, actor, reason string) (*database.Sanction, error) {
+	var sanction database.Sanction
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&sanction, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotFound
+			}
+			return err
+		}
+		if !active(&sanction, time.Now()) {
+			return ErrNotActive
+		}
+		return lift(tx, &sanction, actor, reason)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sanction, Refresh(ctx, sanction.UserID)
+}
+
+// LiftAll ends the user's active sanctions of one type and returns how many there were.
+func LiftAll(ctx context.Context, userID, kind, actor, reason string) (int, error) {
+	var lifted int
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var sanctions []database.Sanction
+		if err := activeQuery(tx, time.Now()).Where("user_id = ? AND type = ?", userID, kind).Find(&sanctions).Error; err != nil {
+			return err
+		}
+		for i := range sanctions {
+			if err := lift(tx, &sanctions[i], actor, reason); err != nil {
+				return err
+			}
+		}
+		lifted = len(sanctions)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return lifted, Refresh(ctx, userID)
+}
+
+func lift(tx *gorm.DB, sanction *database.Sanction, actor, reason string) error {
+	now := time.Now()
+	err := tx.Model(sanction).Updates(map[string]interface{}{
+		"lifted_at":   now,
+		"lifted_by":   actor,
+		"lift_reason": reason,
+	}).Error
+	if err != nil {
+		return err
+	}
+	metrics.Log.Info("Sanction lifted", "id", sanction.ID, "user_id", sanction.UserID, "type", sanction.Type, "actor", actor)
+	return tx.Create(&database.AuditLog{
+		Actor:  actor,
+		Action: "sanction.lift",
+		Target: sanction.UserID,
+		Before: describe(sanction),
+		Reason: reason,
+	}).Error
+}
+
+// History returns every sanction the user has had, newest first.
+func History(ctx context.Context, userID string) ([]database.Sanction, error) {
+	var sanctions []database.Sanction
+	err := database.DB.WithContext(ctx).Where("user_id = ?", userID).Order("id DESC").Find(&sanctions).Error
+	return sanctions, err
+}
+
+// Active returns the user's sanctions in force.
+func Active(ctx context.Context, userID string) ([]database.Sanction, error) {
+	var sanctions []database.Sanction
+	err := activeQuery(database.DB.WithContext(ctx), time.Now()).
+		Where("user_id = ?", userID).Order("id DESC").Find(&sanctions).Error
+	return sanctions, err
+}
+
+// CheckOutranks refuses sanctions against users whose role is equal to or
+// above the moderator's. Guests can always be sanctioned.
+func CheckOutranks(ctx context.Context, moderatorRole, userID string) error {
+	role := "guest"
+	if id, err := social.ParseUserID(userID); err == nil {
+		var user database.User
+		err := database.DB.WithContext(ctx).Select("role").First(&user, id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return auth.ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		role = user.Role
+	}
+	if role == moderatorRole || !rbac.HasRole(moderatorRole, role) {
+		return ErrProtected
+	}
+	return nil
+}
+
+// Sync rebuilds the Redis copy of active sanctions from the database. Run at
+// startup so sanctions survive a Redis flush.
+func Sync(ctx context.Context) error {
+	var userIDs []string
+	err := activeQuery(database.DB.WithContext(ctx).Model(&database.Sanction{}), time.Now()).
+		Distinct("user_id").Pluck("user_id", &userIDs).Error
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		if err := Refresh(ctx, userID); err != nil {
+			return err
+		}
+	}
+	metrics.Log.Info("Sanctions synced", "users", len(userIDs))
+	return nil
+}
+
+// Refresh writes the user's longest lasting ban or suspension, and mute, to
+// Redis, or clears them when none is active.
+func Refresh(ctx context.Context, userID string) error {
+	now := time.Now()
+	var sanctions []database.Sanction
+	if err := activeQuery(database.DB.WithContext(ctx), now).Where("user_id = ?", userID).Find(&sanctions).Error; err != nil {
+		return err
+	}
+
+	var access, mute *database.Sanction
+	for i := range sanctions {
+		s := &sanctions[i]
+		if s.Type == Mute {
+			mute = longest(mute, s)
+		} else {
+			access = longest(access, s)
+		}
+	}
+
+	_, err := gamestate.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if access == nil {
+			pipe.Del(ctx, accessKey(userID))
+		} else {
+			data, _ := json.Marshal(SanctionError{Type: access.Type, Reason: access.Reason, Until: access.ExpiresAt})
+			pipe.Set(ctx, accessKey(userID), data, ttl(access, now))
+		}
+		if mute == nil {
+			pipe.Del(ctx, muteKey(userID))
+		} else {
+			pipe.Set(ctx, muteKey(userID), mute.Reason, ttl(mute, now))
+		}
+		return nil
+	})
+	return err
+}
+
+func activeQuery(db *gorm.DB, now time.Time) *gorm.DB {
+	return db.Where("lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", now)
+}
+
+func active(s *database.Sanction, now time.Time) bool {
+	return s.LiftedAt == nil && (s.ExpiresAt == nil || s.ExpiresAt.After(now))
+}
+
+func longest(current, s *database.Sanction) *database.Sanction {
+	switch {
+	case current == nil, s.ExpiresAt == nil:
+		return s
+	case current.ExpiresAt == nil:
+		return current
+	case s.ExpiresAt.After(*current.ExpiresAt):
+		return s
+	}
+	return current
+}
+
+// ttl is how long the Redis copy lives; zero keeps permanent sanctions forever.
+func ttl(s *database.Sanction, now time.Time) time.Duration {
+	if s.ExpiresAt == nil {
+		return 0
+	}
+	return s.ExpiresAt.Sub(now)
+}
+
+func describe(s *database.Sanction) string {
+	if s.ExpiresAt == nil {
+		return s.Type
+	}
+	return fmt.Sprintf("%s until %s", s.Type, s.ExpiresAt.UTC().Format(time.RFC3339))
+}
+
+func notifyMuted(ctx context.Context, s *database.Sanction) {
+	var until int64 // Zero for permanent mutes
+	if s.ExpiresAt != nil {
+		until = s.ExpiresAt.Unix()
+	}
+	event, _ := json.Marshal(map[string]interface{}{
+		"type": "chat_muted",
+		"payload": map[string]interface{}{
+			"until":  until,
+			"reason": s.Reason,
+		},
+	})
+	if err := gamestate.PublishToUser(ctx, s.UserID, event); err != nil {
+		metrics.Log.Error("Failed to notify muted user", "user_id", s.UserID, "error", err)
+	}
+}
@@ -0,0 +1,212 @@
+package party
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"godra/internal/auth"
+	"godra/internal/chat"
+	"godra/internal/lobby"
+)
+
+type TargetRequest struct {
+	UserID string `json:"user_id"`
+}
+
+type InviteResponseRequest struct {
+	PartyID string `json:"party_id"`
+}
+
+type ChatRequest struct {
+	Message string `json:"message"`
+}
+
+type JoinLobbyRequest struct {
+	GameID     string `json:"game_id"`
+	InviteCode string `json:"invite_code"`
+	Password   string `json:"password"`
+}
+
+func GetHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	p, err := ForUser(r.Context(), claims.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeParty(w, http.StatusOK, p)
+}
+
+func CreateHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	p, err := Create(r.Context(), claims.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeParty(w, http.StatusCreated, p)
+}
+
+func InviteHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	var req TargetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	p, err := Invite(r.Context(), claims.UserID, req.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeParty(w, http.StatusOK, p)
+}
+
+func AcceptHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	var req InviteResponseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PartyID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	p, err := Accept(r.Context(), claims.UserID, req.PartyID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeParty(w, http.StatusOK, p)
+}
+
+func DeclineHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	var req InviteResponseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PartyID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := Decline(r.Context(), claims.UserID, req.PartyID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func LeaveHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	if err := Leave(r.Context(), claims.UserID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func KickHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	var req TargetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	p, err := Kick(r.Context(), claims.UserID, req.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeParty(w, http.StatusOK, p)
+}
+
+func TransferHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	var req TargetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	p, err := Transfer(r.Context(), claims.UserID, req.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeParty(w, http.StatusOK, p)
+}
+
+func ChatHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	var req ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Message == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := Chat(r.Context(), claims.UserID, claims.Username, req.Message)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(msg)
+}
+
+func JoinLobbyHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	var req JoinLobbyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.GameID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	p, err := JoinLobby(r.Context(), claims.UserID, req.GameID, req.InviteCode, req.Password)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeParty(w, http.StatusOK, p)
+}
+
+func writeParty(w http.ResponseWriter, status int, p *Party) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(p)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotInParty), errors.Is(err, ErrPartyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrNotLeader):
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrInParty), errors.Is(err, ErrPartyFull):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, chat.ErrMuted), errors.Is(err, chat.ErrBlocked):
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+	case errors.Is(err, chat.ErrEmpty), errors.Is(err, chat.ErrTooLong):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrBlocked):
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrNotInvited), errors.Is(err, ErrNotMember):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, lobby.ErrLobbyNotFound), errors.Is(err, lobby.ErrAccessDenied),
+		errors.Is(err, lobby.ErrPasswordRequired), errors.Is(err, lobby.ErrWrongPassword):
+		lobby.WriteAccessError(w, err)
+	default:
+		http.Error(w, "Party request failed: "+err.Error(), http.StatusConflict)
+	}
+}
@@ -0,0 +1,351 @@
+package party
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"godra/internal/chat"
+	"godra/internal/database"
+	"godra/internal/gamestate"
+	"godra/internal/lobby"
+	"godra/internal/metrics"
+	"godra/internal/social"
+)
+
+// Redis layout
+//
+//	party:<id>           hash: leader, created_at
+//	party:<id>:members   set of user IDs
+//	party:<id>:invites   set of user IDs with a pending invite
+//	user:<uid>:party     party ID the user belongs to
+//
+// Parties live independently of sockets, so a member who reconnects is still in their party.
+
+var (
+	ErrInParty       = errors.New("already in a party")
+	ErrNotInParty    = errors.New("not in a party")
+	ErrNotLeader     = errors.New("only the party leader can do that")
+	ErrNotInvited    = errors.New("no pending invite for this party")
+	ErrPartyFull     = errors.New("party is full")
+	ErrNotMember     = errors.New("user is not in the party")
+	ErrPartyNotFound = errors.New("party not found")
+	ErrBlocked       = errors.New("user is blocked")
+)
+
+// MaxSize is the largest a party can grow.
+var MaxSize = 4
+
+type Party struct {
+	ID        string   `json:"party_id"`
+	Leader    string   `json:"leader"`
+	Members   []string `json:"members"`
+	Invites   []string `json:"invites"`
+	CreatedAt int64    `json:"created_at"`
+}
+
+func partyKey(id string) string      { return "party:" + id }
+func membersKey(id string) string    { return "party:" + id + ":members" }
+func invitesKey(id string) string    { return "party:" + id + ":invites" }
+func userPartyKey(uid string) string { return "user:" + uid + ":party" }
+
+// IDForUser returns the party the user is in, or "" if none.
+func IDForUser(ctx context.Context, userID string) (string, error) {
+	id, err := gamestate.RDB.Get(ctx, userPartyKey(userID)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return id, err
+}
+
+// Load reads a party.
+func Load(ctx context.Context, id string) (*Party, error) {
+	cmds, err := gamestate.RDB.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HGetAll(ctx, partyKey(id))
+		pipe.SMembers(ctx, membersKey(id))
+		pipe.SMembers(ctx, invitesKey(id))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fields := cmds[0].(*redis.MapStringStringCmd).Val()
+	if len(fields) == 0 {
+		return nil, ErrPartyNotFound
+	}
+	createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+
+	p := &Party{
+		ID:        id,
+		Leader:    fields["leader"],
+		Members:   cmds[1].(*redis.StringSliceCmd).Val(),
+		Invites:   cmds[2].(*redis.StringSliceCmd).Val(),
+		CreatedAt: createdAt,
+	}
+	sort.Strings(p.Members)
+	sort.Strings(p.Invites)
+	return p, nil
+}
+
+// ForUser loads the user's party.
+func ForUser(ctx context.Context, userID string) (*Party, error) {
+	id, err := IDForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, ErrNotInParty
+	}
+	return Load(ctx, id)
+}
+
+// Create starts a new party led by the user.
+func Create(ctx context.Context, userID string) (*Party, error) {
+	id := database.GenerateRandomString(10)
+
+	ok, err := gamestate.RDB.SetNX(ctx, userPartyKey(userID), id, 0).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInParty
+	}
+
+	_, err = gamestate.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, partyKey(id), "leader", userID, "created_at", time.Now().Unix())
+		pipe.SAdd(ctx, membersKey(id), userID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return Load(ctx, id)
+}
+
+// Invite lets the leader invite another user.
+func Invite(ctx context.Context, leaderID, targetID string) (*Party, error) {
+	p, err := leaderParty(ctx, leaderID)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Members) >= MaxSize {
+		return nil, ErrPartyFull
+	}
+	if social.Blocks(ctx, leaderID, targetID) {
+		return nil, ErrBlocked
+	}
+
+	if err := gamestate.RDB.SAdd(ctx, invitesKey(p.ID), targetID).Err(); err != nil {
+		return nil, err
+	}
+
+	notify(ctx, []string{targetID}, "party_invite", map[string]interface{}{
+		"party_id": p.ID,
+		"from":     leaderID,
+	})
+	return broadcastUpdate(ctx, p.ID)
+}
+
+// Accept joins a party the user was invited to.
+func Accept(ctx context.Context, userID, partyID string) (*Party, error) {
+	err := gamestate.RDB.Watch(ctx, func(tx *redis.Tx) error {
+		if current, _ := tx.Get(ctx, userPartyKey(userID)).Result(); current != "" {
+			return ErrInParty
+		}
+		invited, err := tx.SIsMember(ctx, invitesKey(partyID), userID).Result()
+		if err != nil {
+			return err
+		}
+		if !invited {
+			return ErrNotInvited
+		}
+		size, err := tx.SCard(ctx, membersKey(partyID)).Result()
+		if err != nil {
+			return err
+		}
+		if int(size) >= MaxSize {
+			return ErrPartyFull
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SRem(ctx, invitesKey(partyID), userID)
+			pipe.SAdd(ctx, membersKey(partyID), userID)
+			pipe.Set(ctx, userPartyKey(userID), partyID, 0)
+			return nil
+		})
+		return err
+	}, userPartyKey(userID), membersKey(partyID), invitesKey(partyID))
+	if err != nil {
+		return nil, err
+	}
+	return broadcastUpdate(ctx, partyID)
+}
+
+// Decline drops a pending invite.
+func Decline(ctx context.Context, userID, partyID string) error {
+	removed, err := gamestate.RDB.SRem(ctx, invitesKey(partyID), userID).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrNotInvited
+	}
+	_, err = broadcastUpdate(ctx, partyID)
+	return err
+}
+
+// Leave removes the user from their party. The leader role passes to another
+// member, and the party is deleted when the last member leaves.
+func Leave(ctx context.Context, userID string) error {
+	p, err := ForUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return removeMember(ctx, p, userID)
+}
+
+// Kick lets the leader remove a member.
+func Kick(ctx context.Context, leaderID, targetID string) (*Party, error) {
+	p, err := leaderParty(ctx, leaderID)
+	if err != nil {
+		return nil, err
+	}
+	if targetID == leaderID || !contains(p.Members, targetID) {
+		return nil, ErrNotMember
+	}
+	if err := removeMember(ctx, p, targetID); err != nil {
+		return nil, err
+	}
+	notify(ctx, []string{targetID}, "party_kicked", map[string]interface{}{"party_id": p.ID})
+	return Load(ctx, p.ID)
+}
+
+// Transfer hands the leader role to another member.
+func Transfer(ctx context.Context, leaderID, newLeaderID string) (*Party, error) {
+	p, err := leaderParty(ctx, leaderID)
+	if err != nil {
+		return nil, err
+	}
+	if !contains(p.Members, newLeaderID) {
+		return nil, ErrNotMember
+	}
+	if err := gamestate.RDB.HSet(ctx, partyKey(p.ID), "leader", newLeaderID).Err(); err != nil {
+		return nil, err
+	}
+	return broadcastUpdate(ctx, p.ID)
+}
+
+func removeMember(ctx context.Context, p *Party, userID string) error {
+	remaining := make([]string, 0, len(p.Members))
+	for _, m := range p.Members {
+		if m != userID {
+			remaining = append(remaining, m)
+		}
+	}
+
+	_, err := gamestate.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SRem(ctx, membersKey(p.ID), userID)
+		pipe.Del(ctx, userPartyKey(userID))
+		if len(remaining) == 0 {
+			pipe.Del(ctx, partyKey(p.ID), membersKey(p.ID), invitesKey(p.ID))
+		} else if p.Leader == userID {
+			pipe.HSet(ctx, partyKey(p.ID), "leader", remaining[0])
+		}
+		return nil
+	})
+	if err != nil || len(remaining) == 0 {
+		return err
+	}
+	_, err = broadcastUpdate(ctx, p.ID)
+	return err
+}
+
+func leaderParty(ctx context.Context, userID string) (*Party, error) {
+	p, err := ForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if p.Leader != userID {
+		return nil, ErrNotLeader
+	}
+	return p, nil
+}
+
+// broadcastUpdate sends the current party state to every member.
+func broadcastUpdate(ctx context.Context, partyID string) (*Party, error) {
+	p, err := Load(ctx, partyID)
+	if err != nil {
+		return nil, err
+	}
+	notify(ctx, p.Members, "party_update", p)
+	return p, nil
+}
+
+// notify delivers an event to each user's sockets.
+func notify(ctx context.Context, userIDs []string, eventType string, payload interface{}) {
+	msg, err := json.Marshal(map[string]interface{}{
+		"type":    eventType,
+		"payload": payload,
+	})
+	if err != nil {
+		return
+	}
+	for _, id := range userIDs {
+		if err := gamestate.PublishToUser(ctx, id, msg); err != nil {
+			metrics.Log.Error("Failed to send party event", "user_id", id, "type", eventType, "error", err)
+		}
+	}
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Chat posts a message to the party's chat channel ("party:<id>").
+func Chat(ctx context.Context, userID, username, message string) (*chat.Message, error) {
+	p, err := ForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return chat.Send(ctx, chat.Channel{Kind: "party", ID: p.ID}, userID, username, message)
+}
+
+// JoinLobby moves the whole party into a lobby. Only the leader can do this and the
+// lobby must have room for every member. Members get a party_follow event with the
+// game_id so their clients can connect.
+func JoinLobby(ctx context.Context, leaderID, gameID, inviteCode, password string) (*Party, error) {
+	p, err := leaderParty(ctx, leaderID)
+	if err != nil {
+		return nil, err
+	}
+	if err := lobby.Authorize(ctx, gameID, leaderID, inviteCode, password); err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{leaderID}
+	for _, m := range p.Members {
+		if m != leaderID {
+			args = append(args, m)
+		}
+	}
+	if _, err := gamestate.ExecuteScript(ctx, "party_join_lobby", []string{"game:" + gameID}, args...); err != nil {
+		return nil, err
+	}
+
+	notify(ctx, p.Members, "party_follow", map[string]interface{}{
+		"party_id": p.ID,
+		"game_id":  gameID,
+	})
+	return p, nil
+}
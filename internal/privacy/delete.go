@@ -0,0 +1,190 @@
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"godra/internal/auth"
+	"godra/internal/chat"
+	"godra/internal/database"
+	"godra/internal/gamestate"
+	"godra/internal/matchmaker"
+	"godra/internal/metrics"
+	"godra/internal/moderation"
+	"godra/internal/party"
+)
+
+// Receipt confirms an account deletion. It is stored as a
+// database.DeletionReceipt and returned to whoever asked for it.
+type Receipt struct {
+	ID          uint             `json:"receipt_id"`
+	UserID      uint             `json:"user_id"`
+	RequestedBy string           `json:"requested_by"`
+	Reason      string           `json:"reason,omitempty"`
+	Removed     map[string]int64 `json:"removed"`
+	DeletedAt   time.Time        `json:"deleted_at"`
+}
+
+// CheckConfirmation verifies a user's own deletion request: their password,
+// or their username when the account has no password (identity provider
+// logins).
+func CheckConfirmation(ctx context.Context, userID uint, password, username string) error {
+	var user database.User
+	if err := database.DB.WithContext(ctx).First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	if user.Password != "" {
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+			return ErrWrongPassword
+		}
+		return nil
+	}
+	if username != user.Username {
+		return ErrConfirmation
+	}
+	return nil
+}
+
+// Delete erases a user's personal data (see the table in export.go) and
+// records a receipt. actor is the user's own ID, an admin's, or "cli". The
+// last admin can't be deleted.
+func Delete(ctx context.Context, userID uint, actor, reason string) (*Receipt, error) {
+	uid := fmt.Sprintf("%d", userID)
+
+	var user database.User
+	if err := database.DB.WithContext(ctx).First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if user.Role == "admin" {
+		var admins int64
+		if err := database.DB.WithContext(ctx).Model(&database.User{}).Where("role = ?", "admin").Count(&admins).Error; err != nil {
+			return nil, err
+		}
+		if admins <= 1 {
+			return nil, auth.ErrLastAdmin
+		}
+	}
+
+	// Sign out first so nothing new is written while the data goes
+	if err := auth.RevokeAllSessions(ctx, uid, "Account deleted"); err != nil {
+		return nil, err
+	}
+	if err := party.Leave(ctx, uid); err != nil && !errors.Is(err, party.ErrNotInParty) {
+		return nil, err
+	}
+	if err := matchmaker.Cancel(ctx, uid); err != nil && !errors.Is(err, matchmaker.ErrNotQueued) {
+		return nil, err
+	}
+
+	removed := map[string]int64{}
+	receipt := database.DeletionReceipt{UserID: userID, RequestedBy: actor, Reason: reason}
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		deletes := []struct {
+			name  string
+			model interface{}
+			query string
+			args  []interface{}
+		}{
+			{"profiles", &database.Profile{}, "user_id = ?", []interface{}{userID}},
+			{"identities", &database.Identity{}, "user_id = ?", []interface{}{userID}},
+			{"storage_objects", &database.StorageObject{}, "user_id = ?", []interface{}{userID}},
+			{"refresh_tokens", &database.RefreshToken{}, "user_id IN ?", []interface{}{tokenUserIDs(&user)}},
+			{"account_tokens", &database.AccountToken{}, "user_id = ?", []interface{}{userID}},
+			{"player_ratings", &database.PlayerRating{}, "user_id = ?", []interface{}{userID}},
+			{"rating_histories", &database.RatingHistory{}, "user_id = ?", []interface{}{userID}},
+			{"friendships", &database.Friendship{}, "user_id = ? OR friend_id = ?", []interface{}{userID, userID}},
+			{"blocks", &database.Block{}, "user_id = ? OR blocked_id = ?", []interface{}{userID, userID}},
+			{"chat_messages", &database.ChatMessage{}, "user_id = ? OR channel LIKE ? OR channel LIKE ?",
+				[]interface{}{uid, "direct:" + uid + ",%", "direct:%," + uid}},
+			{"sanctions", &database.Sanction{}, "user_id = ?", []interface{}{uid}},
+		}
+		for _, d := range deletes {
+			res := tx.Unscoped().Where(d.query, d.args...).Delete(d.model)
+			if res.Error != nil {
+				return res.Error
+			}
+			removed[d.name] = res.RowsAffected
+		}
+
+		// Lockout entries name the username that was tried
+		err := tx.Model(&database.AuditLog{}).
+			Where("action = ? AND target = ?", "login.lockout", uid).
+			Update("reason", "").Error
+		if err != nil {
+			return err
+		}
+
+		// The row stays, without personal data, so the ID is never handed out again
+		err = tx.Model(&user).Updates(map[string]interface{}{
+			"username":          fmt.Sprintf("deleted-%d", userID),
+			"password":          "",
+			"guest_id":          "",
+			"email":             nil,
+			"email_verified_at": nil,
+			"totp_secret":       "",
+			"totp_enabled_at":   nil,
+			"totp_last_step":    0,
+			"recovery_codes":    "",
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&user).Error; err != nil {
+			return err
+		}
+		removed["users"] = 1
+
+		data, _ := json.Marshal(removed)
+		receipt.Removed = string(data)
+		if err := tx.Create(&receipt).Error; err != nil {
+			return err
+		}
+		return tx.Create(&database.AuditLog{
+			Actor:  actor,
+			Action: "account.delete",
+			Target: uid,
+			After:  fmt.Sprintf("receipt %d", receipt.ID),
+			Reason: reason,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Redis copies of what was just deleted. The SQL rows are gone either
+	// way, so failures here are logged rather than returned.
+	purged, err := chat.PurgeUser(ctx, uid)
+	if err != nil {
+		metrics.Log.Error("Failed to purge chat history of deleted user", "user_id", userID, "error", err)
+	}
+	removed["chat_history"] = int64(purged)
+	if err := moderation.Refresh(ctx, uid); err != nil {
+		metrics.Log.Error("Failed to clear sanctions of deleted user", "user_id", userID, "error", err)
+	}
+	auth.ResetLoginFailures(ctx, user.Username)
+	if user.GuestID != "" {
+		gamestate.RDB.Del(ctx, "guest_alias:"+user.GuestID)
+	}
+
+	metrics.Log.Info("Account deleted", "user_id", userID, "actor", actor, "receipt", receipt.ID)
+	return &Receipt{
+		ID:          receipt.ID,
+		UserID:      userID,
+		RequestedBy: actor,
+		Reason:      reason,
+		Removed:     removed,
+		DeletedAt:   receipt.CreatedAt,
+	}, nil
+}
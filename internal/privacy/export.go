@@ -0,0 +1,387 @@
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"godra/internal/auth"
+	"godra/internal/chat"
+	"godra/internal/database"
+	"godra/internal/identity"
+	"godra/internal/matchmaker"
+	"godra/internal/party"
+)
+
+// Personal data
+//
+// Everything Godra stores about a registered user, and what happens to it
+// when the account is deleted:
+//
+//	users               account row          anonymized and soft-deleted, so the ID is never reused
+//	profiles            profile              deleted
+//	identities          linked providers     deleted
+//	storage_objects     cloud storage        deleted, public objects included
+//	refresh_tokens      sessions             revoked, then deleted
+//	account_tokens      mailed links         deleted
+//	player_ratings      ratings              deleted
+//	rating_histories    rating changes       deleted
+//	match_results       reported matches     kept; shared with the other players and only hold IDs
+//	friendships, blocks both directions      deleted
+//	chat_messages       chat archive         the user's messages and their direct channels deleted
+//	sanctions           moderation history   deleted
+//	audit_logs          privileged changes   kept; IDs only, lockout entries lose the username
+//
+//	chat:history:<ch>   recent chat          the user's messages and their direct channels removed
+//	chat:mute, sanction:access               cleared with the sanctions
+//	user:<uid>:party    party                left
+//	mm:user:<uid>       matchmaking ticket   cancelled
+//	login:fail|lock     login throttling     cleared
+//	revoked:user:<uid>  revocation cutoff    kept until it expires, so issued tokens stay dead
+//
+// Presence and lobby membership go away when the user's sockets close.
+
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrWrongPassword = errors.New("password is incorrect")
+	ErrConfirmation  = errors.New("type your username to confirm")
+)
+
+// Archive is a user's data export.
+type Archive struct {
+	GeneratedAt   time.Time          `json:"generated_at"`
+	Account       AccountData        `json:"account"`
+	Profile       *ProfileData       `json:"profile,omitempty"`
+	Identities    []IdentityData     `json:"identities"`
+	Storage       []StorageData      `json:"storage"`
+	Sessions      []SessionData      `json:"sessions"`
+	EmailTokens   []EmailTokenData   `json:"email_tokens"`
+	Ratings       []RatingData       `json:"ratings"`
+	RatingHistory []RatingChangeData `json:"rating_history"`
+	Matches       []MatchData        `json:"matches"`
+	Friendships   []FriendshipData   `json:"friendships"`
+	Blocks        []BlockData        `json:"blocks"`
+	ChatArchive   []ChatMessageData  `json:"chat_archive"`
+	RecentChat    []chat.Message     `json:"recent_chat"`
+	Sanctions     []SanctionData     `json:"sanctions"`
+	AuditLog      []AuditData        `json:"audit_log"`
+	Party         *party.Party       `json:"party,omitempty"`
+	Matchmaking   *matchmaker.Ticket `json:"matchmaking,omitempty"`
+}
+
+type AccountData struct {
+	UserID            uint       `json:"user_id"`
+	Username          string     `json:"username"`
+	Role              string     `json:"role"`
+	Email             string     `json:"email,omitempty"`
+	EmailVerifiedAt   *time.Time `json:"email_verified_at,omitempty"`
+	HasPassword       bool       `json:"has_password"`
+	TwoFactorSince    *time.Time `json:"two_factor_enabled_at,omitempty"`
+	RecoveryCodes     int        `json:"recovery_codes_left"`
+	UpgradedFromGuest string     `json:"upgraded_from_guest,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+type ProfileData struct {
+	DisplayName string          `json:"display_name,omitempty"`
+	AvatarURL   string          `json:"avatar_url,omitempty"`
+	Locale      string          `json:"locale,omitempty"`
+	Metadata    json.RawMessage `json:"metadata,omitempty"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+type StorageData struct {
+	Collection string          `json:"collection"`
+	Key        string          `json:"key"`
+	Value      json.RawMessage `json:"value"`
+	Version    int64           `json:"version"`
+	Permission string          `json:"permission"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+type IdentityData struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject,omitempty"` // Not included for devices, whose ID is only stored hashed
+	Email    string    `json:"email,omitempty"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+type SessionData struct {
+	SessionID string     `json:"session_id"`
+	IssuedAt  time.Time  `json:"issued_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type EmailTokenData struct {
+	Purpose   string     `json:"purpose"`
+	Email     string     `json:"email"`
+	SentAt    time.Time  `json:"sent_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+type RatingData struct {
+	Mode      string    `json:"mode"`
+	Season    string    `json:"season"`
+	Rating    float64   `json:"rating"`
+	Games     int       `json:"games"`
+	Wins      int       `json:"wins"`
+	Losses    int       `json:"losses"`
+	Draws     int       `json:"draws"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type RatingChangeData struct {
+	MatchID   uint      `json:"match_id"`
+	Mode      string    `json:"mode"`
+	Season    string    `json:"season"`
+	Before    float64   `json:"before"`
+	After     float64   `json:"after"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type MatchData struct {
+	MatchID    uint      `json:"match_id"`
+	GameID     string    `json:"game_id"`
+	Mode       string    `json:"mode"`
+	Season     string    `json:"season"`
+	Result     string    `json:"result"`
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+type FriendshipData struct {
+	UserID     uint       `json:"user_id"`
+	FriendID   uint       `json:"friend_id"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+}
+
+type BlockData struct {
+	UserID    uint      `json:"user_id"`
+	BlockedID uint      `json:"blocked_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ChatMessageData struct {
+	MessageID int64     `json:"message_id"`
+	Channel   string    `json:"channel"`
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	Message   string    `json:"message"`
+	DeletedBy string    `json:"deleted_by,omitempty"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+type SanctionData struct {
+	Type       string     `json:"type"`
+	Reason     string     `json:"reason,omitempty"`
+	IssuedAt   time.Time  `json:"issued_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LiftedAt   *time.Time `json:"lifted_at,omitempty"`
+	LiftReason string     `json:"lift_reason,omitempty"`
+}
+
+type AuditData struct {
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	Before    string    `json:"before,omitempty"`
+	After     string    `json:"after,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Export collects everything stored about the user.
+func Export(ctx context.Context, userID uint) (*Archive, error) {
+	db := database.DB.WithContext(ctx)
+	uid := fmt.Sprintf("%d", userID)
+
+	var user database.User
+	if err := db.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	a := &Archive{
+		GeneratedAt: time.Now().UTC(),
+		Account: AccountData{
+			UserID:            user.ID,
+			Username:          user.Username,
+			Role:              user.Role,
+			EmailVerifiedAt:   user.EmailVerifiedAt,
+			HasPassword:       user.Password != "",
+			TwoFactorSince:    user.TOTPEnabledAt,
+			RecoveryCodes:     auth.RecoveryCodesLeft(&user),
+			UpgradedFromGuest: user.GuestID,
+			CreatedAt:         user.CreatedAt,
+			UpdatedAt:         user.UpdatedAt,
+		},
+	}
+	if user.Email != nil {
+		a.Account.Email = *user.Email
+	}
+
+	var profile database.Profile
+	err := db.Where("user_id = ?", userID).First(&profile).Error
+	if err == nil {
+		a.Profile = &ProfileData{DisplayName: profile.DisplayName, AvatarURL: profile.AvatarURL, Locale: profile.Locale, UpdatedAt: profile.UpdatedAt}
+		if profile.Metadata != "" {
+			a.Profile.Metadata = json.RawMessage(profile.Metadata)
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var identities []database.Identity
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&identities).Error; err != nil {
+		return nil, err
+	}
+	a.Identities = make([]IdentityData, 0, len(identities))
+	for _, i := range identities {
+		data := IdentityData{Provider: i.Provider, Subject: i.Subject, Email: i.Email, LinkedAt: i.CreatedAt}
+		if i.Provider == identity.DeviceProvider {
+			data.Subject = ""
+		}
+		a.Identities = append(a.Identities, data)
+	}
+
+	var objects []database.StorageObject
+	if err := db.Where("user_id = ?", userID).Order("collection, key").Find(&objects).Error; err != nil {
+		return nil, err
+	}
+	a.Storage = make([]StorageData, 0, len(objects))
+	for _, o := range objects {
+		a.Storage = append(a.Storage, StorageData{Collection: o.Collection, Key: o.Key, Value: json.RawMessage(o.Value), Version: o.Version, Permission: o.Permission, UpdatedAt: o.UpdatedAt})
+	}
+
+	var tokens []database.RefreshToken
+	if err := db.Where("user_id IN ?", tokenUserIDs(&user)).Order("id").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	a.Sessions = make([]SessionData, 0, len(tokens))
+	for _, t := range tokens {
+		a.Sessions = append(a.Sessions, SessionData{SessionID: t.SessionID, IssuedAt: t.CreatedAt, ExpiresAt: t.ExpiresAt, RevokedAt: t.RevokedAt})
+	}
+
+	var mailed []database.AccountToken
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&mailed).Error; err != nil {
+		return nil, err
+	}
+	a.EmailTokens = make([]EmailTokenData, 0, len(mailed))
+	for _, t := range mailed {
+		a.EmailTokens = append(a.EmailTokens, EmailTokenData{Purpose: t.Purpose, Email: t.Email, SentAt: t.CreatedAt, ExpiresAt: t.ExpiresAt, UsedAt: t.UsedAt})
+	}
+
+	var ratings []database.PlayerRating
+	if err := db.Where("user_id = ?", userID).Order("season, mode").Find(&ratings).Error; err != nil {
+		return nil, err
+	}
+	a.Ratings = make([]RatingData, 0, len(ratings))
+	for _, r := range ratings {
+		a.Ratings = append(a.Ratings, RatingData{Mode: r.Mode, Season: r.Season, Rating: r.Rating, Games: r.Games, Wins: r.Wins, Losses: r.Losses, Draws: r.Draws, UpdatedAt: r.UpdatedAt})
+	}
+
+	var history []database.RatingHistory
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&history).Error; err != nil {
+		return nil, err
+	}
+	a.RatingHistory = make([]RatingChangeData, 0, len(history))
+	matchIDs := make([]uint, 0, len(history))
+	for _, h := range history {
+		a.RatingHistory = append(a.RatingHistory, RatingChangeData{MatchID: h.MatchResultID, Mode: h.Mode, Season: h.Season, Before: h.Before, After: h.After, CreatedAt: h.CreatedAt})
+		matchIDs = append(matchIDs, h.MatchResultID)
+	}
+
+	a.Matches = []MatchData{}
+	if len(matchIDs) > 0 {
+		var matches []database.MatchResult
+		if err := db.Where("id IN ?", matchIDs).Order("id").Find(&matches).Error; err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			a.Matches = append(a.Matches, MatchData{MatchID: m.ID, GameID: m.GameID, Mode: m.Mode, Season: m.Season, Result: m.Result, ReportedAt: m.CreatedAt})
+		}
+	}
+
+	var friendships []database.Friendship
+	if err := db.Where("user_id = ? OR friend_id = ?", userID, userID).Order("id").Find(&friendships).Error; err != nil {
+		return nil, err
+	}
+	a.Friendships = make([]FriendshipData, 0, len(friendships))
+	for _, f := range friendships {
+		a.Friendships = append(a.Friendships, FriendshipData{UserID: f.UserID, FriendID: f.FriendID, Status: f.Status, CreatedAt: f.CreatedAt, AcceptedAt: f.AcceptedAt})
+	}
+
+	var blocks []database.Block
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&blocks).Error; err != nil {
+		return nil, err
+	}
+	a.Blocks = make([]BlockData, 0, len(blocks))
+	for _, b := range blocks {
+		a.Blocks = append(a.Blocks, BlockData{UserID: b.UserID, BlockedID: b.BlockedID, CreatedAt: b.CreatedAt})
+	}
+
+	var messages []database.ChatMessage
+	if err := db.Where("user_id = ?", uid).Order("message_id").Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	a.ChatArchive = make([]ChatMessageData, 0, len(messages))
+	for _, m := range messages {
+		a.ChatArchive = append(a.ChatArchive, ChatMessageData{MessageID: m.MessageID, Channel: m.Channel, UserID: m.UserID, Username: m.Username, Message: m.Message, DeletedBy: m.DeletedBy, SentAt: m.CreatedAt})
+	}
+
+	recent, err := chat.UserMessages(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	a.RecentChat = append([]chat.Message{}, recent...)
+
+	var sanctions []database.Sanction
+	if err := db.Where("user_id = ?", uid).Order("id").Find(&sanctions).Error; err != nil {
+		return nil, err
+	}
+	a.Sanctions = make([]SanctionData, 0, len(sanctions))
+	for _, s := range sanctions {
+		a.Sanctions = append(a.Sanctions, SanctionData{Type: s.Type, Reason: s.Reason, IssuedAt: s.CreatedAt, ExpiresAt: s.ExpiresAt, LiftedAt: s.LiftedAt, LiftReason: s.LiftReason})
+	}
+
+	var audit []database.AuditLog
+	if err := db.Where("target = ? OR actor = ?", uid, uid).Order("id").Find(&audit).Error; err != nil {
+		return nil, err
+	}
+	a.AuditLog = make([]AuditData, 0, len(audit))
+	for _, e := range audit {
+		a.AuditLog = append(a.AuditLog, AuditData{Actor: e.Actor, Action: e.Action, Target: e.Target, Before: e.Before, After: e.After, Reason: e.Reason, CreatedAt: e.CreatedAt})
+	}
+
+	if p, err := party.ForUser(ctx, uid); err == nil {
+		a.Party = p
+	} else if !errors.Is(err, party.ErrNotInParty) {
+		return nil, err
+	}
+	if t, err := matchmaker.Status(ctx, uid); err == nil {
+		a.Matchmaking = t
+	} else if !errors.Is(err, matchmaker.ErrNotQueued) {
+		return nil, err
+	}
+	return a, nil
+}
+
+// tokenUserIDs are the token user IDs the account has used: its own, and the
+// guest ID it was upgraded from.
+func tokenUserIDs(user *database.User) []string {
+	ids := []string{fmt.Sprintf("%d", user.ID)}
+	if user.GuestID != "" {
+		ids = append(ids, user.GuestID)
+	}
+	return ids
+}
@@ -0,0 +1,123 @@
+package privacy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"godra/internal/auth"
+	"godra/internal/metrics"
+	"godra/internal/social"
+)
+
+type DeleteRequest struct {
+	Password string `json:"password"` // Needed when the account has a password
+	Username string `json:"username"` // Needed otherwise, to confirm
+	Reason   string `json:"reason"`
+}
+
+type AdminDeleteRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ExportHandler downloads everything stored about the caller as a JSON file.
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	archive, err := Export(r.Context(), userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	metrics.Log.Info("Personal data exported", "user_id", userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="godra-export-`+strconv.FormatUint(uint64(userID), 10)+`.json"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(archive)
+}
+
+// DeleteHandler deletes the caller's account after checking their password
+// (or username, for accounts without one). The caller is signed out.
+func DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	var req DeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := CheckConfirmation(ctx, userID, req.Password, req.Username); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	receipt, err := Delete(ctx, userID, auth.ClaimsFromContext(ctx).UserID, req.Reason)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(receipt)
+}
+
+// AdminDeleteHandler deletes {userID}. Needs the users.delete permission.
+// The body (a reason) is optional.
+func AdminDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := social.ParseUserID(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req AdminDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	receipt, err := Delete(ctx, id, auth.ClaimsFromContext(ctx).UserID, req.Reason)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(receipt)
+}
+
+func callerID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	claims := auth.ClaimsFromContext(r.Context())
+	id, err := social.ParseUserID(claims.UserID)
+	if err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return 0, false
+	}
+	return id, true
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrWrongPassword), errors.Is(err, ErrConfirmation):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, auth.ErrLastAdmin):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		metrics.Log.Error("Privacy request failed", "error", err)
+		http.Error(w, "Privacy request failed", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,92 @@
+package profiles
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"godra/internal/auth"
+	"godra/internal/metrics"
+	"godra/internal/social"
+)
+
+// GetHandler returns the caller's profile.
+func GetHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+	writeProfile(w, r, userID)
+}
+
+// GetUserHandler returns {userID}'s profile.
+func GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := social.ParseUserID(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	writeProfile(w, r, userID)
+}
+
+// UpdateHandler changes the fields present in the body of the caller's
+// profile and returns the result.
+func UpdateHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	var req Update
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	profile, err := Set(r.Context(), userID, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+func writeProfile(w http.ResponseWriter, r *http.Request, userID uint) {
+	profile, err := Get(r.Context(), userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+func callerID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	claims := auth.ClaimsFromContext(r.Context())
+	id, err := social.ParseUserID(claims.UserID)
+	if err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return 0, false
+	}
+	return id, true
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidDisplayName):
+		http.Error(w, "Display name must be at most "+strconv.Itoa(MaxDisplayNameLength)+" characters, without control characters", http.StatusBadRequest)
+	case errors.Is(err, ErrMetadataTooLarge):
+		http.Error(w, "Metadata must be at most "+strconv.Itoa(MaxMetadataSize)+" bytes", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrInvalidAvatarURL), errors.Is(err, ErrInvalidLocale), errors.Is(err, ErrInvalidMetadata):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		metrics.Log.Error("Profile request failed", "error", err)
+		http.Error(w, "Profile request failed", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,223 @@
+package profiles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+
+	"godra/internal/database"
+	"godra/internal/gamestate"
+	"godra/internal/lobby"
+	"godra/internal/metrics"
+	"godra/internal/party"
+)
+
+// Player profiles
+//
+// Registered users have a display name, an avatar, a locale and a JSON object
+// of game metadata (titles, cosmetics, ...). Profiles are public. Changes are
+// published as "profile_updated" to the lobbies and party the player is in,
+// and to the player's own sockets, so clients can refresh nameplates.
+
+var (
+	MaxDisplayNameLength = 32
+	MaxMetadataSize      = 4096 // Bytes of compacted JSON
+)
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidDisplayName = errors.New("invalid display name")
+	ErrInvalidAvatarURL   = errors.New("avatar URL must be an http or https URL")
+	ErrInvalidLocale      = errors.New("invalid locale")
+	ErrInvalidMetadata    = errors.New("metadata must be a JSON object")
+	ErrMetadataTooLarge   = errors.New("metadata is too large")
+)
+
+const maxAvatarURLLength = 512
+
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// Profile is a user's profile as clients see it.
+type Profile struct {
+	UserID      uint            `json:"user_id"`
+	Username    string          `json:"username"`
+	DisplayName string          `json:"display_name"` // The username until one is set
+	AvatarURL   string          `json:"avatar_url,omitempty"`
+	Locale      string          `json:"locale,omitempty"`
+	Metadata    json.RawMessage `json:"metadata"`
+	UpdatedAt   *time.Time      `json:"updated_at,omitempty"`
+}
+
+// Update changes some fields of a profile. Nil fields are left alone and
+// empty strings clear a field. Metadata replaces the whole object; JSON null
+// clears it.
+type Update struct {
+	DisplayName *string         `json:"display_name"`
+	AvatarURL   *string         `json:"avatar_url"`
+	Locale      *string         `json:"locale"`
+	Metadata    json.RawMessage `json:"metadata"`
+}
+
+// Get loads a user's profile.
+func Get(ctx context.Context, userID uint) (*Profile, error) {
+	var user database.User
+	err := database.DB.WithContext(ctx).Select("id", "username").First(&user, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var row database.Profile
+	err = database.DB.WithContext(ctx).Where("user_id = ?", userID).First(&row).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return toProfile(&user, &row), nil
+}
+
+// Set validates and applies an update, then tells everyone who can see the player.
+func Set(ctx context.Context, userID uint, u Update) (*Profile, error) {
+	var user database.User
+	var row database.Profile
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("id", "username").First(&user, userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).FirstOrInit(&row, database.Profile{UserID: userID}).Error; err != nil {
+			return err
+		}
+		if err := apply(&row, u); err != nil {
+			return err
+		}
+		return tx.Save(&row).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	profile := toProfile(&user, &row)
+	metrics.Log.Info("Profile updated", "user_id", userID)
+	notify(ctx, profile)
+	return profile, nil
+}
+
+func apply(row *database.Profile, u Update) error {
+	if u.DisplayName != nil {
+		name := strings.TrimSpace(*u.DisplayName)
+		if utf8.RuneCountInString(name) > MaxDisplayNameLength || strings.IndexFunc(name, invisible) >= 0 {
+			return ErrInvalidDisplayName
+		}
+		row.DisplayName = name
+	}
+	if u.AvatarURL != nil {
+		avatar := strings.TrimSpace(*u.AvatarURL)
+		if avatar != "" {
+			parsed, err := url.Parse(avatar)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || len(avatar) > maxAvatarURLLength {
+				return ErrInvalidAvatarURL
+			}
+		}
+		row.AvatarURL = avatar
+	}
+	if u.Locale != nil {
+		locale := strings.TrimSpace(*u.Locale)
+		if locale != "" && !localePattern.MatchString(locale) {
+			return ErrInvalidLocale
+		}
+		row.Locale = locale
+	}
+	if u.Metadata != nil {
+		if string(u.Metadata) == "null" {
+			row.Metadata = ""
+			return nil
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, u.Metadata); err != nil || compact.Len() == 0 || compact.Bytes()[0] != '{' {
+			return ErrInvalidMetadata
+		}
+		if compact.Len() > MaxMetadataSize {
+			return ErrMetadataTooLarge
+		}
+		row.Metadata = compact.String()
+	}
+	return nil
+}
+
+// invisible reports control and format characters. Format (Cf) characters
+// include bidi overrides and zero-width spaces, which make a name render
+// differently from what it is.
+func invisible(r rune) bool {
+	return unicode.IsControl(r) || unicode.Is(unicode.Cf, r)
+}
+
+func toProfile(user *database.User, row *database.Profile) *Profile {
+	p := &Profile{
+		UserID:      user.ID,
+		Username:    user.Username,
+		DisplayName: row.DisplayName,
+		AvatarURL:   row.AvatarURL,
+		Locale:      row.Locale,
+		Metadata:    json.RawMessage("{}"),
+	}
+	if p.DisplayName == "" {
+		p.DisplayName = user.Username
+	}
+	if row.Metadata != "" {
+		p.Metadata = json.RawMessage(row.Metadata)
+	}
+	if row.ID != 0 {
+		p.UpdatedAt = &row.UpdatedAt
+	}
+	return p
+}
+
+// notify publishes profile_updated to the player's lobbies, party and own sockets.
+func notify(ctx context.Context, p *Profile) {
+	userID := strconv.FormatUint(uint64(p.UserID), 10)
+	event, err := json.Marshal(map[string]interface{}{
+		"type":    "profile_updated",
+		"payload": p,
+	})
+	if err != nil {
+		return
+	}
+
+	lobbies, err := lobby.JoinedBy(ctx, userID)
+	if err != nil {
+		metrics.Log.Error("Failed to find lobbies for profile update", "user_id", userID, "error", err)
+	}
+	for _, gameID := range lobbies {
+		if err := gamestate.RDB.Publish(ctx, "game_updates:game:"+gameID, event).Err(); err != nil {
+			metrics.Log.Error("Failed to publish profile update", "game_id", gameID, "error", err)
+		}
+	}
+
+	recipients := []string{userID}
+	if pt, err := party.ForUser(ctx, userID); err == nil {
+		for _, member := range pt.Members {
+			if member != userID {
+				recipients = append(recipients, member)
+			}
+		}
+	}
+	for _, recipient := range recipients {
+		if err := gamestate.PublishToUser(ctx, recipient, event); err != nil {
+			metrics.Log.Error("Failed to deliver profile update", "user_id", recipient, "error", err)
+		}
+	}
+}
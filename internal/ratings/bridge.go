@@ -0,0 +1,104 @@
+package ratings
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Scripts can't reach the database, so report_match.lua pushes results onto
+// this list and the worker below applies them. Each result is moved to a
+// processing list while it's applied, so a crash or a database error doesn't
+// lose it: failed results go back on the queue, and whatever is left in the
+// processing list at startup is requeued. Results that can never apply
+// (malformed ones) are moved to the dead-letter list for inspection.
+const (
+	resultsQueue   = "ratings:results"
+	processingList = "ratings:processing"
+	deadLetterList = "ratings:dead"
+)
+
+type queuedResult struct {
+	ReportedBy string `json:"reported_by"`
+	Result     Result `json:"result"`
+}
+
+// StartResultWorker consumes match results reported from Lua scripts.
+func StartResultWorker(ctx context.Context) {
+	go func() {
+		// Results a previous run was applying when it stopped. Re-applying one
+		// that did commit is harmless: it's refused as a duplicate.
+		for {
+			err := gamestate.RDB.LMove(ctx, processingList, resultsQueue, "RIGHT", "RIGHT").Err()
+			if err != nil {
+				if err != redis.Nil {
+					metrics.Log.Error("Failed to requeue match results", "error", err)
+				}
+				break
+			}
+		}
+
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+
+			item, err := gamestate.RDB.BLMove(ctx, resultsQueue, processingList, "RIGHT", "LEFT", 5*time.Second).Result()
+			if err != nil {
+				// redis.Nil on timeout; anything else is logged and retried after a pause
+				if err != redis.Nil && ctx.Err() == nil {
+					metrics.Log.Error("Failed to read match results", "error", err)
+					time.Sleep(time.Second)
+				}
+				continue
+			}
+
+			var queued queuedResult
+			if err := json.Unmarshal([]byte(item), &queued); err != nil {
+				metrics.Log.Error("Dead-lettering malformed match result", "error", err)
+				settle(ctx, item, deadLetterList)
+				continue
+			}
+
+			changes, err := Apply(ctx, &queued.Result, queued.ReportedBy)
+			switch {
+			case err == nil:
+				metrics.Log.Info("Applied match result", "game_id", queued.Result.GameID, "players", len(changes))
+				settle(ctx, item, "")
+			case errors.Is(err, ErrDuplicateResult):
+				metrics.Log.Warn("Dropping duplicate match result", "game_id", queued.Result.GameID, "reported_by", queued.ReportedBy)
+				settle(ctx, item, "")
+			case errors.Is(err, ErrInvalidResult):
+				metrics.Log.Error("Dead-lettering invalid match result", "game_id", queued.Result.GameID, "error", err)
+				settle(ctx, item, deadLetterList)
+			default:
+				// Retried before newer results
+				metrics.Log.Error("Failed to apply match result, requeueing", "game_id", queued.Result.GameID, "error", err)
+				settle(ctx, item, resultsQueue)
+				time.Sleep(time.Second)
+			}
+		}
+	}()
+}
+
+// settle removes item from the processing list, moving it to dest if set.
+func settle(ctx context.Context, item, dest string) {
+	_, err := gamestate.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if dest == resultsQueue {
+			pipe.RPush(ctx, dest, item)
+		} else if dest != "" {
+			pipe.LPush(ctx, dest, item)
+		}
+		pipe.LRem(ctx, processingList, 1, item)
+		return nil
+	})
+	if err != nil {
+		metrics.Log.Error("Failed to settle match result", "error", err, "result", item)
+	}
+}
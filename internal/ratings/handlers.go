@@ -0,0 +1,131 @@
+package ratings
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"godra/internal/auth"
+	"godra/internal/database"
+)
+
+// ReportResultHandler applies a match result. Needs the matches.report permission.
+func ReportResultHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+
+	var req Result
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	changes, err := Apply(r.Context(), &req, claims.UserID)
+	if err != nil {
+		if errors.Is(err, ErrInvalidResult) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, ErrDuplicateResult) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to apply result", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"changes": changes})
+}
+
+// GetRatingsHandler returns a user's ratings for every mode in a season (current by default).
+func GetRatingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	season := r.URL.Query().Get("season")
+	if season == "" {
+		season = cfg.Season
+	}
+
+	query := database.DB.WithContext(r.Context()).Where("user_id = ? AND season = ?", userID, season)
+	if mode := r.URL.Query().Get("mode"); mode != "" {
+		query = query.Where("mode = ?", mode)
+	}
+
+	var ratings []database.PlayerRating
+	if err := query.Find(&ratings).Error; err != nil {
+		http.Error(w, "Failed to load ratings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"ratings": ratings})
+}
+
+// GetHistoryHandler returns the most recent rating changes for a user.
+func GetHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	query := database.DB.WithContext(r.Context()).Where("user_id = ?", userID)
+	if mode := r.URL.Query().Get("mode"); mode != "" {
+		query = query.Where("mode = ?", mode)
+	}
+
+	var history []database.RatingHistory
+	if err := query.Order("id DESC").Limit(limitParam(r, 50)).Find(&history).Error; err != nil {
+		http.Error(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"history": history})
+}
+
+// LeaderboardHandler returns the top rated players for a mode in a season (current by default).
+func LeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		http.Error(w, "Mode required", http.StatusBadRequest)
+		return
+	}
+	season := r.URL.Query().Get("season")
+	if season == "" {
+		season = cfg.Season
+	}
+
+	var ratings []database.PlayerRating
+	err := database.DB.WithContext(r.Context()).
+		Where("mode = ? AND season = ?", mode, season).
+		Order("rating DESC").
+		Limit(limitParam(r, 100)).
+		Find(&ratings).Error
+	if err != nil {
+		http.Error(w, "Failed to load leaderboard", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"mode":    mode,
+		"season":  season,
+		"ratings": ratings,
+	})
+}
+
+func limitParam(r *http.Request, max int) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > max {
+		return max
+	}
+	return limit
+}
@@ -0,0 +1,271 @@
+package ratings
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"gorm.io/gorm"
+
+	"godra/internal/database"
+)
+
+// Config controls how ratings are calculated.
+type Config struct {
+	InitialRating float64
+	KFactor       float64
+	Season        string
+}
+
+var cfg = Config{
+	InitialRating: 1000,
+	KFactor:       32,
+	Season:        "1",
+}
+
+// Configure replaces the rating settings. Call before serving requests.
+func Configure(c Config) {
+	cfg = c
+}
+
+// CurrentSeason returns the season new results are recorded against.
+func CurrentSeason() string {
+	return cfg.Season
+}
+
+// Team is one side of a match. Rank 1 is the winner; equal ranks are a draw.
+type Team struct {
+	Rank    int      `json:"rank"`
+	Players []string `json:"players"`
+}
+
+// Result is a finished match as reported by a manager or game server.
+type Result struct {
+	GameID string `json:"game_id"`
+	Mode   string `json:"mode"`
+	Teams  []Team `json:"teams"`
+}
+
+// Change is the rating update applied to one player.
+type Change struct {
+	UserID uint    `json:"user_id"`
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+}
+
+var (
+	ErrInvalidResult   = errors.New("invalid match result")
+	ErrDuplicateResult = errors.New("result already reported for this game")
+)
+
+// Get returns the user's rating in the current season, or the initial rating
+// if they haven't played the mode yet. Guests always get the initial rating.
+func Get(ctx context.Context, userID, mode string) float64 {
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return cfg.InitialRating
+	}
+
+	var rating database.PlayerRating
+	err = database.DB.WithContext(ctx).
+		Where("user_id = ? AND mode = ? AND season = ?", id, mode, cfg.Season).
+		First(&rating).Error
+	if err != nil {
+		return cfg.InitialRating
+	}
+	return rating.Rating
+}
+
+// Apply records the result and updates every registered player's Elo rating
+// in a single transaction. Non-numeric (guest) IDs are skipped. Each game can
+// only be reported once.
+func Apply(ctx context.Context, res *Result, reportedBy string) ([]Change, error) {
+	if err := validate(res); err != nil {
+		return nil, err
+	}
+
+	raw, _ := json.Marshal(res.Teams)
+	var changes []Change
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var reported int64
+		if err := tx.Model(&database.MatchResult{}).Where("game_id = ?", res.GameID).Count(&reported).Error; err != nil {
+			return err
+		}
+		if reported > 0 {
+			return ErrDuplicateResult
+		}
+
+		match := database.MatchResult{
+			GameID:     res.GameID,
+			Mode:       res.Mode,
+			Season:     cfg.Season,
+			ReportedBy: reportedBy,
+			Result:     string(raw),
+		}
+		if err := tx.Create(&match).Error; err != nil {
+			return err
+		}
+
+		// Load (or create) every registered player's rating
+		teams := make([][]*database.PlayerRating, len(res.Teams))
+		for i, team := range res.Teams {
+			for _, player := range team.Players {
+				id, err := strconv.ParseUint(player, 10, 64)
+				if err != nil {
+					continue
+				}
+				rating := database.PlayerRating{
+					UserID: uint(id),
+					Mode:   res.Mode,
+					Season: cfg.Season,
+				}
+				if err := tx.Where(&rating).Attrs(database.PlayerRating{Rating: cfg.InitialRating}).
+					FirstOrCreate(&rating).Error; err != nil {
+					return err
+				}
+				teams[i] = append(teams[i], &rating)
+			}
+		}
+
+		deltas := eloDeltas(res.Teams, teams)
+		allDraw := allSameRank(res.Teams)
+		best := bestRank(res.Teams)
+
+		for i, team := range teams {
+			for _, rating := range team {
+				before := rating.Rating
+				rating.Rating += deltas[i]
+				rating.Games++
+				switch {
+				case allDraw:
+					rating.Draws++
+				case res.Teams[i].Rank == best:
+					rating.Wins++
+				default:
+					rating.Losses++
+				}
+				if err := tx.Save(rating).Error; err != nil {
+					return err
+				}
+
+				history := database.RatingHistory{
+					UserID:        rating.UserID,
+					MatchResultID: match.ID,
+					Mode:          res.Mode,
+					Season:        cfg.Season,
+					Before:        before,
+					After:         rating.Rating,
+				}
+				if err := tx.Create(&history).Error; err != nil {
+					return err
+				}
+				changes = append(changes, Change{UserID: rating.UserID, Before: before, After: rating.Rating})
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrDuplicateResult) {
+		// A concurrent report of the same game wins the unique index
+		var reported int64
+		if database.DB.WithContext(ctx).Model(&database.MatchResult{}).Where("game_id = ?", res.GameID).Count(&reported).Error == nil && reported > 0 {
+			return nil, ErrDuplicateResult
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// validate checks a result's shape: a game ID and mode, at least two teams,
+// ranks from 1, and every player on exactly one team.
+func validate(res *Result) error {
+	if res.GameID == "" || res.Mode == "" || len(res.Teams) < 2 {
+		return fmt.Errorf("%w: game_id, mode and at least two teams required", ErrInvalidResult)
+	}
+	seen := map[string]bool{}
+	for _, team := range res.Teams {
+		if team.Rank < 1 {
+			return fmt.Errorf("%w: ranks start at 1", ErrInvalidResult)
+		}
+		if len(team.Players) == 0 {
+			return fmt.Errorf("%w: every team needs players", ErrInvalidResult)
+		}
+		for _, player := range team.Players {
+			if player == "" {
+				return fmt.Errorf("%w: empty player ID", ErrInvalidResult)
+			}
+			if seen[player] {
+				return fmt.Errorf("%w: player %q listed more than once", ErrInvalidResult, player)
+			}
+			seen[player] = true
+		}
+	}
+	return nil
+}
+
+// eloDeltas compares every pair of teams using their average ratings and returns
+// the rating change for each team. The K-factor is split across opponents so a
+// free-for-all doesn't move ratings further than a 1v1.
+func eloDeltas(results []Team, teams [][]*database.PlayerRating) []float64 {
+	avg := make([]float64, len(teams))
+	for i, team := range teams {
+		avg[i] = cfg.InitialRating
+		if len(team) == 0 {
+			continue
+		}
+		sum := 0.0
+		for _, r := range team {
+			sum += r.Rating
+		}
+		avg[i] = sum / float64(len(team))
+	}
+
+	deltas := make([]float64, len(teams))
+	opponents := float64(len(teams) - 1)
+	for i := range teams {
+		for j := range teams {
+			if i == j {
+				continue
+			}
+			expected := 1 / (1 + math.Pow(10, (avg[j]-avg[i])/400))
+			deltas[i] += cfg.KFactor / opponents * (score(results[i].Rank, results[j].Rank) - expected)
+		}
+	}
+	return deltas
+}
+
+// score is the Elo outcome of a team with rank a against a team with rank b.
+func score(a, b int) float64 {
+	switch {
+	case a < b:
+		return 1
+	case a == b:
+		return 0.5
+	default:
+		return 0
+	}
+}
+
+func bestRank(teams []Team) int {
+	best := teams[0].Rank
+	for _, t := range teams[1:] {
+		if t.Rank < best {
+			best = t.Rank
+		}
+	}
+	return best
+}
+
+func allSameRank(teams []Team) bool {
+	for _, t := range teams[1:] {
+		if t.Rank != teams[0].Rank {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,215 @@
+package rbac
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Roles form a hierarchy: each role inherits the permissions of its parent,
+// and a user holding a role also satisfies any requirement for its ancestors.
+// The defaults are guest < player < moderator < manager < admin.
+//
+// Permissions are dotted names such as "chat.moderate". A role granting
+// "chat.*" has every chat permission and "*" grants everything.
+
+type Role struct {
+	Name        string   `json:"name"`
+	Inherits    string   `json:"inherits,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// Requirement is what a caller needs to use a script or endpoint. The caller
+// must hold (or inherit) one of Roles, when set, and every permission in Permissions.
+type Requirement struct {
+	Roles       []string
+	Permissions []string
+}
+
+// Guests chat in their lobbies like everyone else (the load test drives only
+// guests through send_chat), so chat.send starts at the bottom.
+var DefaultRoles = []Role{
+	{Name: "guest", Permissions: []string{"chat.send"}},
+	{Name: "player", Inherits: "guest"},
+	{Name: "moderator", Inherits: "player", Permissions: []string{"chat.moderate", "sanctions.read"}},
+	{Name: "manager", Inherits: "moderator", Permissions: []string{"matches.report", "sessions.revoke", "sanctions.manage"}},
+	{Name: "admin", Inherits: "manager", Permissions: []string{"*"}},
+}
+
+var ErrForbidden = errors.New("forbidden")
+
+// DeniedError says what a caller was missing. It matches ErrForbidden.
+type DeniedError struct {
+	Reason string
+}
+
+func (e *DeniedError) Error() string        { return e.Reason }
+func (e *DeniedError) Is(target error) bool { return target == ErrForbidden }
+
+var (
+	mu    sync.RWMutex
+	roles = index(DefaultRoles)
+)
+
+func index(list []Role) map[string]Role {
+	m := make(map[string]Role, len(list))
+	for _, r := range list {
+		m[r.Name] = r
+	}
+	return m
+}
+
+// Configure replaces the role definitions. Parents must exist and the
+// hierarchy can't loop.
+func Configure(list []Role) error {
+	m := index(list)
+	for _, r := range list {
+		if r.Name == "" {
+			return errors.New("rbac: role without a name")
+		}
+		seen := map[string]bool{}
+		for name := r.Name; name != ""; name = m[name].Inherits {
+			if seen[name] {
+				return fmt.Errorf("rbac: role %q inherits from itself", r.Name)
+			}
+			seen[name] = true
+			if _, ok := m[name]; !ok {
+				return fmt.Errorf("rbac: role %q inherits unknown role %q", r.Name, name)
+			}
+		}
+	}
+
+	mu.Lock()
+	roles = m
+	mu.Unlock()
+	return nil
+}
+
+// LoadFile reads role definitions from a JSON file. Roles in the file replace
+// the default role of the same name; the other defaults are kept.
+func LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var custom []Role
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return fmt.Errorf("rbac: %s: %w", path, err)
+	}
+
+	merged := index(DefaultRoles)
+	order := make([]string, 0, len(DefaultRoles)+len(custom))
+	for _, r := range DefaultRoles {
+		order = append(order, r.Name)
+	}
+	for _, r := range custom {
+		if _, ok := merged[r.Name]; !ok {
+			order = append(order, r.Name)
+		}
+		merged[r.Name] = r
+	}
+
+	list := make([]Role, 0, len(order))
+	for _, name := range order {
+		list = append(list, merged[name])
+	}
+	return Configure(list)
+}
+
+// Exists reports whether a role is defined.
+func Exists(role string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := roles[role]
+	return ok
+}
+
+// Names lists the defined roles.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(roles))
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasRole reports whether a user with role satisfies required: it is the
+// same role or inherits from it.
+func HasRole(role, required string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return hasRole(role, required)
+}
+
+func hasRole(role, required string) bool {
+	for name := role; name != ""; name = roles[name].Inherits {
+		if name == required {
+			return true
+		}
+		if _, ok := roles[name]; !ok {
+			return false
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether role grants perm, directly or by inheritance.
+func HasPermission(role, perm string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return hasPermission(role, perm)
+}
+
+func hasPermission(role, perm string) bool {
+	for name := role; name != ""; name = roles[name].Inherits {
+		r, ok := roles[name]
+		if !ok {
+			return false
+		}
+		for _, granted := range r.Permissions {
+			if matches(granted, perm) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matches(granted, perm string) bool {
+	if granted == "*" || granted == perm {
+		return true
+	}
+	return strings.HasSuffix(granted, ".*") && strings.HasPrefix(perm, strings.TrimSuffix(granted, "*"))
+}
+
+// Authorize checks a caller's role against a requirement.
+func Authorize(role string, req Requirement) error {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if len(req.Roles) > 0 {
+		allowed := false
+		for _, required := range req.Roles {
+			if hasRole(role, required) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return &DeniedError{Reason: strings.Join(req.Roles, " or ") + " role required"}
+		}
+	}
+	for _, perm := range req.Permissions {
+		if !hasPermission(role, perm) {
+			return &DeniedError{Reason: perm + " permission required"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package social
+
+import (
+	"context"
+
+	"godra/internal/database"
+	"godra/internal/gamestate"
+)
+
+// Block stops all contact from targetID: any friendship or pending request
+// between the two is removed, and the target's chat is hidden from userID.
+func Block(ctx context.Context, userID, targetID uint) error {
+	if userID == targetID {
+		return ErrSelf
+	}
+	if err := database.DB.WithContext(ctx).First(&database.User{}, targetID).Error; err != nil {
+		return ErrUserNotFound
+	}
+
+	db := database.DB.WithContext(ctx)
+	err := db.Unscoped().
+		Where("(user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?)", userID, targetID, targetID, userID).
+		Delete(&database.Friendship{}).Error
+	if err != nil {
+		return err
+	}
+	err = db.Where(database.Block{UserID: userID, BlockedID: targetID}).
+		FirstOrCreate(&database.Block{}).Error
+	if err != nil {
+		return err
+	}
+
+	reloadBlocks(ctx, userID)
+	return nil
+}
+
+// Unblock lifts a block.
+func Unblock(ctx context.Context, userID, targetID uint) error {
+	err := database.DB.WithContext(ctx).Unscoped().
+		Where("user_id = ? AND blocked_id = ?", userID, targetID).
+		Delete(&database.Block{}).Error
+	if err != nil {
+		return err
+	}
+
+	reloadBlocks(ctx, userID)
+	return nil
+}
+
+// ListBlocked returns the users userID has blocked.
+func ListBlocked(ctx context.Context, userID uint) ([]Friend, error) {
+	var rows []database.Block
+	if err := database.DB.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(rows))
+	for i, b := range rows {
+		ids[i] = b.BlockedID
+	}
+	names, err := usernames(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked := make([]Friend, len(rows))
+	for i, b := range rows {
+		blocked[i] = Friend{UserID: b.BlockedID, Username: names[b.BlockedID], Since: b.CreatedAt.Unix()}
+	}
+	return blocked, nil
+}
+
+// IsBlocked reports whether either user has blocked the other.
+func IsBlocked(ctx context.Context, a, b uint) (bool, error) {
+	var count int64
+	err := database.DB.WithContext(ctx).Model(&database.Block{}).
+		Where("(user_id = ? AND blocked_id = ?) OR (user_id = ? AND blocked_id = ?)", a, b, b, a).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Blocks reports whether either user has blocked the other, taking token user
+// IDs. Guests can't block or be blocked.
+func Blocks(ctx context.Context, a, b string) bool {
+	idA, errA := ParseUserID(a)
+	idB, errB := ParseUserID(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	blocked, _ := IsBlocked(ctx, idA, idB)
+	return blocked
+}
+
+// BlockedIDs returns the token user IDs that userID has blocked. The WebSocket
+// hub uses it to drop chat from blocked players.
+func BlockedIDs(ctx context.Context, userID string) []string {
+	id, err := ParseUserID(userID)
+	if err != nil {
+		return nil
+	}
+	var ids []uint
+	database.DB.WithContext(ctx).Model(&database.Block{}).Where("user_id = ?", id).Pluck("blocked_id", &ids)
+
+	blocked := make([]string, len(ids))
+	for i, b := range ids {
+		blocked[i] = formatID(b)
+	}
+	return blocked
+}
+
+// reloadBlocks tells every node to refresh the block list of the user's sockets.
+func reloadBlocks(ctx context.Context, userID uint) {
+	gamestate.SendControl(ctx, gamestate.ControlMessage{
+		Type:   "reload_blocks",
+		UserID: formatID(userID),
+	})
+}
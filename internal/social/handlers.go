@@ -0,0 +1,216 @@
+package social
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"godra/internal/auth"
+	"godra/internal/database"
+)
+
+// TargetRequest names another user by ID or username.
+type TargetRequest struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// ListFriendsHandler returns the caller's friends and pending requests.
+func ListFriendsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := List(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to load friends", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// SendRequestHandler sends a friend request.
+func SendRequestHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+	targetID, ok := decodeTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := SendRequest(r.Context(), userID, targetID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptRequestHandler accepts the request from {userID}.
+func AcceptRequestHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+	otherID, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := Accept(r.Context(), userID, otherID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeclineRequestHandler declines the request from {userID}, or cancels the
+// caller's own request to them.
+func DeclineRequestHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+	otherID, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := Decline(r.Context(), userID, otherID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveFriendHandler ends a friendship.
+func RemoveFriendHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+	friendID, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := Remove(r.Context(), userID, friendID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListBlockedHandler returns the users the caller blocked.
+func ListBlockedHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	blocked, err := ListBlocked(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to load blocked users", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"blocked": blocked})
+}
+
+// BlockHandler blocks a user.
+func BlockHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+	targetID, ok := decodeTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := Block(r.Context(), userID, targetID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnblockHandler lifts a block on {userID}.
+func UnblockHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+	targetID, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := Unblock(r.Context(), userID, targetID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func callerID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	claims := auth.ClaimsFromContext(r.Context())
+	id, err := ParseUserID(claims.UserID)
+	if err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return 0, false
+	}
+	return id, true
+}
+
+func pathUserID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func decodeTarget(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	var req TargetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.UserID == 0 && req.Username == "") {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return 0, false
+	}
+	if req.UserID != 0 {
+		return req.UserID, true
+	}
+
+	var user database.User
+	if err := database.DB.WithContext(r.Context()).Where("username = ?", req.Username).First(&user).Error; err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return 0, false
+	}
+	return user.ID, true
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, ErrNoRequest), errors.Is(err, ErrNotFriends):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrAlreadyFriends), errors.Is(err, ErrAlreadyRequested):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrBlocked), errors.Is(err, ErrGuest):
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrSelf):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Friends request failed", http.StatusInternalServerError)
+	}
+}
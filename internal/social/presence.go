@@ -0,0 +1,166 @@
+package social
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"godra/internal/database"
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Presence is tracked per node, so a node that dies without closing its
+// sockets doesn't leave its users online forever:
+//
+//	online:<uid>  sorted set of the nodes the user has sockets on, scored by
+//	              when the entry expires
+//
+// Each node counts its own sockets in memory and refreshes its entries every
+// presenceHeartbeat. Friends are told when the user's first node entry is
+// added (friend_online) and their last one removed (friend_offline).
+
+const (
+	presenceTTL       = time.Minute
+	presenceHeartbeat = 20 * time.Second
+)
+
+var (
+	nodeID = database.GenerateRandomString(12)
+
+	socketsMu sync.Mutex
+	sockets   = make(map[string]int) // Open sockets on this node by user ID
+)
+
+func presenceKey(userID string) string { return "online:" + userID }
+
+// StartPresence refreshes this node's presence entries until ctx is done.
+func StartPresence(ctx context.Context) {
+	ticker := time.NewTicker(presenceHeartbeat)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				refreshPresence(ctx)
+			}
+		}
+	}()
+}
+
+func refreshPresence(ctx context.Context) {
+	socketsMu.Lock()
+	users := make([]string, 0, len(sockets))
+	for userID := range sockets {
+		users = append(users, userID)
+	}
+	socketsMu.Unlock()
+	if len(users) == 0 {
+		return
+	}
+
+	expires := float64(time.Now().Add(presenceTTL).Unix())
+	_, err := gamestate.RDB.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, userID := range users {
+			pipe.ZAdd(ctx, presenceKey(userID), redis.Z{Score: expires, Member: nodeID})
+			pipe.Expire(ctx, presenceKey(userID), presenceTTL)
+		}
+		return nil
+	})
+	if err != nil {
+		metrics.Log.Error("Failed to refresh presence", "error", err)
+	}
+}
+
+// Connected records a new socket for the user. Guests have no friends and are skipped.
+func Connected(ctx context.Context, userID string) {
+	if _, err := ParseUserID(userID); err != nil {
+		return
+	}
+	socketsMu.Lock()
+	sockets[userID]++
+	first := sockets[userID] == 1
+	socketsMu.Unlock()
+	if !first {
+		return
+	}
+
+	key := presenceKey(userID)
+	now := time.Now()
+	var added *redis.IntCmd
+	var nodes *redis.IntCmd
+	_, err := gamestate.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Unix(), 10))
+		added = pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Add(presenceTTL).Unix()), Member: nodeID})
+		nodes = pipe.ZCard(ctx, key)
+		pipe.Expire(ctx, key, presenceTTL)
+		return nil
+	})
+	if err != nil {
+		metrics.Log.Error("Failed to update presence", "user_id", userID, "error", err)
+		return
+	}
+	if added.Val() == 1 && nodes.Val() == 1 {
+		notifyFriends(ctx, userID, "friend_online")
+	}
+}
+
+// Disconnected records a closed socket for the user.
+func Disconnected(ctx context.Context, userID string) {
+	if _, err := ParseUserID(userID); err != nil {
+		return
+	}
+	socketsMu.Lock()
+	sockets[userID]--
+	last := sockets[userID] <= 0
+	if last {
+		delete(sockets, userID)
+	}
+	socketsMu.Unlock()
+	if !last {
+		return
+	}
+
+	key := presenceKey(userID)
+	var removed *redis.IntCmd
+	var nodes *redis.IntCmd
+	_, err := gamestate.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.ZRem(ctx, key, nodeID)
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(time.Now().Unix(), 10))
+		nodes = pipe.ZCard(ctx, key)
+		return nil
+	})
+	if err != nil {
+		metrics.Log.Error("Failed to update presence", "user_id", userID, "error", err)
+		return
+	}
+	if removed.Val() == 1 && nodes.Val() == 0 {
+		notifyFriends(ctx, userID, "friend_offline")
+	}
+}
+
+// IsOnline reports whether the user has at least one socket open on a live node.
+func IsOnline(ctx context.Context, userID string) bool {
+	n, _ := gamestate.RDB.ZCount(ctx, presenceKey(userID), "("+strconv.FormatInt(time.Now().Unix(), 10), "+inf").Result()
+	return n > 0
+}
+
+func notifyFriends(ctx context.Context, userID, eventType string) {
+	id, err := ParseUserID(userID)
+	if err != nil {
+		return
+	}
+	friends, err := FriendIDs(ctx, id)
+	if err != nil {
+		metrics.Log.Error("Failed to load friends", "user_id", userID, "error", err)
+		return
+	}
+	for _, friend := range friends {
+		notify(ctx, friend, eventType, map[string]interface{}{"user_id": id})
+	}
+}
@@ -0,0 +1,275 @@
+package social
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"godra/internal/database"
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+)
+
+// Friends and blocks are stored in the database. Only registered users take part:
+// guest IDs ("guest:...") have no user row to attach relationships to.
+
+var (
+	ErrGuest            = errors.New("guests can't have friends")
+	ErrSelf             = errors.New("that's you")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrAlreadyFriends   = errors.New("already friends")
+	ErrAlreadyRequested = errors.New("friend request already sent")
+	ErrNoRequest        = errors.New("no pending friend request")
+	ErrNotFriends       = errors.New("not friends")
+	ErrBlocked          = errors.New("user is blocked")
+)
+
+const (
+	statusPending  = "pending"
+	statusAccepted = "accepted"
+)
+
+type Friend struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Online   bool   `json:"online"`
+	Since    int64  `json:"since"`
+}
+
+type FriendList struct {
+	Friends  []Friend `json:"friends"`
+	Incoming []Friend `json:"incoming"` // Requests waiting for the caller's answer
+	Outgoing []Friend `json:"outgoing"` // Requests the caller sent
+}
+
+// ParseUserID converts a token user ID to a database ID. Guests are rejected.
+func ParseUserID(userID string) (uint, error) {
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return 0, ErrGuest
+	}
+	return uint(id), nil
+}
+
+func formatID(id uint) string { return strconv.FormatUint(uint64(id), 10) }
+
+// SendRequest asks target to become userID's friend. If target already sent
+// userID a request, the two become friends straight away.
+func SendRequest(ctx context.Context, userID, targetID uint) error {
+	if userID == targetID {
+		return ErrSelf
+	}
+	db := database.DB.WithContext(ctx)
+
+	if err := db.First(&database.User{}, targetID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	if blocked, err := IsBlocked(ctx, userID, targetID); err != nil || blocked {
+		if err != nil {
+			return err
+		}
+		return ErrBlocked
+	}
+
+	var existing database.Friendship
+	err := db.Where("user_id = ? AND friend_id = ?", userID, targetID).First(&existing).Error
+	if err == nil {
+		if existing.Status == statusAccepted {
+			return ErrAlreadyFriends
+		}
+		return ErrAlreadyRequested
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	var reverse database.Friendship
+	err = db.Where("user_id = ? AND friend_id = ? AND status = ?", targetID, userID, statusPending).First(&reverse).Error
+	if err == nil {
+		return Accept(ctx, userID, targetID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if err := db.Create(&database.Friendship{UserID: userID, FriendID: targetID, Status: statusPending}).Error; err != nil {
+		return err
+	}
+
+	notify(ctx, targetID, "friend_request", map[string]interface{}{
+		"user_id":  userID,
+		"username": usernameOf(ctx, userID),
+	})
+	return nil
+}
+
+// Accept accepts requesterID's pending request to userID.
+func Accept(ctx context.Context, userID, requesterID uint) error {
+	now := time.Now()
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&database.Friendship{}).
+			Where("user_id = ? AND friend_id = ? AND status = ?", requesterID, userID, statusPending).
+			Updates(map[string]interface{}{"status": statusAccepted, "accepted_at": now})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNoRequest
+		}
+
+		// A crossed request from userID may already exist; replace it
+		if err := tx.Unscoped().Where("user_id = ? AND friend_id = ?", userID, requesterID).Delete(&database.Friendship{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&database.Friendship{UserID: userID, FriendID: requesterID, Status: statusAccepted, AcceptedAt: &now}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	notify(ctx, requesterID, "friend_accepted", map[string]interface{}{
+		"user_id":  userID,
+		"username": usernameOf(ctx, userID),
+		"online":   IsOnline(ctx, formatID(userID)),
+	})
+	return nil
+}
+
+// Decline drops a pending request between the two users, in either direction,
+// so it covers both refusing an incoming request and cancelling an outgoing one.
+func Decline(ctx context.Context, userID, otherID uint) error {
+	res := database.DB.WithContext(ctx).Unscoped().
+		Where("status = ? AND ((user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?))",
+			statusPending, otherID, userID, userID, otherID).
+		Delete(&database.Friendship{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNoRequest
+	}
+	return nil
+}
+
+// Remove ends a friendship for both users.
+func Remove(ctx context.Context, userID, friendID uint) error {
+	res := database.DB.WithContext(ctx).Unscoped().
+		Where("status = ? AND ((user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?))",
+			statusAccepted, userID, friendID, friendID, userID).
+		Delete(&database.Friendship{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFriends
+	}
+
+	notify(ctx, friendID, "friend_removed", map[string]interface{}{"user_id": userID})
+	return nil
+}
+
+// List returns the user's friends with their online status, plus pending requests.
+func List(ctx context.Context, userID uint) (*FriendList, error) {
+	var rows []database.Friendship
+	err := database.DB.WithContext(ctx).
+		Where("user_id = ? OR (friend_id = ? AND status = ?)", userID, userID, statusPending).
+		Order("id").
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(rows))
+	for _, f := range rows {
+		if f.UserID == userID {
+			ids = append(ids, f.FriendID)
+		} else {
+			ids = append(ids, f.UserID)
+		}
+	}
+	names, err := usernames(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &FriendList{Friends: []Friend{}, Incoming: []Friend{}, Outgoing: []Friend{}}
+	for i, f := range rows {
+		friend := Friend{UserID: ids[i], Username: names[ids[i]], Since: f.CreatedAt.Unix()}
+		switch {
+		case f.Status == statusAccepted:
+			if f.AcceptedAt != nil {
+				friend.Since = f.AcceptedAt.Unix()
+			}
+			list.Friends = append(list.Friends, friend)
+		case f.UserID == userID:
+			list.Outgoing = append(list.Outgoing, friend)
+		default:
+			list.Incoming = append(list.Incoming, friend)
+		}
+	}
+
+	if len(list.Friends) > 0 {
+		keys := make([]string, len(list.Friends))
+		for i, f := range list.Friends {
+			keys[i] = presenceKey(formatID(f.UserID))
+		}
+		counts, err := gamestate.RDB.MGet(ctx, keys...).Result()
+		if err == nil {
+			for i, c := range counts {
+				list.Friends[i].Online = c != nil && c != "0"
+			}
+		}
+	}
+	return list, nil
+}
+
+// FriendIDs returns the IDs of the user's accepted friends.
+func FriendIDs(ctx context.Context, userID uint) ([]uint, error) {
+	var ids []uint
+	err := database.DB.WithContext(ctx).Model(&database.Friendship{}).
+		Where("user_id = ? AND status = ?", userID, statusAccepted).
+		Pluck("friend_id", &ids).Error
+	return ids, err
+}
+
+func usernames(ctx context.Context, ids []uint) (map[uint]string, error) {
+	names := make(map[uint]string, len(ids))
+	if len(ids) == 0 {
+		return names, nil
+	}
+	var users []database.User
+	if err := database.DB.WithContext(ctx).Select("id", "username").Find(&users, ids).Error; err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		names[u.ID] = u.Username
+	}
+	return names, nil
+}
+
+func usernameOf(ctx context.Context, id uint) string {
+	names, _ := usernames(ctx, []uint{id})
+	return names[id]
+}
+
+// notify delivers an event to the user's sockets.
+func notify(ctx context.Context, userID uint, eventType string, payload interface{}) {
+	msg, err := json.Marshal(map[string]interface{}{
+		"type":    eventType,
+		"payload": payload,
+	})
+	if err != nil {
+		return
+	}
+	id := formatID(userID)
+	if err := gamestate.PublishToUser(ctx, id, msg); err != nil {
+		metrics.Log.Error("Failed to send social event", "user_id", id, "type", eventType, "error", err)
+	}
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Scripts can't reach the database, so storage_write.lua (or any game script)
+// pushes writes onto this list and the worker below applies them. The write
+// is queued as the JSON string the caller sent, so the value is stored exactly
+// as written rather than after a round trip through Lua's cjson (which rounds
+// numbers and turns [] into {}):
+//
+//	redis.call("LPUSH", "storage:writes", cjson.encode({
+//	    user_id = user_id,
+//	    raw = '{"collection":"progress","key":"save1","value":{"level":3},"version":2}',
+//	}))
+//
+// version and permission are optional; "delete": true removes the object
+// instead. Rejected writes (a version conflict, an invalid value, ...) are
+// logged and the owner gets a "storage_write_failed" event. Writes that fail
+// for any other reason, such as the database being down, go back on the queue.
+const writesQueue = "storage:writes"
+
+type queuedWrite struct {
+	UserID string `json:"user_id"`
+	Raw    string `json:"raw"`
+}
+
+type rawWrite struct {
+	Write
+	Delete bool `json:"delete"`
+}
+
+// StartWorker consumes storage writes queued from Lua scripts.
+func StartWorker(ctx context.Context) {
+	go func() {
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+
+			item, err := gamestate.RDB.BRPop(ctx, 5*time.Second, writesQueue).Result()
+			if err != nil {
+				// redis.Nil on timeout; anything else is logged and retried after a pause
+				if err != redis.Nil && ctx.Err() == nil {
+					metrics.Log.Error("Failed to read storage writes", "error", err)
+					time.Sleep(time.Second)
+				}
+				continue
+			}
+
+			var queued queuedWrite
+			var w rawWrite
+			if err := json.Unmarshal([]byte(item[1]), &queued); err != nil {
+				metrics.Log.Error("Dropping malformed storage write", "error", err)
+				continue
+			}
+			if err := json.Unmarshal([]byte(queued.Raw), &w); err != nil {
+				metrics.Log.Error("Dropping malformed storage write", "user_id", queued.UserID, "error", err)
+				continue
+			}
+			userID, err := strconv.ParseUint(queued.UserID, 10, 64)
+			if err != nil || userID == 0 {
+				metrics.Log.Error("Dropping storage write for a user without storage", "user_id", queued.UserID)
+				continue
+			}
+
+			if w.Delete {
+				err = DeleteObject(ctx, uint(userID), w.Collection, w.Key, w.Version)
+			} else {
+				_, err = WriteObjects(ctx, uint(userID), []Write{w.Write})
+			}
+			switch {
+			case err == nil:
+			case rejected(err):
+				metrics.Log.Info("Storage write rejected", "user_id", queued.UserID, "collection", w.Collection, "key", w.Key, "error", err)
+				notifyFailed(ctx, queued.UserID, &w, err)
+			default:
+				// Back to the end it was popped from, so it's retried before newer writes
+				metrics.Log.Error("Failed to apply storage write, requeueing", "user_id", queued.UserID, "error", err)
+				if err := gamestate.RDB.RPush(context.Background(), writesQueue, item[1]).Err(); err != nil {
+					metrics.Log.Error("Lost storage write", "user_id", queued.UserID, "write", queued.Raw, "error", err)
+				}
+				time.Sleep(time.Second)
+			}
+		}
+	}()
+}
+
+// rejected reports whether err is the write's fault, so retrying can't help.
+func rejected(err error) bool {
+	for _, e := range []error{ErrInvalidName, ErrInvalidValue, ErrValueTooLarge, ErrInvalidPermission,
+		ErrBatchTooLarge, ErrVersionConflict, ErrNotFound} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+func notifyFailed(ctx context.Context, userID string, w *rawWrite, cause error) {
+	event, _ := json.Marshal(map[string]interface{}{
+		"type": "storage_write_failed",
+		"payload": map[string]interface{}{
+			"collection": w.Collection,
+			"key":        w.Key,
+			"error":      cause.Error(),
+		},
+	})
+	if err := gamestate.PublishToUser(ctx, userID, event); err != nil {
+		metrics.Log.Error("Failed to notify storage write failure", "user_id", userID, "error", err)
+	}
+}
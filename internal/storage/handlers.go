@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"godra/internal/auth"
+	"godra/internal/metrics"
+	"godra/internal/social"
+)
+
+type batchReadRequest struct {
+	Objects []ObjectID `json:"objects"`
+}
+
+type batchWriteRequest struct {
+	Objects []Write `json:"objects"`
+}
+
+type putRequest struct {
+	Value      json.RawMessage `json:"value"`
+	Version    *int64          `json:"version"`
+	Permission string          `json:"permission"`
+}
+
+type listResponse struct {
+	Objects []Object `json:"objects"`
+	Cursor  string   `json:"cursor,omitempty"`
+}
+
+// ListHandler returns a page of a collection: the caller's, or the public
+// objects of ?user_id=.
+func ListHandler(w http.ResponseWriter, r *http.Request) {
+	callerID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+	ownerID, ok := ownerParam(w, r, callerID)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	objects, cursor, err := List(r.Context(), callerID, ownerID, chi.URLParam(r, "collection"), r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listResponse{Objects: objects, Cursor: cursor})
+}
+
+// GetHandler returns one object, the caller's or ?user_id='s. Objects the
+// caller may not read are reported as missing.
+func GetHandler(w http.ResponseWriter, r *http.Request) {
+	callerID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+	ownerID, ok := ownerParam(w, r, callerID)
+	if !ok {
+		return
+	}
+
+	objects, err := ReadObjects(r.Context(), callerID, []ObjectID{{
+		UserID:     ownerID,
+		Collection: chi.URLParam(r, "collection"),
+		Key:        chi.URLParam(r, "key"),
+	}})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(objects) == 0 {
+		writeError(w, ErrNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(objects[0])
+}
+
+// PutHandler writes one of the caller's objects and returns it with its new version.
+func PutHandler(w http.ResponseWriter, r *http.Request) {
+	callerID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	var req putRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	objects, err := WriteObjects(r.Context(), callerID, []Write{{
+		Collection: chi.URLParam(r, "collection"),
+		Key:        chi.URLParam(r, "key"),
+		Value:      req.Value,
+		Version:    req.Version,
+		Permission: req.Permission,
+	}})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(objects[0])
+}
+
+// DeleteHandler removes one of the caller's objects, only at ?version= if given.
+func DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	callerID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	var version *int64
+	if v := r.URL.Query().Get("version"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid version", http.StatusBadRequest)
+			return
+		}
+		version = &n
+	}
+
+	if err := DeleteObject(r.Context(), callerID, chi.URLParam(r, "collection"), chi.URLParam(r, "key"), version); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BatchReadHandler returns the listed objects the caller may read.
+func BatchReadHandler(w http.ResponseWriter, r *http.Request) {
+	callerID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	var req batchReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	objects, err := ReadObjects(r.Context(), callerID, req.Objects)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"objects": objects})
+}
+
+// BatchWriteHandler writes several of the caller's objects atomically.
+func BatchWriteHandler(w http.ResponseWriter, r *http.Request) {
+	callerID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	var req batchWriteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	objects, err := WriteObjects(r.Context(), callerID, req.Objects)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"objects": objects})
+}
+
+func ownerParam(w http.ResponseWriter, r *http.Request, callerID uint) (uint, bool) {
+	raw := r.URL.Query().Get("user_id")
+	if raw == "" {
+		return callerID, true
+	}
+	id, err := social.ParseUserID(raw)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func callerID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	claims := auth.ClaimsFromContext(r.Context())
+	id, err := social.ParseUserID(claims.UserID)
+	if err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return 0, false
+	}
+	return id, true
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrVersionConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrValueTooLarge):
+		http.Error(w, "Value must be at most "+strconv.Itoa(MaxValueSize)+" bytes", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrBatchTooLarge):
+		http.Error(w, "At most "+strconv.Itoa(MaxBatch)+" objects per request", http.StatusBadRequest)
+	case errors.Is(err, ErrInvalidName), errors.Is(err, ErrInvalidValue),
+		errors.Is(err, ErrInvalidPermission), errors.Is(err, ErrInvalidCursor):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		metrics.Log.Error("Storage request failed", "error", err)
+		http.Error(w, "Storage request failed", http.StatusInternalServerError)
+	}
+}
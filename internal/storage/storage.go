@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"time"
+
+	"gorm.io/gorm"
+
+	"godra/internal/database"
+)
+
+// Storage
+//
+// Each registered user has named collections of JSON objects, stored in the
+// database. Objects are addressed by (user, collection, key) and carry a
+// version that goes up on every write, so clients can write conditionally:
+//
+//	no version   write whatever is stored
+//	version 0    only create; fails if the object exists
+//	version n    only replace version n
+//
+// A failed condition is ErrVersionConflict; the client reads the object
+// again and retries. Objects are readable by their owner only, or by
+// everyone when their permission is "public". Only the owner writes.
+
+const (
+	ReadOwner  = "owner"
+	ReadPublic = "public"
+)
+
+var (
+	MaxValueSize = 64 * 1024 // Bytes of compacted JSON per object
+	MaxBatch     = 100       // Objects per batch read or write
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var (
+	ErrInvalidName       = errors.New("collection and key must be 1-128 letters, digits, '.', '_' or '-'")
+	ErrInvalidValue      = errors.New("value must be a JSON object")
+	ErrValueTooLarge     = errors.New("value is too large")
+	ErrInvalidPermission = errors.New(`permission must be "owner" or "public"`)
+	ErrInvalidCursor     = errors.New("invalid cursor")
+	ErrBatchTooLarge     = errors.New("too many objects in one request")
+	ErrVersionConflict   = errors.New("version conflict")
+	ErrNotFound          = errors.New("object not found")
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Object is a stored object as clients see it.
+type Object struct {
+	UserID     uint            `json:"user_id"`
+	Collection string          `json:"collection"`
+	Key        string          `json:"key"`
+	Value      json.RawMessage `json:"value"`
+	Version    int64           `json:"version"`
+	Permission string          `json:"permission"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// ObjectID addresses an object in a batch read. UserID defaults to the caller.
+type ObjectID struct {
+	UserID     uint   `json:"user_id,omitempty"`
+	Collection string `json:"collection"`
+	Key        string `json:"key"`
+}
+
+// Write stores Value under Collection/Key. See the package comment for
+// Version. An empty Permission keeps the current one ("owner" for new objects).
+type Write struct {
+	Collection string          `json:"collection"`
+	Key        string          `json:"key"`
+	Value      json.RawMessage `json:"value"`
+	Version    *int64          `json:"version,omitempty"`
+	Permission string          `json:"permission,omitempty"`
+}
+
+// WriteObjects applies writes for the user in one transaction: all of them
+// succeed or none does.
+func WriteObjects(ctx context.Context, userID uint, writes []Write) ([]Object, error) {
+	if len(writes) > MaxBatch {
+		return nil, ErrBatchTooLarge
+	}
+	values := make([]string, len(writes))
+	for i, w := range writes {
+		value, err := validate(w)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+
+	objects := make([]Object, 0, len(writes))
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i, w := range writes {
+			row, err := write(tx, userID, w, values[i])
+			if err != nil {
+				return err
+			}
+			objects = append(objects, toObject(row))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func validate(w Write) (string, error) {
+	if !namePattern.MatchString(w.Collection) || !namePattern.MatchString(w.Key) {
+		return "", ErrInvalidName
+	}
+	if w.Permission != "" && w.Permission != ReadOwner && w.Permission != ReadPublic {
+		return "", ErrInvalidPermission
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, w.Value); err != nil || compact.Len() == 0 || compact.Bytes()[0] != '{' {
+		return "", ErrInvalidValue
+	}
+	if compact.Len() > MaxValueSize {
+		return "", ErrValueTooLarge
+	}
+	return compact.String(), nil
+}
+
+func write(tx *gorm.DB, userID uint, w Write, value string) (*database.StorageObject, error) {
+	var row database.StorageObject
+	err := tx.Where("user_id = ? AND collection = ? AND key = ?", userID, w.Collection, w.Key).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if w.Version != nil && *w.Version != 0 {
+			return nil, ErrVersionConflict
+		}
+		row = database.StorageObject{
+			UserID:     userID,
+			Collection: w.Collection,
+			Key:        w.Key,
+			Value:      value,
+			Version:    1,
+			Permission: w.Permission,
+		}
+		if row.Permission == "" {
+			row.Permission = ReadOwner
+		}
+		// In a savepoint, so losing a race to create the same object is a
+		// conflict rather than an aborted transaction
+		err := tx.Transaction(func(sp *gorm.DB) error { return sp.Create(&row).Error })
+		if err != nil {
+			var count int64
+			if tx.Model(&database.StorageObject{}).
+				Where("user_id = ? AND collection = ? AND key = ?", userID, w.Collection, w.Key).
+				Count(&count).Error == nil && count > 0 {
+				return nil, ErrVersionConflict
+			}
+			return nil, err
+		}
+		return &row, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if w.Version != nil && *w.Version != row.Version {
+		return nil, ErrVersionConflict
+	}
+	updates := map[string]interface{}{"value": value, "version": row.Version + 1}
+	if w.Permission != "" {
+		updates["permission"] = w.Permission
+	}
+	// The version check also catches a write that raced this one
+	res := tx.Model(&database.StorageObject{}).Where("id = ? AND version = ?", row.ID, row.Version).Updates(updates)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrVersionConflict
+	}
+	return &row, tx.First(&row, row.ID).Error
+}
+
+// DeleteObject removes one of the user's objects. A non-nil version must match.
+func DeleteObject(ctx context.Context, userID uint, collection, key string, version *int64) error {
+	q := database.DB.WithContext(ctx).Unscoped().
+		Where("user_id = ? AND collection = ? AND key = ?", userID, collection, key)
+	if version != nil {
+		q = q.Where("version = ?", *version)
+	}
+	res := q.Delete(&database.StorageObject{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+
+	// Tell a missing object from a stale version
+	var count int64
+	err := database.DB.WithContext(ctx).Model(&database.StorageObject{}).
+		Where("user_id = ? AND collection = ? AND key = ?", userID, collection, key).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrVersionConflict
+	}
+	return ErrNotFound
+}
+
+// ReadObjects returns the requested objects that exist and callerID may read,
+// in request order. Missing and unreadable objects are left out.
+func ReadObjects(ctx context.Context, callerID uint, ids []ObjectID) ([]Object, error) {
+	if len(ids) > MaxBatch {
+		return nil, ErrBatchTooLarge
+	}
+	objects := []Object{}
+	if len(ids) == 0 {
+		return objects, nil
+	}
+
+	cond := database.DB
+	for i, id := range ids {
+		if id.UserID == 0 {
+			ids[i].UserID = callerID
+		}
+		if i == 0 {
+			cond = cond.Where("user_id = ? AND collection = ? AND key = ?", ids[i].UserID, id.Collection, id.Key)
+		} else {
+			cond = cond.Or("user_id = ? AND collection = ? AND key = ?", ids[i].UserID, id.Collection, id.Key)
+		}
+	}
+	var rows []database.StorageObject
+	if err := database.DB.WithContext(ctx).Where(cond).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[ObjectID]*database.StorageObject, len(rows))
+	for i := range rows {
+		r := &rows[i]
+		found[ObjectID{UserID: r.UserID, Collection: r.Collection, Key: r.Key}] = r
+	}
+	for _, id := range ids {
+		if r, ok := found[id]; ok && readable(r, callerID) {
+			objects = append(objects, toObject(r))
+		}
+	}
+	return objects, nil
+}
+
+// List returns a page of ownerID's objects in collection ordered by key.
+// Other users only see public objects. cursor comes from the previous page;
+// the returned one is empty on the last page.
+func List(ctx context.Context, callerID, ownerID uint, collection, cursor string, limit int) ([]Object, string, error) {
+	if !namePattern.MatchString(collection) {
+		return nil, "", ErrInvalidName
+	}
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	q := database.DB.WithContext(ctx).Where("user_id = ? AND collection = ?", ownerID, collection)
+	if callerID != ownerID {
+		q = q.Where("permission = ?", ReadPublic)
+	}
+	if cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		q = q.Where("key > ?", string(after))
+	}
+
+	var rows []database.StorageObject
+	if err := q.Order("key").Limit(limit + 1).Find(&rows).Error; err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(rows) > limit {
+		rows = rows[:limit]
+		next = base64.RawURLEncoding.EncodeToString([]byte(rows[limit-1].Key))
+	}
+	objects := make([]Object, 0, len(rows))
+	for i := range rows {
+		objects = append(objects, toObject(&rows[i]))
+	}
+	return objects, next, nil
+}
+
+func readable(r *database.StorageObject, callerID uint) bool {
+	return r.UserID == callerID || r.Permission == ReadPublic
+}
+
+func toObject(r *database.StorageObject) Object {
+	return Object{
+		UserID:     r.UserID,
+		Collection: r.Collection,
+		Key:        r.Key,
+		Value:      json.RawMessage(r.Value),
+		Version:    r.Version,
+		Permission: r.Permission,
+		CreatedAt:  r.CreatedAt,
+		UpdatedAt:  r.UpdatedAt,
+	}
+}
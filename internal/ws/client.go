@@ -4,15 +4,21 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"godra/internal/auth"
 	"godra/internal/gamestate"
+	"godra/internal/lobby"
 	"godra/internal/metrics"
+	"godra/internal/social"
 
 	"github.com/gorilla/websocket"
 )
 
+// How often player actions refresh the lobby's last_activity (read by the lobby reaper).
+const activityInterval = 30 * time.Second
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
@@ -23,7 +29,11 @@ type Client struct {
 	Send     chan []byte
 	UserID   string
 	Username string
+	Role     string
 	GameID   string
+
+	lastActivity time.Time                       // Last time this client refreshed the lobby's last_activity
+	blocked      atomic.Pointer[map[string]bool] // Users whose chat this client doesn't receive
 }
 
 type IncomingMessage struct {
@@ -39,25 +49,35 @@ func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// game_id is optional: without it the socket only receives events
+	// addressed to the user (matchmaking, invites, ...)
 	gameID := r.URL.Query().Get("game_id")
-	if gameID == "" {
-		http.Error(w, "Missing game_id", http.StatusBadRequest)
-		return
-	}
+	if gameID != "" {
+		// Private / password protected lobbies: invite code and password come from the query
+		q := r.URL.Query()
+		if err := lobby.Authorize(r.Context(), gameID, claims.UserID, q.Get("invite"), q.Get("password")); err != nil {
+			lobby.WriteAccessError(w, err)
+			return
+		}
 
-	// We execute "on_connect" script which validates lobby and joins user
-	gameKey := "game:" + gameID
-	playersKey := gameKey + ":players"
-	_, err = gamestate.ExecuteScript(r.Context(), "on_connect", []string{gameKey, playersKey}, claims.Username, gameID)
-	if err != nil {
-		log.Printf("Connection rejected by on_connect hook: %v", err)
-		http.Error(w, "Connection rejected: "+err.Error(), http.StatusForbidden)
-		return
+		// We execute "on_connect" script which validates lobby and joins user
+		gameKey := "game:" + gameID
+		playersKey := gameKey + ":players"
+		_, err = gamestate.ExecuteScript(r.Context(), "on_connect", []string{gameKey, playersKey}, claims.UserID, gameID)
+		if err != nil {
+			log.Printf("Connection rejected by on_connect hook: %v", err)
+			http.Error(w, "Connection rejected: "+err.Error(), http.StatusForbidden)
+			return
+		}
 	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
+		if gameID != "" {
+			// on_connect already counted this socket
+			gamestate.MarkDisconnected(context.Background(), gameID, claims.UserID)
+		}
 		return
 	}
 
@@ -69,10 +89,14 @@ func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 		Send:     make(chan []byte, 256),
 		UserID:   claims.UserID,
 		Username: claims.Username,
+		Role:     claims.Role,
 		GameID:   gameID,
 	}
 
+	client.blocked.Store(loadBlocks(r.Context(), claims.UserID))
+
 	client.Hub.register <- client
+	social.Connected(context.Background(), claims.UserID)
 
 	go client.writePump()
 	go client.readPump()
@@ -84,8 +108,14 @@ func (c *Client) readPump() {
 		c.Hub.unregister <- c
 		c.Conn.Close()
 		metrics.ActiveConnections.Add(^int64(0))
+		social.Disconnected(context.Background(), c.UserID)
+
+		if c.GameID != "" {
+			// The lobby reaper removes the player if they don't reconnect in time
+			gamestate.MarkDisconnected(context.Background(), c.GameID, c.UserID)
+		}
 
-		if len(c.UserID) > 6 && c.UserID[:6] == "guest:" {
+		if gamestate.IsGuest(context.Background(), c.UserID) {
 			// Clean up guest data via Lua
 			gamestate.ExecuteScript(context.Background(), "on_disconnect", []string{}, c.UserID)
 		}
@@ -100,10 +130,23 @@ func (c *Client) readPump() {
 			break
 		}
 
+		if c.GameID == "" {
+			continue
+		}
+
 		// Process message execute Lua script
 		log.Printf("Player %s sent action: %s", c.Username, string(message))
 
 		gameKey := "game:" + c.GameID
+		if time.Since(c.lastActivity) > activityInterval {
+			gamestate.RDB.HSet(context.Background(), gameKey, "last_activity", time.Now().Unix())
+			c.lastActivity = time.Now()
+		}
+		// Actions run update_state, under the same rules as an RPC call to it
+		if err := gamestate.AuthorizeScript(c.Role, "update_state"); err != nil {
+			log.Printf("Action from %s rejected: %v", c.UserID, err)
+			continue
+		}
 		_, err = gamestate.ExecuteScript(context.Background(), "update_state", []string{gameKey}, c.Username, string(message))
 		if err != nil {
 			log.Printf("Error updating state: %v", err)
@@ -111,6 +154,27 @@ func (c *Client) readPump() {
 	}
 }
 
+func loadBlocks(ctx context.Context, userID string) *map[string]bool {
+	blocked := make(map[string]bool)
+	for _, id := range social.BlockedIDs(ctx, userID) {
+		blocked[id] = true
+	}
+	return &blocked
+}
+
+func (c *Client) hasBlocked(userID string) bool {
+	blocked := c.blocked.Load()
+	return blocked != nil && (*blocked)[userID]
+}
+
+// disconnect closes the socket with a reason the client can show. readPump
+// notices the closed connection and runs the usual cleanup.
+func (c *Client) disconnect(reason string) {
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	c.Conn.Close()
+}
+
 func (c *Client) writePump() {
 	ticker := time.NewTicker(50 * time.Millisecond) // hardcode 50ms default
 	defer func() {
@@ -2,7 +2,9 @@ package ws
 
 import (
 	"context"
+	"encoding/json"
 	"log"
+	"strings"
 	"sync"
 
 	"godra/internal/gamestate"
@@ -20,6 +22,7 @@ type Hub struct {
 	register   chan *Client
 	unregister chan *Client
 	rooms      map[string]*GameRoom
+	users      map[string]map[*Client]bool // UserID -> open sockets
 	mu         sync.Mutex
 }
 
@@ -29,17 +32,29 @@ func NewHub() *Hub {
 		unregister: make(chan *Client),
 		clients:    make(map[*Client]bool),
 		rooms:      make(map[string]*GameRoom),
+		users:      make(map[string]map[*Client]bool),
 	}
 }
 
 func (h *Hub) Run() {
+	go h.listenToUsers(context.Background())
+	go h.listenToControl(context.Background())
+	go h.listenToAll(context.Background())
+
 	for {
 		select {
 		case client := <-h.register:
 			h.mu.Lock()
 			h.clients[client] = true
-			room := h.getOrCreateRoom(client.GameID)
-			room.Clients[client] = true
+			if h.users[client.UserID] == nil {
+				h.users[client.UserID] = make(map[*Client]bool)
+			}
+			h.users[client.UserID][client] = true
+			// Clients connected without a game only receive user events
+			if client.GameID != "" {
+				room := h.getOrCreateRoom(client.GameID)
+				room.Clients[client] = true
+			}
 			h.mu.Unlock()
 
 		case client := <-h.unregister:
@@ -47,6 +62,12 @@ func (h *Hub) Run() {
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
 				close(client.Send)
+				if sockets, ok := h.users[client.UserID]; ok {
+					delete(sockets, client)
+					if len(sockets) == 0 {
+						delete(h.users, client.UserID)
+					}
+				}
 				if room, exists := h.rooms[client.GameID]; exists {
 					delete(room.Clients, client)
 					if len(room.Clients) == 0 {
@@ -96,8 +117,12 @@ func (r *GameRoom) listenToRedis(ctx context.Context) {
 			if msg == nil {
 				continue
 			}
-			// Broadcast to all clients in this room
+			// Broadcast to all clients in this room, except chat from players they blocked
+			sender := chatSender(msg.Payload)
 			for client := range r.Clients {
+				if sender != "" && client.hasBlocked(sender) {
+					continue
+				}
 				select {
 				case client.Send <- []byte(msg.Payload):
 				default:
@@ -108,3 +133,135 @@ func (r *GameRoom) listenToRedis(ctx context.Context) {
 		}
 	}
 }
+
+// chatSender returns the user_id of a "chat" message, or "" for anything else.
+func chatSender(payload string) string {
+	if !strings.Contains(payload, `"chat"`) {
+		return ""
+	}
+	var msg struct {
+		Type    string `json:"type"`
+		Payload struct {
+			UserID string `json:"user_id"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil || msg.Type != "chat" {
+		return ""
+	}
+	return msg.Payload.UserID
+}
+
+// listenToUsers forwards events published with gamestate.PublishToUser
+// to the target user's sockets on this node.
+func (h *Hub) listenToUsers(ctx context.Context) {
+	pubsub := gamestate.SubscribeToUsers(ctx)
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-ch:
+			if msg == nil {
+				continue
+			}
+			userID := strings.TrimPrefix(msg.Channel, "user_updates:")
+
+			h.mu.Lock()
+			for client := range h.users[userID] {
+				select {
+				case client.Send <- []byte(msg.Payload):
+				default:
+					// Slow client, drop the event rather than block other users
+				}
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// listenToAll forwards events published with gamestate.PublishToAll (global
+// chat) to every socket on this node.
+func (h *Hub) listenToAll(ctx context.Context) {
+	pubsub := gamestate.SubscribeToAll(ctx)
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-ch:
+			if msg == nil {
+				continue
+			}
+			sender := chatSender(msg.Payload)
+
+			h.mu.Lock()
+			for client := range h.clients {
+				if sender != "" && client.hasBlocked(sender) {
+					continue
+				}
+				select {
+				case client.Send <- []byte(msg.Payload):
+				default:
+					// Slow client, drop the event rather than block other users
+				}
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// listenToControl applies gamestate.ControlMessage commands to sockets on this node.
+func (h *Hub) listenToControl(ctx context.Context) {
+	pubsub := gamestate.SubscribeToControl(ctx)
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-ch:
+			if msg == nil {
+				continue
+			}
+			var ctrl gamestate.ControlMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &ctrl); err != nil {
+				log.Printf("Invalid control message: %v", err)
+				continue
+			}
+
+			switch ctrl.Type {
+			case "disconnect":
+				h.mu.Lock()
+				for client := range h.users[ctrl.UserID] {
+					if ctrl.GameID == "" || client.GameID == ctrl.GameID {
+						client.disconnect(ctrl.Reason)
+					}
+				}
+				h.mu.Unlock()
+
+			case "reload_blocks":
+				h.mu.Lock()
+				clients := make([]*Client, 0, len(h.users[ctrl.UserID]))
+				for client := range h.users[ctrl.UserID] {
+					clients = append(clients, client)
+				}
+				h.mu.Unlock()
+
+				if len(clients) > 0 {
+					blocked := loadBlocks(ctx, ctrl.UserID)
+					for _, client := range clients {
+						client.blocked.Store(blocked)
+					}
+				}
+			}
+		}
+	}
+}
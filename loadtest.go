@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"godra/internal/loadtest"
+)
+
+// runLoadTest implements the "godra loadtest" subcommand.
+func runLoadTest(args []string) {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+
+	opts := loadtest.Options{}
+	fs.StringVar(&opts.BaseURL, "url", "http://localhost:8080", "Godra server URL")
+	fs.IntVar(&opts.Lobbies, "lobbies", 10, "Number of lobbies to create")
+	fs.IntVar(&opts.PlayersPerLobby, "players", 4, "Guest clients per lobby")
+	fs.Float64Var(&opts.ActionRate, "rate", 1, "Actions per second, per client")
+	fs.DurationVar(&opts.Duration, "duration", 30*time.Second, "How long to send actions once connected")
+	fs.DurationVar(&opts.RampUp, "ramp-up", 5*time.Second, "Spread connection attempts over this window")
+	fs.DurationVar(&opts.Timeout, "timeout", 10*time.Second, "Per-request timeout")
+	fs.StringVar(&opts.RedisAddr, "redis-addr", getEnv("REDIS_ADDR", "localhost:6379"), "Redis address used to clean up test lobbies (empty to keep them)")
+	fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	log.Printf("Load testing %s: %d lobbies x %d players, %.2f actions/s each for %s",
+		opts.BaseURL, opts.Lobbies, opts.PlayersPerLobby, opts.ActionRate, opts.Duration)
+
+	report, err := loadtest.Run(ctx, opts)
+	if report != nil {
+		report.Print(os.Stdout)
+	}
+	if err != nil {
+		log.Fatalf("Load test failed: %v", err)
+	}
+}
@@ -4,20 +4,45 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"godra/internal/account"
 	"godra/internal/api"
+	"godra/internal/apikeys"
 	"godra/internal/auth"
+	"godra/internal/chat"
 	"godra/internal/database"
 	"godra/internal/gamestate"
+	"godra/internal/identity"
+	"godra/internal/lobby"
+	"godra/internal/matchmaker"
 	"godra/internal/metrics"
+	"godra/internal/moderation"
+	"godra/internal/party"
+	"godra/internal/privacy"
+	"godra/internal/profiles"
+	"godra/internal/ratings"
+	"godra/internal/rbac"
+	"godra/internal/social"
+	"godra/internal/storage"
 	"godra/internal/ws"
 )
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		runLoadTest(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "role" {
+		runRole(os.Args[2:])
+		return
+	}
+
 	// Init Logger
 	metrics.Init()
 
@@ -25,17 +50,151 @@ func main() {
 	cfg := Load()
 	log.Printf("Starting Godra Server on port %s...", cfg.Port)
 
+	// JWT signing keys
+	err := auth.ConfigureKeys(auth.KeyConfig{
+		Algorithm:      cfg.JWTAlgorithm,
+		Secret:         cfg.JWTSecret,
+		PrivateKeyFile: cfg.JWTPrivateKeyFile,
+		KeyID:          cfg.JWTKeyID,
+		Issuer:         cfg.JWTIssuer,
+		RandomSecret:   cfg.Dev,
+		VerifyKeyFiles: splitList(cfg.JWTVerifyKeys),
+		VerifySecrets:  splitList(cfg.JWTVerifySecrets),
+	})
+	if err != nil {
+		log.Fatalf("JWT key configuration failed: %v", err)
+	}
+	auth.AccessTokenTTL = time.Duration(cfg.AccessTokenTTL) * time.Second
+	auth.RefreshTokenTTL = time.Duration(cfg.RefreshTokenTTL) * time.Second
+	auth.LoginThrottle = auth.ThrottleConfig{
+		MaxFailures:   cfg.LoginMaxFailures,
+		MaxIPFailures: cfg.LoginMaxIPFailures,
+		Window:        time.Duration(cfg.LoginFailureWindow) * time.Second,
+		Lockout:       time.Duration(cfg.LoginLockout) * time.Second,
+		LockoutMax:    time.Duration(cfg.LoginLockoutMax) * time.Second,
+	}
+	auth.TrustProxy = cfg.TrustProxy
+	auth.TrustedProxyHops = cfg.TrustedProxyHops
+
+	// Roles and permissions
+	if cfg.RolesFile != "" {
+		if err := rbac.LoadFile(cfg.RolesFile); err != nil {
+			log.Fatalf("Role configuration failed: %v", err)
+		}
+	}
+
 	// Init DB
 	if err := database.Init(cfg.DBType, cfg.DBDSN); err != nil {
 		log.Fatalf("Database initialization failed: %v", err)
 	}
+	if cfg.AdminUsername != "" {
+		if err := auth.BootstrapAdmin(context.Background(), cfg.AdminUsername, cfg.AdminPassword); err != nil {
+			log.Fatalf("Bootstrap admin failed: %v", err)
+		}
+	}
 
 	// Init Redis
 	if err := gamestate.Init(cfg.RedisAddr); err != nil {
 		log.Fatalf("Redis initialization failed: %v", err)
 	}
+	// Needs Redis to sign the demoted users out
+	if err := auth.DemoteSelfGrantedManagers(context.Background()); err != nil {
+		log.Fatalf("Manager audit failed: %v", err)
+	}
 	// Start Cleanup Worker
 	gamestate.StartSessionCleaner(context.Background(), 5*time.Second, 10)
+	social.StartPresence(context.Background())
+	gamestate.StartLobbyReaper(context.Background(), time.Duration(cfg.LobbyReapInterval)*time.Second, gamestate.LobbyReaperConfig{
+		DisconnectGrace: time.Duration(cfg.LobbyDisconnectGrace) * time.Second,
+		EmptyTimeout:    time.Duration(cfg.LobbyEmptyTimeout) * time.Second,
+		IdleTimeout:     time.Duration(cfg.LobbyIdleTimeout) * time.Second,
+	})
+
+	// External identity providers
+	for _, name := range splitList(cfg.OIDCProviders) {
+		provider, err := identity.NewOIDC(context.Background(), oidcConfig(name))
+		if err != nil {
+			metrics.Log.Error("Identity provider disabled", "provider", name, "error", err)
+			continue
+		}
+		identity.Register(provider)
+	}
+	identity.ServerKey = cfg.ServerKey
+
+	// Bans and suspensions are checked at login and on every token
+	auth.AccessCheck = moderation.CheckAccess
+	if err := moderation.Sync(context.Background()); err != nil {
+		log.Fatalf("Sanction sync failed: %v", err)
+	}
+
+	// Two-factor authentication
+	auth.TwoFactorRoles = splitList(cfg.TwoFactorRoles)
+	auth.TOTPIssuer = cfg.TOTPIssuer
+
+	// Account email
+	mailer := cfg.Mailer
+	if mailer == "" {
+		mailer = "smtp"
+		if cfg.Dev {
+			mailer = "log"
+		}
+	}
+	switch mailer {
+	case "smtp":
+		account.Mail = &account.SMTPMailer{
+			Addr:     cfg.SMTPAddr,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		}
+	case "log":
+		if cfg.MailDir == "" {
+			metrics.Log.Warn("The log mailer only records recipients and subjects; set -mail-dir to keep the messages")
+		}
+		account.Mail = &account.LogMailer{Dir: cfg.MailDir, From: cfg.MailFrom}
+	default:
+		log.Fatalf("Unknown mailer %q (use log or smtp)", mailer)
+	}
+	account.ResetURL = cfg.PasswordResetURL
+	account.VerifyURL = cfg.VerifyEmailURL
+
+	// Parties
+	party.MaxSize = cfg.PartyMaxSize
+
+	// Profiles
+	profiles.MaxMetadataSize = cfg.ProfileMetadataMax
+
+	// Storage
+	storage.MaxValueSize = cfg.StorageMaxValueSize
+	storage.StartWorker(context.Background())
+
+	// Chat
+	chat.Configure(chat.Config{
+		HistorySize: cfg.ChatHistorySize,
+		MaxLength:   cfg.ChatMaxLength,
+		BannedWords: splitList(cfg.ChatBannedWords),
+		Archive:     cfg.ChatArchive,
+	})
+	chat.StartWorker(context.Background())
+
+	// Ratings
+	ratings.Configure(ratings.Config{
+		InitialRating: cfg.RatingInitial,
+		KFactor:       cfg.RatingKFactor,
+		Season:        cfg.RatingSeason,
+	})
+	ratings.StartResultWorker(context.Background())
+
+	// Start Matchmaker
+	matchmaker.Start(context.Background(), time.Duration(cfg.MatchInterval)*time.Millisecond, matchmaker.Rules{
+		PlayersPerMatch:   cfg.MatchPlayers,
+		InitialSkillRange: cfg.MatchSkillRange,
+		SkillRangeGrowth:  cfg.MatchSkillGrowth,
+		MaxSkillRange:     cfg.MatchSkillMax,
+		Modes:             splitList(cfg.MatchModes),
+		Regions:           splitList(cfg.MatchRegions),
+		TicketTTL:         time.Duration(cfg.MatchTicketTTL) * time.Second,
+	})
 
 	// Init WebSocket Hub
 	hub := ws.NewHub()
@@ -46,10 +205,112 @@ func main() {
 	r.Use(middleware.Recoverer)
 	r.Use(api.RequestLogger)
 
-	r.Post("/register", auth.RegisterHandler)
+	r.With(api.RateLimit("register", cfg.RegisterRateLimit, time.Minute)).Post("/register", auth.RegisterHandler)
 	r.Post("/login", auth.LoginHandler)
-	r.Post("/guest-login", auth.GuestLoginHandler)
+	r.With(api.RateLimit("guest_login", cfg.GuestLoginRateLimit, time.Minute)).Post("/guest-login", auth.GuestLoginHandler)
+	r.Post("/login/2fa", auth.TwoFactorLoginHandler)
+	r.Post("/login/2fa/enroll", auth.TwoFactorLoginEnrollHandler)
+	// Device logins create accounts too, so they share the registration budget
+	r.With(api.RateLimit("register", cfg.RegisterRateLimit, time.Minute)).Post("/login/device", identity.DeviceLoginHandler)
+	r.Post("/login/custom", identity.CustomLoginHandler)
+	r.Post("/token/refresh", auth.RefreshHandler)
+	r.With(api.RateLimit("password_reset", cfg.PasswordResetRateLimit, time.Minute)).Post("/password/reset", account.RequestResetHandler)
+	r.Post("/password/reset/confirm", account.ConfirmResetHandler)
+	r.Post("/email/verify", account.VerifyEmailHandler)
 	r.Post("/api/rpc", api.RPCHandler)
+	r.Get("/.well-known/jwks.json", auth.JWKSHandler)
+	r.Get("/auth/providers", identity.ProvidersHandler)
+	r.Get("/auth/{provider}/login", identity.LoginHandler)
+	r.Get("/auth/{provider}/callback", identity.CallbackHandler)
+
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireAuth)
+
+		r.Post("/logout", auth.LogoutHandler)
+		r.Post("/upgrade", auth.UpgradeHandler)
+		r.Get("/api/account", account.GetHandler)
+		r.Post("/api/account/password", account.ChangePasswordHandler)
+		r.Put("/api/account/email", account.SetEmailHandler)
+		r.Post("/api/account/email/verify", account.ResendVerificationHandler)
+		r.Get("/api/account/2fa", auth.TwoFactorStatusHandler)
+		r.Post("/api/account/2fa/enroll", auth.TwoFactorEnrollHandler)
+		r.Post("/api/account/2fa/confirm", auth.TwoFactorConfirmHandler)
+		r.Post("/api/account/2fa/recovery-codes", auth.RecoveryCodesHandler)
+		r.Delete("/api/account/2fa", auth.TwoFactorDisableHandler)
+		r.With(api.RateLimit("data_export", cfg.DataExportRateLimit, time.Minute)).Get("/api/account/export", privacy.ExportHandler)
+		r.Delete("/api/account", privacy.DeleteHandler)
+		r.Get("/api/profile", profiles.GetHandler)
+		r.Patch("/api/profile", profiles.UpdateHandler)
+		r.Get("/api/profiles/{userID}", profiles.GetUserHandler)
+		r.Post("/api/storage/read", storage.BatchReadHandler)
+		r.Post("/api/storage/write", storage.BatchWriteHandler)
+		r.Get("/api/storage/{collection}", storage.ListHandler)
+		r.Get("/api/storage/{collection}/{key}", storage.GetHandler)
+		r.Put("/api/storage/{collection}/{key}", storage.PutHandler)
+		r.Delete("/api/storage/{collection}/{key}", storage.DeleteHandler)
+		r.Get("/api/identities", identity.ListHandler)
+		r.Post("/api/identities/{provider}/link", identity.LinkHandler)
+		r.Delete("/api/identities/{provider}", identity.UnlinkHandler)
+		r.Post("/api/identities/device", identity.LinkDeviceHandler)
+		r.Delete("/api/identities/device/{deviceID}", identity.UnlinkDeviceHandler)
+		r.With(auth.RequirePermission("roles.manage")).Get("/api/admin/roles", auth.ListRolesHandler)
+		r.With(auth.RequirePermission("roles.manage")).Put("/api/admin/users/{userID}/role", auth.SetRoleHandler)
+		r.With(auth.RequirePermission("roles.manage")).Delete("/api/admin/users/{userID}/role", auth.RevokeRoleHandler)
+		r.With(auth.RequirePermission("roles.manage")).Delete("/api/admin/users/{userID}/2fa", auth.ResetTwoFactorHandler)
+		r.With(auth.RequirePermission("users.delete")).Delete("/api/admin/users/{userID}", privacy.AdminDeleteHandler)
+		r.With(auth.RequirePermission("audit.read")).Get("/api/admin/audit", auth.AuditHandler)
+		r.With(auth.RequirePermission("apikeys.manage")).Get("/api/admin/api-keys", apikeys.ListHandler)
+		r.With(auth.RequirePermission("apikeys.manage")).Post("/api/admin/api-keys", apikeys.CreateHandler)
+		r.With(auth.RequirePermission("apikeys.manage")).Delete("/api/admin/api-keys/{keyID}", apikeys.RevokeHandler)
+		r.With(auth.RequirePermission("sessions.revoke")).Post("/api/admin/users/{userID}/revoke-sessions", auth.RevokeSessionsHandler)
+
+		r.Post("/api/matchmaking/queue", matchmaker.EnqueueHandler)
+		r.Get("/api/matchmaking/queue", matchmaker.StatusHandler)
+		r.Delete("/api/matchmaking/queue", matchmaker.CancelHandler)
+
+		r.Get("/api/lobbies", lobby.ListHandler)
+		r.Get("/api/lobbies/{gameID}", lobby.GetHandler)
+		r.With(api.RateLimit("lobby_join", cfg.LobbyJoinRateLimit, time.Minute)).Post("/api/lobbies/{gameID}/join", lobby.JoinHandler)
+		r.Put("/api/lobbies/{gameID}/access", lobby.UpdateAccessHandler)
+		r.Post("/api/lobbies/{gameID}/invite", lobby.InviteHandler)
+		r.With(api.RateLimit("lobby_join", cfg.LobbyJoinRateLimit, time.Minute)).Get("/api/invites/{code}", lobby.ResolveInviteHandler)
+
+		r.Get("/api/friends", social.ListFriendsHandler)
+		r.Post("/api/friends/requests", social.SendRequestHandler)
+		r.Post("/api/friends/requests/{userID}/accept", social.AcceptRequestHandler)
+		r.Delete("/api/friends/requests/{userID}", social.DeclineRequestHandler)
+		r.Delete("/api/friends/{userID}", social.RemoveFriendHandler)
+		r.Get("/api/blocks", social.ListBlockedHandler)
+		r.Post("/api/blocks", social.BlockHandler)
+		r.Delete("/api/blocks/{userID}", social.UnblockHandler)
+
+		r.Get("/api/chat/{channel}/history", chat.HistoryHandler)
+		r.With(auth.RequirePermission("chat.send")).Post("/api/chat/{channel}", chat.SendHandler)
+		r.With(auth.RequirePermission("chat.moderate")).Delete("/api/chat/{channel}/messages/{messageID}", chat.DeleteMessageHandler)
+		r.With(auth.RequirePermission("chat.moderate")).Post("/api/chat/mutes", chat.MuteHandler)
+		r.Get("/api/account/sanctions", moderation.MineHandler)
+		r.With(auth.RequirePermission("chat.moderate")).Post("/api/admin/users/{userID}/sanctions", moderation.IssueHandler)
+		r.With(auth.RequirePermission("sanctions.read")).Get("/api/admin/users/{userID}/sanctions", moderation.HistoryHandler)
+		r.With(auth.RequirePermission("chat.moderate")).Delete("/api/admin/sanctions/{sanctionID}", moderation.LiftHandler)
+		r.With(auth.RequirePermission("chat.moderate")).Delete("/api/chat/mutes/{userID}", chat.UnmuteHandler)
+
+		r.Get("/api/party", party.GetHandler)
+		r.Post("/api/party", party.CreateHandler)
+		r.Post("/api/party/invite", party.InviteHandler)
+		r.Post("/api/party/accept", party.AcceptHandler)
+		r.Post("/api/party/decline", party.DeclineHandler)
+		r.Post("/api/party/leave", party.LeaveHandler)
+		r.Post("/api/party/kick", party.KickHandler)
+		r.Post("/api/party/leader", party.TransferHandler)
+		r.Post("/api/party/chat", party.ChatHandler)
+		r.Post("/api/party/join-lobby", party.JoinLobbyHandler)
+
+		r.Get("/api/ratings/{userID}", ratings.GetRatingsHandler)
+		r.Get("/api/ratings/{userID}/history", ratings.GetHistoryHandler)
+		r.Get("/api/leaderboard", ratings.LeaderboardHandler)
+
+		r.With(auth.RequirePermission("matches.report")).Post("/api/matches/result", ratings.ReportResultHandler)
+	})
 
 	r.Get("/metrics", metrics.Handler)
 
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"gorm.io/gorm/logger"
+
+	"godra/internal/auth"
+	"godra/internal/database"
+	"godra/internal/gamestate"
+	"godra/internal/metrics"
+	"godra/internal/rbac"
+)
+
+const roleUsage = `Usage:
+  godra role [flags] grant <username> <role>
+  godra role [flags] revoke <username>
+  godra role [flags] list`
+
+// runRole implements the "godra role" subcommand for granting and revoking
+// roles without going through the API.
+func runRole(args []string) {
+	fs := flag.NewFlagSet("role", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), roleUsage)
+		fs.PrintDefaults()
+	}
+
+	dbType := fs.String("db-type", getEnv("DB_TYPE", "sqlite"), "Database type: sqlite or postgres")
+	dbDSN := fs.String("db-dsn", getEnv("DB_DSN", "game.db"), "Database DSN")
+	redisAddr := fs.String("redis-addr", getEnv("REDIS_ADDR", "localhost:6379"), "Redis address, used to sign out demoted users")
+	rolesFile := fs.String("roles-file", getEnv("ROLES_FILE", ""), "JSON file with custom roles and permissions")
+	reason := fs.String("reason", "", "Reason recorded in the audit log")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	metrics.Init()
+
+	if *rolesFile != "" {
+		if err := rbac.LoadFile(*rolesFile); err != nil {
+			log.Fatalf("Role configuration failed: %v", err)
+		}
+	}
+	if err := database.Init(*dbType, *dbDSN); err != nil {
+		log.Fatalf("Database initialization failed: %v", err)
+	}
+	database.DB.Logger = logger.Discard
+
+	ctx := context.Background()
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+
+	switch {
+	case cmd == "list" && len(rest) == 0:
+		var users []database.User
+		if err := database.DB.Where("role <> ?", auth.DefaultRole).Order("role, username").Find(&users).Error; err != nil {
+			log.Fatalf("Failed to list users: %v", err)
+		}
+		for _, u := range users {
+			fmt.Printf("%d\t%s\t%s\n", u.ID, u.Username, u.Role)
+		}
+		return
+	case cmd == "grant" && len(rest) == 2, cmd == "revoke" && len(rest) == 1:
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var user database.User
+	if err := database.DB.Where("username = ?", rest[0]).First(&user).Error; err != nil {
+		log.Fatalf("User %q not found", rest[0])
+	}
+
+	role := auth.DefaultRole
+	if cmd == "grant" {
+		role = rest[1]
+		if !rbac.Exists(role) {
+			log.Fatalf("Unknown role %q (known: %v)", role, rbac.Names())
+		}
+	}
+
+	// Demotions revoke the user's sessions, which needs Redis
+	if err := gamestate.Connect(*redisAddr); err != nil {
+		log.Fatalf("Redis initialization failed: %v", err)
+	}
+
+	updated, err := auth.SetRole(ctx, user.ID, role, "cli", *reason)
+	if err != nil {
+		log.Fatalf("Failed to change role: %v", err)
+	}
+	fmt.Printf("%s (%d) is now %s\n", updated.Username, updated.ID, updated.Role)
+}
@@ -0,0 +1,57 @@
+-- close_lobby.lua
+-- INTERNAL
+-- Deletes a lobby, drops it from the lobby browser index and tells anyone still
+-- connected. Used by the lobby reaper for empty/idle lobbies.
+-- KEYS[1]: lobby_key (e.g. "game:123")
+-- ARGV[1]: user_id (caller)
+-- ARGV[2]: reason
+
+local lobby_key = KEYS[1]
+local reason = ARGV[2] or "closed"
+local lobby_id = string.gsub(lobby_key, "^game:", "")
+
+local fields = redis.call("HMGET", lobby_key, "status", "mode", "region", "invite_code")
+local status, mode, region, invite_code = fields[1], fields[2], fields[3], fields[4]
+
+if status then
+    redis.call("SREM", "lobbies:status:" .. status, lobby_id)
+    if mode and mode ~= "" then
+        redis.call("SREM", "lobbies:mode:" .. mode, lobby_id)
+    end
+    if region and region ~= "" then
+        redis.call("SREM", "lobbies:region:" .. region, lobby_id)
+    end
+else
+    -- The hash is gone, so drop the ID from every set it could be in
+    for _, s in ipairs({ "open", "ready_check", "starting", "in_progress", "finished", "closed" }) do
+        redis.call("SREM", "lobbies:status:" .. s, lobby_id)
+    end
+    for _, m in ipairs(redis.call("SMEMBERS", "lobbies:modes")) do
+        redis.call("SREM", "lobbies:mode:" .. m, lobby_id)
+    end
+    for _, r in ipairs(redis.call("SMEMBERS", "lobbies:regions")) do
+        redis.call("SREM", "lobbies:region:" .. r, lobby_id)
+    end
+end
+redis.call("SREM", "lobbies:public", lobby_id)
+redis.call("ZREM", "lobbies:index", lobby_id)
+redis.call("ZREM", "lobbies:free", lobby_id)
+
+for _, player in ipairs(redis.call("SMEMBERS", lobby_key .. ":players")) do
+    redis.call("SREM", "user:" .. player .. ":lobbies", lobby_id)
+end
+
+if invite_code then
+    redis.call("DEL", "lobby_invite:" .. invite_code)
+end
+redis.call("DEL", lobby_key, lobby_key .. ":players", lobby_key .. ":ready", lobby_key .. ":disconnected", lobby_key .. ":allowed", lobby_key .. ":connections")
+
+redis.call("PUBLISH", "game_updates:" .. lobby_key, cjson.encode({
+    type = "lobby_closed",
+    payload = {
+        game_id = lobby_id,
+        reason = reason
+    }
+}))
+
+return "OK"
@@ -1,25 +1,78 @@
 -- create_lobby.lua
 -- ROLE: guest
--- KEYS[1]: lobby_key (e.g. "game:123")
--- KEYS[2]: players_key (e.g. "game:123:players")
+-- KEYS[1]: lobby_key (optional, e.g. "game:123"); a new "game:<n>" ID is assigned when omitted
 -- ARGV[1]: user_id
 -- ARGV[2]: capacity
+-- ARGV[3]: mode (optional, used by the lobby browser)
+-- ARGV[4]: region (optional, used by the lobby browser)
+-- ARGV[5]: visibility (optional): "public" (default, listed), "unlisted" or "private"
 
+-- KEYS come from the client, so only a plain lobby key is accepted and every
+-- other key is derived from it; "game:<id>:players" or "game:<id>:grant:<uid>"
+-- would otherwise let the caller write into someone else's lobby
 local lobby_key = KEYS[1]
-local players_key = KEYS[2]
+if lobby_key == nil or lobby_key == "" then
+    lobby_key = "game:" .. redis.call("INCR", "lobbies:next_id")
+elseif not string.match(lobby_key, "^game:[%w_-]+$") then
+    return redis.error_reply("Invalid lobby key")
+end
+local players_key = lobby_key .. ":players"
 local user_id = ARGV[1]
-local capacity = tonumber(ARGV[2]) or 4
+local capacity = 4
+local max_capacity = 100
+local mode = ARGV[3] or ""
+local region = ARGV[4] or ""
+local visibility = ARGV[5] or "public"
+
+if ARGV[2] and ARGV[2] ~= "" then
+    capacity = tonumber(ARGV[2])
+    if not capacity or capacity ~= math.floor(capacity) or capacity < 1 or capacity > max_capacity then
+        return redis.error_reply("Capacity must be a whole number from 1 to " .. max_capacity)
+    end
+end
+
+if visibility ~= "public" and visibility ~= "unlisted" and visibility ~= "private" then
+    return redis.error_reply("Invalid visibility")
+end
 
 if redis.call("EXISTS", lobby_key) == 1 then
     return redis.error_reply("Lobby already exists")
 end
 
+local now = redis.call("TIME")[1]
+
 redis.call("HSET", lobby_key, "owner", user_id)
 redis.call("HSET", lobby_key, "capacity", capacity)
-redis.call("HSET", lobby_key, "created_at", redis.call("TIME")[1])
+redis.call("HSET", lobby_key, "created_at", now)
 redis.call("HSET", lobby_key, "status", "open")
+redis.call("HSET", lobby_key, "mode", mode)
+redis.call("HSET", lobby_key, "region", region)
+redis.call("HSET", lobby_key, "visibility", visibility)
+redis.call("HSET", lobby_key, "last_activity", now)
 
 redis.call("SADD", players_key, user_id)
+-- Disconnected until the owner's socket arrives (see join_lobby.lua)
+redis.call("ZADD", lobby_key .. ":disconnected", now, user_id)
+
+-- Lobby browser index (see internal/lobby/browser.go)
+local lobby_id = string.gsub(lobby_key, "^game:", "")
+redis.call("ZADD", "lobbies:index", now, lobby_id)
+if visibility == "public" then
+    redis.call("SADD", "lobbies:public", lobby_id)
+end
+redis.call("SADD", "lobbies:status:open", lobby_id)
+if mode ~= "" then
+    redis.call("SADD", "lobbies:mode:" .. mode, lobby_id)
+    redis.call("SADD", "lobbies:modes", mode)
+end
+if region ~= "" then
+    redis.call("SADD", "lobbies:region:" .. region, lobby_id)
+    redis.call("SADD", "lobbies:regions", region)
+end
+redis.call("ZADD", "lobbies:free", capacity - 1, lobby_id)
+
+-- Lobbies each player is in, for lobby.JoinedBy
+redis.call("SADD", "user:" .. user_id .. ":lobbies", lobby_id)
 
 -- Return the ID part (strip "lobby:") for convenience, or just return the key
 return lobby_key
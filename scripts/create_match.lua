@@ -0,0 +1,96 @@
+-- create_match.lua
+-- INTERNAL
+-- Claims a matchmaking group's tickets and creates its lobby in one step, so
+-- a ticket is never both cancelled and matched, and a lobby is never left
+-- behind for tickets that go back to the queue. Everything is checked before
+-- anything is written. The lobby is set up like create_lobby.lua and
+-- join_lobby.lua do; keep them in sync.
+-- KEYS[1]: lobby_key ("game:<id>")
+-- KEYS[2]: queue key ("mm:queue:<mode>:<region>")
+-- ARGV[1]: owner user_id
+-- ARGV[2]: capacity
+-- ARGV[3]: mode
+-- ARGV[4]: region
+-- ARGV[5]: seconds matched tickets stay readable
+-- ARGV[6..]: ticket IDs
+
+local lobby_key = KEYS[1]
+local queue_key = KEYS[2]
+local players_key = lobby_key .. ":players"
+local owner = ARGV[1]
+local capacity = tonumber(ARGV[2])
+local mode = ARGV[3] or ""
+local region = ARGV[4] or ""
+local ttl = tonumber(ARGV[5])
+local lobby_id = string.gsub(lobby_key, "^game:", "")
+
+if redis.call("EXISTS", lobby_key) == 1 then
+    return redis.error_reply("Lobby already exists")
+end
+
+-- Every ticket must still be waiting in this queue, and not past its expiry
+local now = redis.call("TIME")[1]
+local tickets = {}
+local players = {}
+for i = 6, #ARGV do
+    local id = ARGV[i]
+    local fields = redis.call("HMGET", "mm:ticket:" .. id, "status", "user_id", "members", "expires_at")
+    if fields[1] ~= "queued" or not redis.call("ZSCORE", queue_key, id) then
+        return redis.error_reply("Ticket no longer queued: " .. id)
+    end
+    if (tonumber(fields[4]) or 0) <= tonumber(now) then
+        return redis.error_reply("Ticket expired: " .. id)
+    end
+    local ok, members = pcall(cjson.decode, fields[3] or "")
+    if not ok or type(members) ~= "table" or #members == 0 then
+        members = { fields[2] }
+    end
+    table.insert(tickets, { id = id, user_id = fields[2] })
+    for _, member in ipairs(members) do
+        table.insert(players, member)
+    end
+end
+if #players > capacity then
+    return redis.error_reply("Group exceeds lobby capacity")
+end
+
+-- Lobby (see create_lobby.lua)
+redis.call("HSET", lobby_key,
+    "owner", owner,
+    "capacity", capacity,
+    "created_at", now,
+    "status", "open",
+    "mode", mode,
+    "region", region,
+    "visibility", "public",
+    "last_activity", now)
+for _, player in ipairs(players) do
+    redis.call("SADD", players_key, player)
+    -- Disconnected until their socket arrives (see join_lobby.lua)
+    redis.call("ZADD", lobby_key .. ":disconnected", now, player)
+    redis.call("SADD", "user:" .. player .. ":lobbies", lobby_id)
+end
+
+-- Lobby browser index (see internal/lobby/browser.go)
+redis.call("ZADD", "lobbies:index", now, lobby_id)
+redis.call("SADD", "lobbies:public", lobby_id)
+redis.call("SADD", "lobbies:status:open", lobby_id)
+if mode ~= "" then
+    redis.call("SADD", "lobbies:mode:" .. mode, lobby_id)
+    redis.call("SADD", "lobbies:modes", mode)
+end
+if region ~= "" then
+    redis.call("SADD", "lobbies:region:" .. region, lobby_id)
+    redis.call("SADD", "lobbies:regions", region)
+end
+redis.call("ZADD", "lobbies:free", capacity - redis.call("SCARD", players_key), lobby_id)
+
+-- Claim the tickets
+for _, t in ipairs(tickets) do
+    redis.call("ZREM", queue_key, t.id)
+    redis.call("HSET", "mm:ticket:" .. t.id, "status", "matched", "game_id", lobby_id)
+    redis.call("EXPIRE", "mm:ticket:" .. t.id, ttl)
+    redis.call("EXPIRE", "mm:user:" .. t.user_id, ttl)
+end
+
+return lobby_id
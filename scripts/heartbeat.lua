@@ -1,5 +1,5 @@
 -- heartbeat.lua
--- ROLE: player
+-- ROLE: guest
 -- KEYS: None (Dynamic)
 -- ARGV[1]: user_id
 
@@ -8,6 +8,11 @@ if not user_id then
     return redis.error_reply("User ID required")
 end
 
+-- Only guests have sessions to expire; registered users live in SQL
+if string.sub(user_id, 1, 6) ~= "guest:" then
+    return "OK"
+end
+
 -- 1. Update Sorted Set Score (Timestamp)
 local now = redis.call("TIME")[1]
 redis.call("ZADD", "active_sessions:guests", now, user_id)
@@ -1,4 +1,5 @@
 -- join_lobby.lua
+-- INTERNAL
 -- KEYS[1]: lobby_key (e.g. "lobby:xyz")
 -- ARGV[1]: user_id
 
@@ -13,14 +14,52 @@ end
 local capacity = tonumber(redis.call("HGET", lobby_key, "capacity"))
 local current_count = redis.call("SCARD", players_key)
 
+redis.call("HSET", lobby_key, "last_activity", redis.call("TIME")[1])
+
 -- Check if user is already in
 if redis.call("SISMEMBER", players_key, user_id) == 1 then
     return "OK" -- idempotent
 end
 
+-- Only members may (re)join once the match is under way
+local status = redis.call("HGET", lobby_key, "status")
+if status == "starting" or status == "in_progress" then
+    return redis.error_reply("Match already in progress")
+end
+if status == "finished" or status == "closed" then
+    return redis.error_reply("Lobby is closed")
+end
+
+if redis.call("EXISTS", lobby_key .. ":kicked:" .. user_id) == 1 then
+    return redis.error_reply("Kicked from this lobby")
+end
+
+-- Private and password protected lobbies need a grant from the server's access
+-- check (internal/lobby/access.go), which is consumed by the join
+local access = redis.call("HMGET", lobby_key, "visibility", "password_hash")
+local grant_key = lobby_key .. ":grant:" .. user_id
+if access[1] == "private" or (access[2] and access[2] ~= "") then
+    if redis.call("DEL", grant_key) == 0 then
+        return redis.error_reply("Access denied")
+    end
+end
+
 if current_count >= capacity then
     return redis.error_reply("Lobby is full")
 end
 
 redis.call("SADD", players_key, user_id)
+redis.call("HDEL", lobby_key, "empty_since")
+
+-- Counts as disconnected until a socket arrives (on_connect clears it), so the
+-- reaper frees the seat of a player who never connects
+if not redis.call("HGET", lobby_key .. ":connections", user_id) then
+    redis.call("ZADD", lobby_key .. ":disconnected", redis.call("TIME")[1], user_id)
+end
+
+-- Keep the lobby browser's free slot count in sync
+local lobby_id = string.gsub(lobby_key, "^game:", "")
+redis.call("ZADD", "lobbies:free", capacity - current_count - 1, lobby_id)
+redis.call("SADD", "user:" .. user_id .. ":lobbies", lobby_id)
+
 return "OK"
@@ -0,0 +1,75 @@
+-- leave_lobby.lua
+-- ROLE: guest
+-- KEYS[1]: lobby_key (e.g. "game:123")
+-- ARGV[1]: user_id
+
+local lobby_key = KEYS[1]
+local user_id = ARGV[1]
+local players_key = lobby_key .. ":players"
+
+if redis.call("EXISTS", lobby_key) == 0 then
+    return redis.error_reply("Lobby does not exist")
+end
+
+if redis.call("SREM", players_key, user_id) == 0 then
+    return "OK" -- idempotent
+end
+redis.call("HDEL", lobby_key .. ":ready", user_id)
+redis.call("ZREM", lobby_key .. ":disconnected", user_id)
+
+local lobby_id = string.gsub(lobby_key, "^game:", "")
+redis.call("SREM", "user:" .. user_id .. ":lobbies", lobby_id)
+
+local capacity = tonumber(redis.call("HGET", lobby_key, "capacity"))
+local current_count = redis.call("SCARD", players_key)
+local channel = "game_updates:" .. lobby_key
+local now = redis.call("TIME")[1]
+
+redis.call("HSET", lobby_key, "last_activity", now)
+if current_count == 0 then
+    -- The lobby reaper closes lobbies that stay empty
+    redis.call("HSET", lobby_key, "empty_since", now)
+end
+
+-- Keep the lobby browser's free slot count in sync
+redis.call("ZADD", "lobbies:free", capacity - current_count, lobby_id)
+
+redis.call("PUBLISH", channel, cjson.encode({
+    type = "player_left",
+    payload = {
+        game_id = lobby_id,
+        user_id = user_id
+    }
+}))
+
+-- Hand ownership to someone still in the lobby
+if redis.call("HGET", lobby_key, "owner") == user_id and current_count > 0 then
+    local new_owner = redis.call("SRANDMEMBER", players_key)
+    redis.call("HSET", lobby_key, "owner", new_owner)
+    redis.call("PUBLISH", channel, cjson.encode({
+        type = "owner_changed",
+        payload = {
+            game_id = lobby_id,
+            owner = new_owner,
+            previous = user_id
+        }
+    }))
+end
+
+-- A ready check can't complete with a different roster, start over
+if redis.call("HGET", lobby_key, "status") == "ready_check" then
+    redis.call("HSET", lobby_key, "status", "open")
+    redis.call("SMOVE", "lobbies:status:ready_check", "lobbies:status:open", lobby_id)
+    redis.call("DEL", lobby_key .. ":ready")
+    redis.call("PUBLISH", channel, cjson.encode({
+        type = "lobby_state",
+        payload = {
+            game_id = lobby_id,
+            status = "open",
+            previous = "ready_check",
+            by = user_id
+        }
+    }))
+end
+
+return "OK"
@@ -0,0 +1,74 @@
+-- lobby_kick.lua
+-- ROLE: guest
+-- Removes a player from the lobby and closes their socket. Owner only.
+-- The player can't rejoin for a few minutes (join_lobby.lua, on_connect.lua).
+-- KEYS[1]: lobby_key (e.g. "game:123")
+-- ARGV[1]: user_id
+-- ARGV[2]: target user_id
+
+local lobby_key = KEYS[1]
+local user_id = ARGV[1]
+local target = ARGV[2]
+local players_key = lobby_key .. ":players"
+local kick_ttl = 300 -- Seconds before a kicked player may rejoin
+
+if redis.call("EXISTS", lobby_key) == 0 then
+    return redis.error_reply("Lobby does not exist")
+end
+
+if redis.call("HGET", lobby_key, "owner") ~= user_id then
+    return redis.error_reply("Only the lobby owner can do that")
+end
+
+if target == user_id then
+    return redis.error_reply("Use leave_lobby to leave your own lobby")
+end
+
+if redis.call("SREM", players_key, target) == 0 then
+    return redis.error_reply("Player is not in this lobby")
+end
+redis.call("HDEL", lobby_key .. ":ready", target)
+redis.call("ZREM", lobby_key .. ":disconnected", target)
+redis.call("SET", lobby_key .. ":kicked:" .. target, 1, "EX", kick_ttl)
+
+local lobby_id = string.gsub(lobby_key, "^game:", "")
+redis.call("SREM", "user:" .. target .. ":lobbies", lobby_id)
+local capacity = tonumber(redis.call("HGET", lobby_key, "capacity"))
+redis.call("ZADD", "lobbies:free", capacity - redis.call("SCARD", players_key), lobby_id)
+redis.call("HSET", lobby_key, "last_activity", redis.call("TIME")[1])
+
+local channel = "game_updates:" .. lobby_key
+redis.call("PUBLISH", channel, cjson.encode({
+    type = "player_kicked",
+    payload = {
+        game_id = lobby_id,
+        user_id = target,
+        by = user_id
+    }
+}))
+
+-- A ready check can't complete with a different roster, start over
+if redis.call("HGET", lobby_key, "status") == "ready_check" then
+    redis.call("HSET", lobby_key, "status", "open")
+    redis.call("SMOVE", "lobbies:status:ready_check", "lobbies:status:open", lobby_id)
+    redis.call("DEL", lobby_key .. ":ready")
+    redis.call("PUBLISH", channel, cjson.encode({
+        type = "lobby_state",
+        payload = {
+            game_id = lobby_id,
+            status = "open",
+            previous = "ready_check",
+            by = user_id
+        }
+    }))
+end
+
+-- Close the kicked player's socket on whichever node holds it
+redis.call("PUBLISH", "user_control", cjson.encode({
+    type = "disconnect",
+    user_id = target,
+    game_id = lobby_id,
+    reason = "kicked"
+}))
+
+return "OK"
@@ -0,0 +1,62 @@
+-- lobby_ready.lua
+-- ROLE: guest
+-- Sets the caller's ready flag. When every player is ready during a ready check
+-- the lobby moves to "starting".
+-- KEYS[1]: lobby_key (e.g. "game:123")
+-- ARGV[1]: user_id
+-- ARGV[2]: "1" for ready, "0" for not ready
+
+local lobby_key = KEYS[1]
+local user_id = ARGV[1]
+local ready = ARGV[2] ~= "0"
+local players_key = lobby_key .. ":players"
+local ready_key = lobby_key .. ":ready"
+
+if redis.call("EXISTS", lobby_key) == 0 then
+    return redis.error_reply("Lobby does not exist")
+end
+
+if redis.call("SISMEMBER", players_key, user_id) == 0 then
+    return redis.error_reply("Not in this lobby")
+end
+
+local status = redis.call("HGET", lobby_key, "status")
+if status ~= "open" and status ~= "ready_check" then
+    return redis.error_reply("Ready flags can't change once the match is starting")
+end
+
+redis.call("HSET", lobby_key, "last_activity", redis.call("TIME")[1])
+if ready then
+    redis.call("HSET", ready_key, user_id, 1)
+else
+    redis.call("HDEL", ready_key, user_id)
+end
+
+local channel = "game_updates:" .. lobby_key
+local lobby_id = string.gsub(lobby_key, "^game:", "")
+
+redis.call("PUBLISH", channel, cjson.encode({
+    type = "player_ready",
+    payload = {
+        game_id = lobby_id,
+        user_id = user_id,
+        ready = ready
+    }
+}))
+
+if status == "ready_check" and redis.call("HLEN", ready_key) >= redis.call("SCARD", players_key) then
+    redis.call("HSET", lobby_key, "status", "starting")
+    redis.call("SMOVE", "lobbies:status:ready_check", "lobbies:status:starting", lobby_id)
+    redis.call("PUBLISH", channel, cjson.encode({
+        type = "lobby_state",
+        payload = {
+            game_id = lobby_id,
+            status = "starting",
+            previous = "ready_check",
+            by = user_id
+        }
+    }))
+    return "starting"
+end
+
+return status
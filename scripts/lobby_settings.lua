@@ -0,0 +1,74 @@
+-- lobby_settings.lua
+-- ROLE: guest
+-- Changes lobby settings while it's open. Owner only. Empty arguments are left unchanged.
+-- KEYS[1]: lobby_key (e.g. "game:123")
+-- ARGV[1]: user_id
+-- ARGV[2]: capacity
+-- ARGV[3]: mode
+-- ARGV[4]: region
+
+local lobby_key = KEYS[1]
+local user_id = ARGV[1]
+local capacity = tonumber(ARGV[2] or "")
+local mode = ARGV[3] or ""
+local region = ARGV[4] or ""
+local players_key = lobby_key .. ":players"
+local max_capacity = 100 -- Same limit as create_lobby.lua
+
+if redis.call("EXISTS", lobby_key) == 0 then
+    return redis.error_reply("Lobby does not exist")
+end
+
+if redis.call("HGET", lobby_key, "owner") ~= user_id then
+    return redis.error_reply("Only the lobby owner can do that")
+end
+
+if redis.call("HGET", lobby_key, "status") ~= "open" then
+    return redis.error_reply("Settings can only change while the lobby is open")
+end
+
+local lobby_id = string.gsub(lobby_key, "^game:", "")
+local current_count = redis.call("SCARD", players_key)
+
+if capacity then
+    if capacity ~= math.floor(capacity) or capacity < 1 or capacity > max_capacity then
+        return redis.error_reply("Capacity must be a whole number from 1 to " .. max_capacity)
+    end
+    if capacity < current_count then
+        return redis.error_reply("Capacity is below the current player count")
+    end
+    redis.call("HSET", lobby_key, "capacity", capacity)
+    redis.call("ZADD", "lobbies:free", capacity - current_count, lobby_id)
+end
+
+if mode ~= "" then
+    local old = redis.call("HGET", lobby_key, "mode")
+    if old and old ~= "" then
+        redis.call("SREM", "lobbies:mode:" .. old, lobby_id)
+    end
+    redis.call("HSET", lobby_key, "mode", mode)
+    redis.call("SADD", "lobbies:mode:" .. mode, lobby_id)
+    redis.call("SADD", "lobbies:modes", mode)
+end
+
+if region ~= "" then
+    local old = redis.call("HGET", lobby_key, "region")
+    if old and old ~= "" then
+        redis.call("SREM", "lobbies:region:" .. old, lobby_id)
+    end
+    redis.call("HSET", lobby_key, "region", region)
+    redis.call("SADD", "lobbies:region:" .. region, lobby_id)
+    redis.call("SADD", "lobbies:regions", region)
+end
+
+redis.call("PUBLISH", "game_updates:" .. lobby_key, cjson.encode({
+    type = "settings_changed",
+    payload = {
+        game_id = lobby_id,
+        capacity = tonumber(redis.call("HGET", lobby_key, "capacity")),
+        mode = redis.call("HGET", lobby_key, "mode"),
+        region = redis.call("HGET", lobby_key, "region")
+    }
+}))
+
+return "OK"
@@ -0,0 +1,35 @@
+-- lobby_transfer.lua
+-- ROLE: guest
+-- Hands lobby ownership to another player. Owner only.
+-- KEYS[1]: lobby_key (e.g. "game:123")
+-- ARGV[1]: user_id
+-- ARGV[2]: new owner user_id
+
+local lobby_key = KEYS[1]
+local user_id = ARGV[1]
+local new_owner = ARGV[2]
+
+if redis.call("EXISTS", lobby_key) == 0 then
+    return redis.error_reply("Lobby does not exist")
+end
+
+if redis.call("HGET", lobby_key, "owner") ~= user_id then
+    return redis.error_reply("Only the lobby owner can do that")
+end
+
+if redis.call("SISMEMBER", lobby_key .. ":players", new_owner) == 0 then
+    return redis.error_reply("New owner must be in the lobby")
+end
+
+redis.call("HSET", lobby_key, "owner", new_owner)
+
+redis.call("PUBLISH", "game_updates:" .. lobby_key, cjson.encode({
+    type = "owner_changed",
+    payload = {
+        game_id = string.gsub(lobby_key, "^game:", ""),
+        owner = new_owner,
+        previous = user_id
+    }
+}))
+
+return "OK"
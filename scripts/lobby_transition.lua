@@ -0,0 +1,59 @@
+-- lobby_transition.lua
+-- ROLE: guest
+-- Moves a lobby through its lifecycle. Owner only.
+--   open        -> ready_check, closed
+--   ready_check -> open (cancel), closed   (-> starting happens in lobby_ready.lua once everyone is ready)
+--   starting    -> in_progress, open (abort), closed
+--   in_progress -> finished, closed
+--   finished    -> open (rematch), closed
+-- KEYS[1]: lobby_key (e.g. "game:123")
+-- ARGV[1]: user_id
+-- ARGV[2]: target status
+
+local lobby_key = KEYS[1]
+local user_id = ARGV[1]
+local target = ARGV[2]
+
+local allowed = {
+    open = { ready_check = true, closed = true },
+    ready_check = { open = true, closed = true },
+    starting = { in_progress = true, open = true, closed = true },
+    in_progress = { finished = true, closed = true },
+    finished = { open = true, closed = true },
+}
+
+if redis.call("EXISTS", lobby_key) == 0 then
+    return redis.error_reply("Lobby does not exist")
+end
+
+if redis.call("HGET", lobby_key, "owner") ~= user_id then
+    return redis.error_reply("Only the lobby owner can do that")
+end
+
+local status = redis.call("HGET", lobby_key, "status")
+if not (allowed[status] and allowed[status][target]) then
+    return redis.error_reply("Cannot move lobby from " .. tostring(status) .. " to " .. tostring(target))
+end
+
+local lobby_id = string.gsub(lobby_key, "^game:", "")
+
+redis.call("HSET", lobby_key, "last_activity", redis.call("TIME")[1])
+redis.call("HSET", lobby_key, "status", target)
+redis.call("SMOVE", "lobbies:status:" .. status, "lobbies:status:" .. target, lobby_id)
+
+-- Every ready check starts from scratch
+if target == "open" or target == "ready_check" then
+    redis.call("DEL", lobby_key .. ":ready")
+end
+
+redis.call("PUBLISH", "game_updates:" .. lobby_key, cjson.encode({
+    type = "lobby_state",
+    payload = {
+        game_id = lobby_id,
+        status = target,
+        previous = status,
+        by = user_id
+    }
+}))
+
+return target
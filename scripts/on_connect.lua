@@ -1,4 +1,5 @@
 -- on_connect.lua
+-- INTERNAL
 -- Used to validate/join a lobby when a WebSocket connects
 -- KEYS[1]: lobby_key
 -- KEYS[2]: players_key
@@ -16,13 +17,51 @@ end
 local capacity = tonumber(redis.call("HGET", lobby_key, "capacity"))
 local current_count = redis.call("SCARD", players_key)
 
+redis.call("HSET", lobby_key, "last_activity", redis.call("TIME")[1])
+
 if redis.call("SISMEMBER", players_key, user_id) == 1 then
+    -- Reconnected within the grace period, the lobby reaper must not remove them
+    redis.call("ZREM", lobby_key .. ":disconnected", user_id)
+    redis.call("HINCRBY", lobby_key .. ":connections", user_id, 1)
+    redis.call("SADD", "user:" .. user_id .. ":lobbies", (string.gsub(lobby_key, "^game:", "")))
     return "OK"
 end
 
+-- Only members may (re)join once the match is under way
+local status = redis.call("HGET", lobby_key, "status")
+if status == "starting" or status == "in_progress" then
+    return redis.error_reply("Match already in progress")
+end
+if status == "finished" or status == "closed" then
+    return redis.error_reply("Lobby is closed")
+end
+
+if redis.call("EXISTS", lobby_key .. ":kicked:" .. user_id) == 1 then
+    return redis.error_reply("Kicked from this lobby")
+end
+
+-- Private and password protected lobbies need a grant from the server's access
+-- check (internal/lobby/access.go), which is consumed by the join
+local access = redis.call("HMGET", lobby_key, "visibility", "password_hash")
+local grant_key = lobby_key .. ":grant:" .. user_id
+if access[1] == "private" or (access[2] and access[2] ~= "") then
+    if redis.call("DEL", grant_key) == 0 then
+        return redis.error_reply("Access denied")
+    end
+end
+
 if current_count >= capacity then
     return redis.error_reply("Lobby is full")
 end
 
 redis.call("SADD", players_key, user_id)
+redis.call("HDEL", lobby_key, "empty_since")
+-- Open sockets, counted down by on_socket_close
+redis.call("HINCRBY", lobby_key .. ":connections", user_id, 1)
+
+-- Keep the lobby browser's free slot count in sync
+local lobby_id = string.gsub(lobby_key, "^game:", "")
+redis.call("ZADD", "lobbies:free", capacity - current_count - 1, lobby_id)
+redis.call("SADD", "user:" .. user_id .. ":lobbies", lobby_id)
+
 return "OK"
@@ -1,15 +1,18 @@
 -- on_disconnect.lua
+-- INTERNAL
 -- ARGV[1]: user_id
 
 local user_id = ARGV[1]
 
--- If it's a guest, delete the key
--- Note: Lua doesn't have "startswith" easily, but we trust the caller (Go server) only calls this for guests
--- Or we try to delete regardless. 
--- Since UserIDs for real users are ints (as strings "1", "2"), and guests are "guest:xyz",
--- deleting "1" might be bad if "1" is a key for something else.
--- But we don't use "1" as a key at root level for users in this schema (User data is in SQL).
--- So it is safe to try DEL user_id.
+-- Only guest records live at the root of the keyspace ("guest:xyz"). Registered
+-- users ("1", "2", ...) are in SQL, and guests that upgraded to an account
+-- (guest_alias:<id>) have already been moved by upgrade_guest.
+if string.sub(user_id, 1, 6) ~= "guest:" then
+    return "OK"
+end
+if redis.call("EXISTS", "guest_alias:" .. user_id) == 1 then
+    return "OK"
+end
 
 redis.call("DEL", user_id)
 return "OK"
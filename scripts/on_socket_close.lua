@@ -0,0 +1,32 @@
+-- on_socket_close.lua
+-- INTERNAL
+-- Called when a lobby socket closes. A player can have several sockets open
+-- on a lobby (tabs, devices, nodes); on_connect counts them in
+-- <lobby_key>:connections and the player is only marked disconnected, for the
+-- lobby reaper, once the last one is gone.
+-- KEYS[1]: lobby_key
+-- ARGV[1]: user_id
+
+local lobby_key = KEYS[1]
+local user_id = ARGV[1]
+
+-- Sockets opened before a guest upgrade still carry the guest ID, but their
+-- count moved to the new one (upgrade_guest.lua)
+local alias = redis.call("GET", "guest_alias:" .. user_id)
+if alias then
+    user_id = alias
+end
+local connections_key = lobby_key .. ":connections"
+
+local left = redis.call("HINCRBY", connections_key, user_id, -1)
+if left > 0 then
+    return left
+end
+redis.call("HDEL", connections_key, user_id)
+
+-- Players who left, were kicked or whose lobby closed have nothing to clean up
+if redis.call("SISMEMBER", lobby_key .. ":players", user_id) == 1 then
+    redis.call("ZADD", lobby_key .. ":disconnected", redis.call("TIME")[1], user_id)
+end
+
+return 0
@@ -0,0 +1,65 @@
+-- party_join_lobby.lua
+-- INTERNAL
+-- Adds a whole party to a lobby at once, only if there is room for everyone.
+-- Called by the server after it has checked the leader's access to the lobby.
+-- KEYS[1]: lobby_key (e.g. "game:123")
+-- ARGV[1]: leader user_id
+-- ARGV[2..n]: the other party members
+
+local lobby_key = KEYS[1]
+local leader = ARGV[1]
+local players_key = lobby_key .. ":players"
+
+if redis.call("EXISTS", lobby_key) == 0 then
+    return redis.error_reply("Lobby does not exist")
+end
+
+local status = redis.call("HGET", lobby_key, "status")
+if status ~= "open" and status ~= "ready_check" then
+    return redis.error_reply("Lobby is not accepting players")
+end
+
+-- Private and password protected lobbies need the leader's grant (internal/lobby/access.go)
+local access = redis.call("HMGET", lobby_key, "visibility", "password_hash")
+if access[1] == "private" or (access[2] and access[2] ~= "") then
+    if redis.call("SISMEMBER", players_key, leader) == 0 and redis.call("DEL", lobby_key .. ":grant:" .. leader) == 0 then
+        return redis.error_reply("Access denied")
+    end
+end
+
+-- Capacity is checked for everyone who isn't already in the lobby
+local joining = {}
+for i = 1, #ARGV do
+    if redis.call("SISMEMBER", players_key, ARGV[i]) == 0 then
+        if redis.call("EXISTS", lobby_key .. ":kicked:" .. ARGV[i]) == 1 then
+            return redis.error_reply("A party member was kicked from this lobby")
+        end
+        table.insert(joining, ARGV[i])
+    end
+end
+
+local capacity = tonumber(redis.call("HGET", lobby_key, "capacity"))
+local current_count = redis.call("SCARD", players_key)
+if current_count + #joining > capacity then
+    return redis.error_reply("Not enough room for the whole party")
+end
+
+local now = redis.call("TIME")[1]
+for _, user_id in ipairs(joining) do
+    redis.call("SADD", players_key, user_id)
+    -- Disconnected until their socket arrives (see join_lobby.lua)
+    if not redis.call("HGET", lobby_key .. ":connections", user_id) then
+        redis.call("ZADD", lobby_key .. ":disconnected", now, user_id)
+    end
+end
+redis.call("HDEL", lobby_key, "empty_since")
+redis.call("HSET", lobby_key, "last_activity", now)
+
+-- Keep the lobby browser's free slot count in sync
+local lobby_id = string.gsub(lobby_key, "^game:", "")
+redis.call("ZADD", "lobbies:free", capacity - current_count - #joining, lobby_id)
+for _, user_id in ipairs(joining) do
+    redis.call("SADD", "user:" .. user_id .. ":lobbies", lobby_id)
+end
+
+return #joining
@@ -0,0 +1,28 @@
+-- report_match.lua
+-- PERMISSION: matches.report
+-- Queues a finished match for rating updates (applied by the server's ratings worker)
+-- ARGV[1]: user_id (reporter)
+-- ARGV[2]: result JSON: {"game_id": "...", "mode": "...", "teams": [{"rank": 1, "players": ["1", "2"]}, ...]}
+
+local user_id = ARGV[1]
+local raw = ARGV[2]
+
+if not raw then
+    return redis.error_reply("Result required")
+end
+
+local ok, result = pcall(cjson.decode, raw)
+if not ok or type(result) ~= "table" then
+    return redis.error_reply("Result must be valid JSON")
+end
+
+if not result.game_id or not result.mode or type(result.teams) ~= "table" or #result.teams < 2 then
+    return redis.error_reply("Result needs a game_id, a mode and at least two teams")
+end
+
+redis.call("LPUSH", "ratings:results", cjson.encode({
+    reported_by = user_id,
+    result = result
+}))
+
+return "OK"
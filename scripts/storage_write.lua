@@ -0,0 +1,35 @@
+-- storage_write.lua
+-- Queues a write to the user's cloud storage (applied by the server's storage worker).
+-- A rejected write (version conflict, invalid value, ...) is reported to the
+-- user as a storage_write_failed event.
+-- ARGV[1]: user_id
+-- ARGV[2]: write JSON: {"collection": "...", "key": "...", "value": {...}, "version": 2, "permission": "public"}
+--          version and permission are optional; {"collection", "key", "delete": true} removes the object
+
+local user_id = ARGV[1]
+local raw = ARGV[2]
+
+if not raw then
+    return redis.error_reply("Write required")
+end
+
+local ok, write = pcall(cjson.decode, raw)
+if not ok or type(write) ~= "table" then
+    return redis.error_reply("Write must be valid JSON")
+end
+
+if type(write.collection) ~= "string" or type(write.key) ~= "string" then
+    return redis.error_reply("Write needs a collection and a key")
+end
+if not write.delete and type(write.value) ~= "table" then
+    return redis.error_reply("Value must be a JSON object")
+end
+
+-- Queue the caller's JSON untouched: re-encoding it with cjson would round
+-- numbers to 14 digits and turn [] into {}. The server validates it again.
+redis.call("LPUSH", "storage:writes", cjson.encode({
+    user_id = user_id,
+    raw = raw
+}))
+
+return "OK"
@@ -0,0 +1,113 @@
+-- upgrade_guest.lua
+-- INTERNAL
+-- Moves a guest's Redis state to their new registered user ID. Called by the
+-- server's /upgrade endpoint, not by clients.
+-- ARGV[1]: guest user_id ("guest:...")
+-- ARGV[2]: new user_id
+
+local old_id = ARGV[1]
+local new_id = ARGV[2]
+
+if not old_id or string.sub(old_id, 1, 6) ~= "guest:" or not new_id then
+    return redis.error_reply("Guest ID and new user ID required")
+end
+
+-- Moves a member of a set from old_id to new_id
+local function swap_set(key)
+    if redis.call("SREM", key, old_id) == 1 then
+        redis.call("SADD", key, new_id)
+        return true
+    end
+    return false
+end
+
+-- Moves a hash field from old_id to new_id
+local function swap_field(key)
+    local value = redis.call("HGET", key, old_id)
+    if value then
+        redis.call("HDEL", key, old_id)
+        redis.call("HSET", key, new_id, value)
+    end
+end
+
+local function rename_if_exists(from, to)
+    if redis.call("EXISTS", from) == 1 then
+        redis.call("RENAME", from, to)
+    end
+end
+
+-- 1. Lobbies the guest is a player in (user:<id>:lobbies, kept by the scripts
+-- that add and remove players)
+local lobbies = redis.call("SMEMBERS", "user:" .. old_id .. ":lobbies")
+for _, lobby_id in ipairs(lobbies) do
+    local lobby_key = "game:" .. lobby_id
+    swap_set(lobby_key .. ":players")
+    swap_set(lobby_key .. ":allowed")
+    swap_field(lobby_key .. ":ready")
+    swap_field(lobby_key .. ":teams")
+    -- The guest's open sockets now count for the new ID; on_socket_close
+    -- follows guest_alias when they close
+    swap_field(lobby_key .. ":connections")
+
+    local disconnected_at = redis.call("ZSCORE", lobby_key .. ":disconnected", old_id)
+    if disconnected_at then
+        redis.call("ZREM", lobby_key .. ":disconnected", old_id)
+        redis.call("ZADD", lobby_key .. ":disconnected", disconnected_at, new_id)
+    end
+
+    if redis.call("HGET", lobby_key, "owner") == old_id then
+        redis.call("HSET", lobby_key, "owner", new_id)
+    end
+    rename_if_exists(lobby_key .. ":grant:" .. old_id, lobby_key .. ":grant:" .. new_id)
+
+    redis.call("PUBLISH", "game_updates:" .. lobby_key, cjson.encode({
+        type = "player_renamed",
+        payload = {
+            game_id = lobby_id,
+            old_user_id = old_id,
+            user_id = new_id
+        }
+    }))
+end
+rename_if_exists("user:" .. old_id .. ":lobbies", "user:" .. new_id .. ":lobbies")
+
+-- 2. Party
+local party_id = redis.call("GET", "user:" .. old_id .. ":party")
+if party_id then
+    local party_key = "party:" .. party_id
+    swap_set(party_key .. ":members")
+    if redis.call("HGET", party_key, "leader") == old_id then
+        redis.call("HSET", party_key, "leader", new_id)
+    end
+    redis.call("RENAME", "user:" .. old_id .. ":party", "user:" .. new_id .. ":party")
+end
+
+-- 3. Matchmaking ticket
+local ticket_id = redis.call("GET", "mm:user:" .. old_id)
+if ticket_id then
+    local ticket_key = "mm:ticket:" .. ticket_id
+    if redis.call("HGET", ticket_key, "user_id") == old_id then
+        redis.call("HSET", ticket_key, "user_id", new_id)
+    end
+    local members_raw = redis.call("HGET", ticket_key, "members")
+    if members_raw then
+        local members = cjson.decode(members_raw)
+        for i, member in ipairs(members) do
+            if member == old_id then
+                members[i] = new_id
+            end
+        end
+        redis.call("HSET", ticket_key, "members", cjson.encode(members))
+    end
+    redis.call("RENAME", "mm:user:" .. old_id, "mm:user:" .. new_id)
+end
+
+-- 4. Chat mute
+rename_if_exists("chat:mute:" .. old_id, "chat:mute:" .. new_id)
+
+-- 5. Retire the guest: the session cleaner and on_disconnect skip aliased IDs
+redis.call("DEL", old_id, "guest:" .. old_id .. ":heartbeat")
+redis.call("ZREM", "active_sessions:guests", old_id)
+redis.call("SET", "guest_alias:" .. old_id, new_id, "EX", 86400)
+
+return #lobbies
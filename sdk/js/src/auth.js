@@ -10,7 +10,7 @@ export class AuthService {
             body: JSON.stringify({ username, password })
         });
         if (!response.ok) throw new Error('Login failed');
-        return await response.json(); // Returns { token: "..." }
+        return await response.json(); // Returns { token, refresh_token, expires_in, ... }, or { challenge_token, enroll_required } with 2FA
     }
 
     async register(username, password) {
@@ -28,6 +28,277 @@ export class AuthService {
             method: 'POST'
         });
         if (!response.ok) throw new Error('Guest login failed');
-        return await response.json(); // Returns { token, user_id, role }
+        return await response.json(); // Returns { token, refresh_token, expires_in, user_id, role }
+    }
+
+    // Keeps the guest's lobbies, party and queue; reconnect with the returned token
+    async upgrade(token, username, password) {
+        const response = await fetch(`${this.baseUrl}/upgrade`, {
+            method: 'POST',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({ username, password })
+        });
+        if (!response.ok) throw new Error('Upgrade failed');
+        return await response.json(); // Returns { token, refresh_token, expires_in, user_id, ... }
+    }
+
+    // deviceId is generated once by the client and kept across launches
+    async deviceLogin(deviceId, username) {
+        const response = await fetch(`${this.baseUrl}/login/device`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ device_id: deviceId, username })
+        });
+        if (!response.ok) throw new Error('Device login failed');
+        return await response.json(); // Returns { token, refresh_token, expires_in, user_id, ... }
+    }
+
+    async linkDevice(token, deviceId) {
+        const response = await fetch(`${this.baseUrl}/api/identities/device`, {
+            method: 'POST',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({ device_id: deviceId })
+        });
+        if (!response.ok) throw new Error('Link failed');
+        return await response.json();
+    }
+
+    async unlinkDevice(token, deviceId) {
+        const response = await fetch(`${this.baseUrl}/api/identities/device/${encodeURIComponent(deviceId)}`, {
+            method: 'DELETE',
+            headers: { 'Authorization': `Bearer ${token}` }
+        });
+        if (!response.ok) throw new Error('Unlink failed');
+    }
+
+    async providers() {
+        const response = await fetch(`${this.baseUrl}/auth/providers`);
+        if (!response.ok) throw new Error('Failed to list providers');
+        return await response.json(); // Returns ["studio", ...]
+    }
+
+    // Open the returned url; the provider redirects to /auth/{provider}/callback,
+    // which responds with the same body as login()
+    async providerLogin(provider) {
+        const response = await fetch(`${this.baseUrl}/auth/${encodeURIComponent(provider)}/login`, {
+            headers: { 'Accept': 'application/json' }
+        });
+        if (!response.ok) throw new Error('Provider login failed');
+        return await response.json(); // Returns { url, state }
+    }
+
+    async linkProvider(token, provider) {
+        const response = await fetch(`${this.baseUrl}/api/identities/${encodeURIComponent(provider)}/link`, {
+            method: 'POST',
+            headers: { 'Authorization': `Bearer ${token}` }
+        });
+        if (!response.ok) throw new Error('Link failed');
+        return await response.json(); // Returns { url, state }
+    }
+
+    async identities(token) {
+        const response = await fetch(`${this.baseUrl}/api/identities`, {
+            headers: { 'Authorization': `Bearer ${token}` }
+        });
+        if (!response.ok) throw new Error('Failed to list identities');
+        return await response.json(); // Returns [{ provider, subject, email, linked_at }]
+    }
+
+    async unlinkProvider(token, provider) {
+        const response = await fetch(`${this.baseUrl}/api/identities/${encodeURIComponent(provider)}`, {
+            method: 'DELETE',
+            headers: { 'Authorization': `Bearer ${token}` }
+        });
+        if (!response.ok) throw new Error('Unlink failed');
+    }
+
+    // Second login step, when login() returned a challenge_token
+    async twoFactorLogin(challengeToken, code) {
+        const response = await fetch(`${this.baseUrl}/login/2fa`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ challenge_token: challengeToken, code })
+        });
+        if (!response.ok) throw new Error('Two-factor login failed');
+        return await response.json(); // Returns the same body as login(), plus recovery_codes after enrolling
+    }
+
+    // For challenges with enroll_required; finish with twoFactorLogin()
+    async twoFactorLoginEnroll(challengeToken) {
+        const response = await fetch(`${this.baseUrl}/login/2fa/enroll`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ challenge_token: challengeToken })
+        });
+        if (!response.ok) throw new Error('Two-factor enrollment failed');
+        return await response.json(); // Returns { secret, uri }
+    }
+
+    async twoFactorStatus(token) {
+        const response = await fetch(`${this.baseUrl}/api/account/2fa`, {
+            headers: { 'Authorization': `Bearer ${token}` }
+        });
+        if (!response.ok) throw new Error('Failed to load two-factor status');
+        return await response.json(); // Returns { enabled, required, recovery_codes_left }
+    }
+
+    async enrollTwoFactor(token) {
+        const response = await fetch(`${this.baseUrl}/api/account/2fa/enroll`, {
+            method: 'POST',
+            headers: { 'Authorization': `Bearer ${token}` }
+        });
+        if (!response.ok) throw new Error('Two-factor enrollment failed');
+        return await response.json(); // Returns { secret, uri }
+    }
+
+    async confirmTwoFactor(token, code) {
+        return await this._twoFactorCode(token, 'POST', '/api/account/2fa/confirm', code); // Returns { recovery_codes }
+    }
+
+    async regenerateRecoveryCodes(token, code) {
+        return await this._twoFactorCode(token, 'POST', '/api/account/2fa/recovery-codes', code); // Returns { recovery_codes }
+    }
+
+    async disableTwoFactor(token, code) {
+        await this._twoFactorCode(token, 'DELETE', '/api/account/2fa', code);
+    }
+
+    async _twoFactorCode(token, method, path, code) {
+        const response = await fetch(`${this.baseUrl}${path}`, {
+            method,
+            headers: {
+                'Authorization': `Bearer ${token}`,
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({ code })
+        });
+        if (!response.ok) throw new Error('Two-factor request failed');
+        return response.status === 204 ? null : await response.json();
+    }
+
+    async account(token) {
+        const response = await fetch(`${this.baseUrl}/api/account`, {
+            headers: { 'Authorization': `Bearer ${token}` }
+        });
+        if (!response.ok) throw new Error('Failed to load account');
+        return await response.json(); // Returns { user_id, username, role, email, email_verified, has_password, ... }
+    }
+
+    async sanctions(token) {
+        const response = await fetch(`${this.baseUrl}/api/account/sanctions`, {
+            headers: { 'Authorization': `Bearer ${token}` }
+        });
+        if (!response.ok) throw new Error('Failed to load sanctions');
+        return await response.json(); // Returns [{ id, type, reason, expires_at, ... }]
+    }
+
+    async exportData(token) {
+        const response = await fetch(`${this.baseUrl}/api/account/export`, {
+            headers: { 'Authorization': `Bearer ${token}` }
+        });
+        if (!response.ok) throw new Error('Failed to export data');
+        return await response.json(); // Returns { account, identities, sessions, ratings, chat_archive, ... }
+    }
+
+    // Permanent. Pass the password, or the username for accounts without one
+    async deleteAccount(token, { password, username, reason } = {}) {
+        const response = await fetch(`${this.baseUrl}/api/account`, {
+            method: 'DELETE',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({ password, username, reason })
+        });
+        if (!response.ok) throw new Error('Account deletion failed');
+        return await response.json(); // Returns { receipt_id, user_id, removed, deleted_at, ... }
+    }
+
+    // Signs out every session; use the returned token pair from now on
+    async changePassword(token, currentPassword, newPassword) {
+        const response = await fetch(`${this.baseUrl}/api/account/password`, {
+            method: 'POST',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({ current_password: currentPassword, new_password: newPassword })
+        });
+        if (!response.ok) throw new Error('Password change failed');
+        return await response.json(); // Returns the same body as login()
+    }
+
+    async setEmail(token, email) {
+        const response = await fetch(`${this.baseUrl}/api/account/email`, {
+            method: 'PUT',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({ email })
+        });
+        if (!response.ok) throw new Error('Failed to set email');
+        return await response.json();
+    }
+
+    async resendVerification(token) {
+        const response = await fetch(`${this.baseUrl}/api/account/email/verify`, {
+            method: 'POST',
+            headers: { 'Authorization': `Bearer ${token}` }
+        });
+        if (!response.ok) throw new Error('Failed to send verification');
+    }
+
+    async verifyEmail(verifyToken) {
+        const response = await fetch(`${this.baseUrl}/email/verify`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ token: verifyToken })
+        });
+        if (!response.ok) throw new Error('Verification failed');
+        return await response.json();
+    }
+
+    // login is a username or email address
+    async requestPasswordReset(login) {
+        const response = await fetch(`${this.baseUrl}/password/reset`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ login })
+        });
+        if (!response.ok) throw new Error('Reset request failed');
+    }
+
+    async resetPassword(resetToken, newPassword) {
+        const response = await fetch(`${this.baseUrl}/password/reset/confirm`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ token: resetToken, new_password: newPassword })
+        });
+        if (!response.ok) throw new Error('Password reset failed');
+    }
+
+    async refresh(refreshToken) {
+        const response = await fetch(`${this.baseUrl}/token/refresh`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ refresh_token: refreshToken })
+        });
+        if (!response.ok) throw new Error('Refresh failed');
+        return await response.json(); // Returns { token, refresh_token, expires_in }
+    }
+
+    async logout(token) {
+        const response = await fetch(`${this.baseUrl}/logout`, {
+            method: 'POST',
+            headers: { 'Authorization': `Bearer ${token}` }
+        });
+        if (!response.ok) throw new Error('Logout failed');
     }
 }
@@ -0,0 +1,44 @@
+export class ChatService {
+    constructor(baseUrl) {
+        this.baseUrl = baseUrl;
+    }
+
+    // channel: 'global', 'room:<lobbyId>', 'team:<lobbyId>:<team>', 'party:<partyId>' or 'direct:<userId>'
+    async send(token, channel, message) {
+        return this.request(token, 'POST', `/api/chat/${encodeURIComponent(channel)}`, { message });
+    }
+
+    // Returns { messages, next_cursor }; pass next_cursor as before to page back
+    async history(token, channel, { before, limit } = {}) {
+        const params = new URLSearchParams();
+        if (before) params.set('before', before);
+        if (limit) params.set('limit', limit);
+        return this.request(token, 'GET', `/api/chat/${encodeURIComponent(channel)}/history?${params}`);
+    }
+
+    async deleteMessage(token, channel, messageId) {
+        return this.request(token, 'DELETE', `/api/chat/${encodeURIComponent(channel)}/messages/${messageId}`);
+    }
+
+    async mute(token, userId, duration, reason = '') {
+        return this.request(token, 'POST', '/api/chat/mutes', { user_id: userId, duration, reason });
+    }
+
+    async unmute(token, userId) {
+        return this.request(token, 'DELETE', `/api/chat/mutes/${encodeURIComponent(userId)}`);
+    }
+
+    async request(token, method, path, body) {
+        const response = await fetch(`${this.baseUrl}${path}`, {
+            method,
+            headers: {
+                'Authorization': `Bearer ${token}`,
+                'Content-Type': 'application/json'
+            },
+            body: body ? JSON.stringify(body) : undefined
+        });
+        if (!response.ok) throw new Error(await response.text());
+        if (response.status === 204) return null;
+        return await response.json();
+    }
+}
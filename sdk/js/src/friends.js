@@ -0,0 +1,52 @@
+export class FriendsService {
+    constructor(baseUrl) {
+        this.baseUrl = baseUrl;
+    }
+
+    async listFriends(token) {
+        return this.request(token, 'GET', '/api/friends');
+    }
+
+    // target: { userId } or { username }
+    async sendRequest(token, { userId, username }) {
+        return this.request(token, 'POST', '/api/friends/requests', { user_id: userId, username });
+    }
+
+    async acceptRequest(token, userId) {
+        return this.request(token, 'POST', `/api/friends/requests/${userId}/accept`);
+    }
+
+    async declineRequest(token, userId) {
+        return this.request(token, 'DELETE', `/api/friends/requests/${userId}`);
+    }
+
+    async removeFriend(token, userId) {
+        return this.request(token, 'DELETE', `/api/friends/${userId}`);
+    }
+
+    async listBlocked(token) {
+        return this.request(token, 'GET', '/api/blocks');
+    }
+
+    async block(token, { userId, username }) {
+        return this.request(token, 'POST', '/api/blocks', { user_id: userId, username });
+    }
+
+    async unblock(token, userId) {
+        return this.request(token, 'DELETE', `/api/blocks/${userId}`);
+    }
+
+    async request(token, method, path, body) {
+        const response = await fetch(`${this.baseUrl}${path}`, {
+            method,
+            headers: {
+                'Authorization': `Bearer ${token}`,
+                'Content-Type': 'application/json'
+            },
+            body: body ? JSON.stringify(body) : undefined
+        });
+        if (!response.ok) throw new Error(await response.text());
+        if (response.status === 204) return null;
+        return await response.json();
+    }
+}
@@ -1,11 +1,21 @@
 import { AuthService } from './auth.js';
+import { ChatService } from './chat.js';
+import { FriendsService } from './friends.js';
 import { LobbyService } from './lobby.js';
+import { PartyService } from './party.js';
+import { ProfilesService } from './profiles.js';
 import { RealtimeService } from './realtime.js';
+import { StorageService } from './storage.js';
 
 export class GodraClient {
     constructor(baseUrl) {
         this.auth = new AuthService(baseUrl);
         this.lobby = new LobbyService(baseUrl, this.auth);
+        this.chat = new ChatService(baseUrl);
+        this.friends = new FriendsService(baseUrl);
+        this.party = new PartyService(baseUrl);
+        this.profiles = new ProfilesService(baseUrl);
         this.realtime = new RealtimeService(baseUrl);
+        this.storage = new StorageService(baseUrl);
     }
 }
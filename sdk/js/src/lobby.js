@@ -3,7 +3,7 @@ export class LobbyService {
         this.baseUrl = baseUrl;
     }
 
-    async createLobby(token, maxPlayers) {
+    async createLobby(token, maxPlayers, lobbyId, { mode = '', region = '' } = {}) {
         const response = await fetch(`${this.baseUrl}/api/rpc`, {
             method: 'POST',
             headers: {
@@ -12,7 +12,8 @@ export class LobbyService {
             },
             body: JSON.stringify({
                 script: 'create_lobby',
-                args: [maxPlayers]
+                args: [maxPlayers, mode, region],
+                keys: lobbyId ? [`game:${lobbyId}`] : []
             })
         });
         if (!response.ok) throw new Error('Failed to create lobby');
@@ -20,10 +21,121 @@ export class LobbyService {
         return data.result; // Returns lobby_id
     }
 
-    async joinLobby(token, lobbyId) {
-        // Technically join is handled on WS connect for verification, 
-        // but we can check if it exists via RPC if needed.
-        // For now, this is a placeholder or can perform a "check_lobby" RPC.
+    // filters: { status, mode, region, minFree, sort, limit, cursor }
+    async listLobbies(token, filters = {}) {
+        const params = new URLSearchParams();
+        if (filters.status) params.set('status', filters.status);
+        if (filters.mode) params.set('mode', filters.mode);
+        if (filters.region) params.set('region', filters.region);
+        if (filters.minFree) params.set('min_free', filters.minFree);
+        if (filters.sort) params.set('sort', filters.sort);
+        if (filters.limit) params.set('limit', filters.limit);
+        if (filters.cursor) params.set('cursor', filters.cursor);
+
+        const response = await fetch(`${this.baseUrl}/api/lobbies?${params}`, {
+            headers: { 'Authorization': `Bearer ${token}` }
+        });
+        if (!response.ok) throw new Error('Failed to list lobbies');
+        return await response.json(); // Returns { lobbies, next_cursor }
+    }
+
+    async getLobby(token, lobbyId) {
+        const response = await fetch(`${this.baseUrl}/api/lobbies/${encodeURIComponent(lobbyId)}`, {
+            headers: { 'Authorization': `Bearer ${token}` }
+        });
+        if (!response.ok) throw new Error('Lobby not found');
+        return await response.json();
+    }
+
+    async joinLobby(token, lobbyId, { inviteCode = '', password = '' } = {}) {
+        // Checks access (private lobbies, passwords) and reserves a slot;
+        // the WebSocket connect to the same lobby completes the join.
+        const response = await fetch(`${this.baseUrl}/api/lobbies/${encodeURIComponent(lobbyId)}/join`, {
+            method: 'POST',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({ invite_code: inviteCode, password })
+        });
+        if (!response.ok) throw new Error(`Failed to join lobby: ${await response.text()}`);
+        return true;
+    }
+
+    // access: { visibility: 'public' | 'unlisted' | 'private', password, allowedUsers }
+    async setAccess(token, lobbyId, { visibility, password, allowedUsers } = {}) {
+        const response = await fetch(`${this.baseUrl}/api/lobbies/${encodeURIComponent(lobbyId)}/access`, {
+            method: 'PUT',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({ visibility, password, allowed_users: allowedUsers })
+        });
+        if (!response.ok) throw new Error(`Failed to update lobby access: ${await response.text()}`);
+        return await response.json(); // Returns { game_id, visibility, invite_code }
+    }
+
+    async resolveInvite(token, code) {
+        const response = await fetch(`${this.baseUrl}/api/invites/${encodeURIComponent(code)}`, {
+            headers: { 'Authorization': `Bearer ${token}` }
+        });
+        if (!response.ok) throw new Error('Invite not found');
+        return await response.json(); // Returns the lobby summary
+    }
+
+    async leaveLobby(token, lobbyId) {
+        const response = await fetch(`${this.baseUrl}/api/rpc`, {
+            method: 'POST',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({
+                script: 'leave_lobby',
+                args: [],
+                keys: [`game:${lobbyId}`]
+            })
+        });
+        if (!response.ok) throw new Error('Failed to leave lobby');
         return true;
     }
+
+    // Lifecycle: open -> ready_check -> starting -> in_progress -> finished, or closed.
+    // State changes arrive over the WebSocket as typed events
+    // (lobby_state, player_ready, player_kicked, owner_changed, settings_changed).
+
+    async setStatus(token, lobbyId, status) {
+        return this.rpc(token, 'lobby_transition', [`game:${lobbyId}`], [status]);
+    }
+
+    async setReady(token, lobbyId, ready = true) {
+        return this.rpc(token, 'lobby_ready', [`game:${lobbyId}`], [ready ? '1' : '0']);
+    }
+
+    async kick(token, lobbyId, userId) {
+        return this.rpc(token, 'lobby_kick', [`game:${lobbyId}`], [userId]);
+    }
+
+    async transferOwnership(token, lobbyId, userId) {
+        return this.rpc(token, 'lobby_transfer', [`game:${lobbyId}`], [userId]);
+    }
+
+    async updateSettings(token, lobbyId, { capacity = '', mode = '', region = '' } = {}) {
+        return this.rpc(token, 'lobby_settings', [`game:${lobbyId}`], [String(capacity), mode, region]);
+    }
+
+    async rpc(token, script, keys, args) {
+        const response = await fetch(`${this.baseUrl}/api/rpc`, {
+            method: 'POST',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({ script, args, keys })
+        });
+        if (!response.ok) throw new Error(`${script} failed: ${await response.text()}`);
+        const data = await response.json();
+        return data.result;
+    }
 }
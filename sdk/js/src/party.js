@@ -0,0 +1,64 @@
+export class PartyService {
+    constructor(baseUrl) {
+        this.baseUrl = baseUrl;
+    }
+
+    async getParty(token) {
+        return this.request(token, 'GET', '');
+    }
+
+    async createParty(token) {
+        return this.request(token, 'POST', '');
+    }
+
+    async invite(token, userId) {
+        return this.request(token, 'POST', '/invite', { user_id: userId });
+    }
+
+    async accept(token, partyId) {
+        return this.request(token, 'POST', '/accept', { party_id: partyId });
+    }
+
+    async decline(token, partyId) {
+        return this.request(token, 'POST', '/decline', { party_id: partyId });
+    }
+
+    async leave(token) {
+        return this.request(token, 'POST', '/leave');
+    }
+
+    async kick(token, userId) {
+        return this.request(token, 'POST', '/kick', { user_id: userId });
+    }
+
+    async transferLeader(token, userId) {
+        return this.request(token, 'POST', '/leader', { user_id: userId });
+    }
+
+    async chat(token, message) {
+        return this.request(token, 'POST', '/chat', { message });
+    }
+
+    // Members receive a party_follow event with the game_id to connect to
+    async joinLobby(token, lobbyId, { inviteCode = '', password = '' } = {}) {
+        return this.request(token, 'POST', '/join-lobby', {
+            game_id: lobbyId,
+            invite_code: inviteCode,
+            password
+        });
+    }
+
+    async request(token, method, path, body) {
+        const response = await fetch(`${this.baseUrl}/api/party${path}`, {
+            method,
+            headers: {
+                'Authorization': `Bearer ${token}`,
+                'Content-Type': 'application/json'
+            },
+            body: body ? JSON.stringify(body) : undefined
+        });
+        if (!response.ok) throw new Error(await response.text());
+        if (response.status === 204) return null;
+        return await response.json();
+    }
+}
@@ -0,0 +1,37 @@
+export class ProfilesService {
+    constructor(baseUrl) {
+        this.baseUrl = baseUrl;
+    }
+
+    async me(token) {
+        return this.request(token, 'GET', '/api/profile');
+    }
+
+    async get(token, userId) {
+        return this.request(token, 'GET', `/api/profiles/${userId}`);
+    }
+
+    // Only the given fields change: { displayName, avatarUrl, locale, metadata }.
+    // metadata replaces the whole object; null clears it.
+    async update(token, { displayName, avatarUrl, locale, metadata }) {
+        return this.request(token, 'PATCH', '/api/profile', {
+            display_name: displayName,
+            avatar_url: avatarUrl,
+            locale,
+            metadata
+        });
+    }
+
+    async request(token, method, path, body) {
+        const response = await fetch(`${this.baseUrl}${path}`, {
+            method,
+            headers: {
+                'Authorization': `Bearer ${token}`,
+                'Content-Type': 'application/json'
+            },
+            body: body ? JSON.stringify(body) : undefined
+        });
+        if (!response.ok) throw new Error(await response.text());
+        return await response.json();
+    }
+}
@@ -9,9 +9,11 @@ export class RealtimeService {
         this.heartbeatInterval = null;
     }
 
-    connect(token, lobbyId) {
+    connect(token, lobbyId, { invite = '', password = '' } = {}) {
         return new Promise((resolve, reject) => {
-            const url = `${this.baseUrl}/ws?token=${token}&game_id=${lobbyId}`;
+            let url = `${this.baseUrl}/ws?token=${token}&game_id=${lobbyId}`;
+            if (invite) url += `&invite=${encodeURIComponent(invite)}`;
+            if (password) url += `&password=${encodeURIComponent(password)}`;
             this.socket = new WebSocket(url);
 
             this.socket.onopen = () => {
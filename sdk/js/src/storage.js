@@ -0,0 +1,59 @@
+export class StorageService {
+    constructor(baseUrl) {
+        this.baseUrl = baseUrl;
+    }
+
+    // Pass the cursor from the previous page to continue. userId lists
+    // another player's public objects.
+    async list(token, collection, { userId, cursor, limit } = {}) {
+        const params = new URLSearchParams();
+        if (userId) params.set('user_id', userId);
+        if (cursor) params.set('cursor', cursor);
+        if (limit) params.set('limit', limit);
+        const query = params.toString() ? `?${params}` : '';
+        return this.request(token, 'GET', `/api/storage/${encodeURIComponent(collection)}${query}`);
+    }
+
+    async get(token, collection, key, userId) {
+        const query = userId ? `?user_id=${encodeURIComponent(userId)}` : '';
+        return this.request(token, 'GET', `${this.path(collection, key)}${query}`);
+    }
+
+    // version: only replace that version (0 only creates). permission: 'owner' or 'public'.
+    async put(token, collection, key, value, { version, permission } = {}) {
+        return this.request(token, 'PUT', this.path(collection, key), { value, version, permission });
+    }
+
+    async delete(token, collection, key, version) {
+        const query = version !== undefined ? `?version=${version}` : '';
+        return this.request(token, 'DELETE', `${this.path(collection, key)}${query}`);
+    }
+
+    // objects: [{ collection, key, user_id }]
+    async read(token, objects) {
+        return this.request(token, 'POST', '/api/storage/read', { objects });
+    }
+
+    // objects: [{ collection, key, value, version, permission }], written all or none.
+    async write(token, objects) {
+        return this.request(token, 'POST', '/api/storage/write', { objects });
+    }
+
+    path(collection, key) {
+        return `/api/storage/${encodeURIComponent(collection)}/${encodeURIComponent(key)}`;
+    }
+
+    async request(token, method, path, body) {
+        const response = await fetch(`${this.baseUrl}${path}`, {
+            method,
+            headers: {
+                'Authorization': `Bearer ${token}`,
+                'Content-Type': 'application/json'
+            },
+            body: body ? JSON.stringify(body) : undefined
+        });
+        if (!response.ok) throw new Error(await response.text());
+        if (response.status === 204) return null;
+        return await response.json();
+    }
+}